@@ -0,0 +1,189 @@
+package lazyhttp
+
+import (
+	"context"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// BulkheadConfig limits the number of requests in flight. A request is in
+// flight from the moment it is sent until its response body is closed.
+// Requests exceeding a limit are queued until a slot is free. A limit of 0
+// disables the limit.
+type BulkheadConfig struct {
+	MaxConcurrent        int           // max requests in flight for the whole client
+	MaxConcurrentPerHost int           // max requests in flight per host
+	MaxQueue             int           // max requests waiting for a slot, 0 allows an unlimited queue
+	MaxWait              time.Duration // max time a request waits for a slot, 0 waits as long as the request context allows
+}
+
+// BulkheadStats are the metrics of the bulkhead of a client.
+type BulkheadStats struct {
+	InFlight int64         // requests currently in flight
+	Queued   int64         // requests currently waiting for a slot
+	Admitted int64         // requests that acquired a slot
+	Rejected int64         // requests rejected because the queue was full or the wait timed out
+	Waited   time.Duration // total time admitted requests waited for a slot
+}
+
+type bulkhead struct {
+	conf   BulkheadConfig
+	client chan struct{} // nil if the client wide limit is disabled
+
+	mtx   sync.Mutex
+	hosts map[string]chan struct{}
+
+	inFlight atomic.Int64
+	queued   atomic.Int64
+	admitted atomic.Int64
+	rejected atomic.Int64
+	waited   atomic.Int64
+}
+
+func newBulkhead(conf BulkheadConfig) *bulkhead {
+	b := &bulkhead{
+		conf:  conf,
+		hosts: map[string]chan struct{}{},
+	}
+
+	if conf.MaxConcurrent > 0 {
+		b.client = make(chan struct{}, conf.MaxConcurrent)
+	}
+
+	return b
+}
+
+// host returns the semaphore of the given host or nil if the per host limit
+// is disabled.
+func (b *bulkhead) host(host string) chan struct{} {
+	if b.conf.MaxConcurrentPerHost <= 0 {
+		return nil
+	}
+
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+
+	sem, ok := b.hosts[host]
+	if !ok {
+		sem = make(chan struct{}, b.conf.MaxConcurrentPerHost)
+		b.hosts[host] = sem
+	}
+
+	return sem
+}
+
+// acquire waits for a slot for the given host. The host slot is acquired
+// first, so a slow host can not occupy the slots of the whole client while
+// waiting. The returned func releases the slots.
+func (b *bulkhead) acquire(ctx context.Context, host string) (func(), error) {
+	hostSem := b.host(host)
+
+	// fast path without queueing
+	if b.tryAcquire(hostSem) {
+		if b.tryAcquire(b.client) {
+			return b.admit(hostSem, 0), nil
+		}
+		releaseSlot(hostSem)
+	}
+
+	queued := b.queued.Add(1)
+	defer b.queued.Add(-1)
+
+	if b.conf.MaxQueue > 0 && queued > int64(b.conf.MaxQueue) {
+		b.rejected.Add(1)
+		return nil, BulkheadError{Host: host, Reason: BulkheadQueueFull}
+	}
+
+	if b.conf.MaxWait > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, b.conf.MaxWait)
+		defer cancel()
+	}
+
+	start := time.Now()
+	if err := b.wait(ctx, hostSem); err != nil {
+		b.rejected.Add(1)
+		return nil, BulkheadError{Host: host, Reason: BulkheadWaitTimeout, Waited: time.Since(start), Err: err}
+	}
+
+	if err := b.wait(ctx, b.client); err != nil {
+		releaseSlot(hostSem)
+		b.rejected.Add(1)
+		return nil, BulkheadError{Host: host, Reason: BulkheadWaitTimeout, Waited: time.Since(start), Err: err}
+	}
+
+	return b.admit(hostSem, time.Since(start)), nil
+}
+
+func (b *bulkhead) tryAcquire(sem chan struct{}) bool {
+	if sem == nil {
+		return true
+	}
+
+	select {
+	case sem <- struct{}{}:
+		return true
+	default:
+		return false
+	}
+}
+
+func (b *bulkhead) wait(ctx context.Context, sem chan struct{}) error {
+	if sem == nil {
+		return nil
+	}
+
+	select {
+	case sem <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func releaseSlot(sem chan struct{}) {
+	if sem != nil {
+		<-sem
+	}
+}
+
+// admit records the admission of a request and returns the func releasing its
+// slots. The func may be called multiple times.
+func (b *bulkhead) admit(hostSem chan struct{}, waited time.Duration) func() {
+	b.admitted.Add(1)
+	b.inFlight.Add(1)
+	b.waited.Add(int64(waited))
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			b.inFlight.Add(-1)
+			releaseSlot(b.client)
+			releaseSlot(hostSem)
+		})
+	}
+}
+
+func (b *bulkhead) stats() BulkheadStats {
+	return BulkheadStats{
+		InFlight: b.inFlight.Load(),
+		Queued:   b.queued.Load(),
+		Admitted: b.admitted.Load(),
+		Rejected: b.rejected.Load(),
+		Waited:   time.Duration(b.waited.Load()),
+	}
+}
+
+// releaseOnClose releases the bulkhead slot of a response once its body is
+// closed.
+type releaseOnClose struct {
+	io.ReadCloser
+	release func()
+}
+
+func (b *releaseOnClose) Close() error {
+	defer b.release()
+	return b.ReadCloser.Close()
+}
@@ -0,0 +1,174 @@
+package lazyhttp_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/niksteff/lazyhttp"
+)
+
+// TestBulkheadLimitsInFlight sends more requests than the bulkhead allows and
+// checks that the server never sees more concurrent requests than the limit.
+func TestBulkheadLimitsInFlight(t *testing.T) {
+	var current, peak atomic.Int32
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		n := current.Add(1)
+		defer current.Add(-1)
+
+		for {
+			p := peak.Load()
+			if n <= p || peak.CompareAndSwap(p, n) {
+				break
+			}
+		}
+
+		time.Sleep(50 * time.Millisecond)
+		w.WriteHeader(http.StatusOK)
+	})
+
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	addr, err := url.Parse(srv.URL)
+	if err != nil {
+		t.Errorf("did not expect error parsing url: %+v", err)
+		return
+	}
+
+	limit := 2
+	client := lazyhttp.New(
+		lazyhttp.WithHost(addr),
+		lazyhttp.WithBulkhead(lazyhttp.BulkheadConfig{
+			MaxConcurrentPerHost: limit,
+		}),
+	)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 6; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			req, err := http.NewRequestWithContext(context.Background(), http.MethodGet, "/", nil)
+			if err != nil {
+				t.Errorf("did not expect error creating request: %+v", err)
+				return
+			}
+
+			res, err := client.Do(req)
+			if err != nil {
+				t.Errorf("did not expect error making request: %+v", err)
+				return
+			}
+			lazyhttp.NoopBodyCloser(res.Body)
+		}()
+	}
+	wg.Wait()
+
+	if peak.Load() > int32(limit) {
+		t.Errorf("expected at most %d concurrent requests but got: %d", limit, peak.Load())
+	}
+
+	stats := client.BulkheadStats()
+	if stats.Admitted != 6 || stats.InFlight != 0 || stats.Rejected != 0 {
+		t.Errorf("unexpected bulkhead stats: %+v", stats)
+	}
+
+	if stats.Waited == 0 {
+		t.Errorf("expected requests to wait for a slot")
+	}
+}
+
+// TestBulkheadRejects checks that requests are rejected with a BulkheadError
+// once the queue is full or the wait timed out.
+func TestBulkheadRejects(t *testing.T) {
+	unblock := make(chan struct{})
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		<-unblock
+		w.WriteHeader(http.StatusOK)
+	})
+
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	addr, err := url.Parse(srv.URL)
+	if err != nil {
+		t.Errorf("did not expect error parsing url: %+v", err)
+		return
+	}
+
+	client := lazyhttp.New(
+		lazyhttp.WithHost(addr),
+		lazyhttp.WithBulkhead(lazyhttp.BulkheadConfig{
+			MaxConcurrent: 1,
+			MaxQueue:      1,
+			MaxWait:       100 * time.Millisecond,
+		}),
+	)
+
+	do := func() error {
+		req, err := http.NewRequestWithContext(context.Background(), http.MethodGet, "/", nil)
+		if err != nil {
+			return err
+		}
+
+		res, err := client.Do(req)
+		if err != nil {
+			return err
+		}
+		lazyhttp.NoopBodyCloser(res.Body)
+
+		return nil
+	}
+
+	// occupy the only slot
+	blocked := make(chan error, 1)
+	go func() {
+		blocked <- do()
+	}()
+
+	for client.BulkheadStats().InFlight != 1 {
+		time.Sleep(time.Millisecond)
+	}
+
+	// occupy the only queue slot until the wait times out
+	queued := make(chan error, 1)
+	go func() {
+		queued <- do()
+	}()
+
+	for client.BulkheadStats().Queued != 1 {
+		time.Sleep(time.Millisecond)
+	}
+
+	var bulkheadErr lazyhttp.BulkheadError
+	err = do()
+	if !errors.As(err, &bulkheadErr) || bulkheadErr.Reason != lazyhttp.BulkheadQueueFull {
+		t.Errorf("expected queue full BulkheadError but got: %+v", err)
+	}
+
+	err = <-queued
+	if !errors.As(err, &bulkheadErr) || bulkheadErr.Reason != lazyhttp.BulkheadWaitTimeout {
+		t.Errorf("expected wait timeout BulkheadError but got: %+v", err)
+	}
+
+	close(unblock)
+	if err := <-blocked; err != nil {
+		t.Errorf("did not expect error making request: %+v", err)
+	}
+
+	if client.BulkheadStats().Rejected != 2 {
+		t.Errorf("expected 2 rejections but got: %d", client.BulkheadStats().Rejected)
+	}
+}
@@ -0,0 +1,393 @@
+package lazyhttp
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// CircuitState is the state of the circuit for a single host.
+type CircuitState int
+
+const (
+	// CircuitClosed lets all requests pass and records their outcome.
+	CircuitClosed CircuitState = iota
+	// CircuitOpen rejects all requests until the open timeout passed.
+	CircuitOpen
+	// CircuitHalfOpen lets a limited number of probe requests pass to decide
+	// whether the host recovered.
+	CircuitHalfOpen
+)
+
+func (s CircuitState) String() string {
+	switch s {
+	case CircuitClosed:
+		return "closed"
+	case CircuitOpen:
+		return "open"
+	case CircuitHalfOpen:
+		return "half-open"
+	default:
+		return "unknown"
+	}
+}
+
+// CircuitBreakerConfig configures the per host circuit breaker of the client.
+// Zero values are replaced by sensible defaults, a threshold of 0 disables the
+// threshold.
+type CircuitBreakerConfig struct {
+	FailureRatio        float64                                  // ratio of failed requests in the window that opens the circuit
+	MinRequests         int                                      // minimum number of requests in the window before the failure ratio is considered
+	ConsecutiveFailures int                                      // number of consecutive failures that open the circuit
+	Window              time.Duration                            // length of the rolling window the failure ratio is calculated on
+	Buckets             int                                      // number of buckets the rolling window is divided into
+	OpenTimeout         time.Duration                            // time the circuit stays open before probe requests are let through
+	HalfOpenProbes      int                                      // max concurrent probes in half-open state, this many successes close the circuit again
+	IsFailure           func(res *http.Response, err error) bool // decides whether the outcome of a request counts as failure
+	OnStateChange       func(host string, from, to CircuitState) // called after the circuit of a host changed its state
+}
+
+// DefaultIsFailure counts transport errors and server errors (5xx) as
+// failures. Requests cancelled by the caller are never recorded, no matter
+// what the IsFailure function decides.
+func DefaultIsFailure(res *http.Response, err error) bool {
+	if err != nil {
+		return true
+	}
+
+	return res.StatusCode >= http.StatusInternalServerError
+}
+
+type circuitBucket struct {
+	start     time.Time
+	successes int
+	failures  int
+}
+
+// circuit holds the state of a single host
+type circuit struct {
+	state          CircuitState
+	generation     uint64 // incremented on every state change to detect stale permits
+	openedAt       time.Time
+	consecutive    int // consecutive failures in closed state
+	probes         int // probes in flight in half-open state
+	probeSuccesses int // successful probes in half-open state
+	buckets        []circuitBucket
+}
+
+type circuitTransition struct {
+	host     string
+	from, to CircuitState
+}
+
+type circuitBreaker struct {
+	conf CircuitBreakerConfig
+
+	mtx   sync.Mutex
+	hosts map[string]*circuit
+}
+
+func newCircuitBreaker(conf CircuitBreakerConfig) *circuitBreaker {
+	if conf.Window <= 0 {
+		conf.Window = 60 * time.Second
+	}
+
+	if conf.Buckets <= 0 {
+		conf.Buckets = 10
+	}
+
+	if conf.MinRequests <= 0 {
+		conf.MinRequests = 10
+	}
+
+	if conf.OpenTimeout <= 0 {
+		conf.OpenTimeout = 30 * time.Second
+	}
+
+	if conf.HalfOpenProbes <= 0 {
+		conf.HalfOpenProbes = 1
+	}
+
+	if conf.IsFailure == nil {
+		conf.IsFailure = DefaultIsFailure
+	}
+
+	// without any threshold the breaker would never open, so we fall back to
+	// a consecutive failure threshold
+	if conf.FailureRatio <= 0 && conf.ConsecutiveFailures <= 0 {
+		conf.ConsecutiveFailures = 5
+	}
+
+	return &circuitBreaker{
+		conf:  conf,
+		hosts: map[string]*circuit{},
+	}
+}
+
+// circuitPermit is handed out for every request that passed the circuit
+// breaker. The outcome of the request has to be reported with done or the
+// permit has to be released if the request never hit the network.
+type circuitPermit struct {
+	b          *circuitBreaker
+	host       string
+	generation uint64
+	probe      bool
+	finished   bool
+}
+
+// allow checks whether a request to the given host may pass. It returns a
+// CircuitOpenError if the circuit is open or all probes are in flight.
+func (b *circuitBreaker) allow(host string) (*circuitPermit, error) {
+	now := time.Now()
+
+	b.mtx.Lock()
+	c := b.circuit(host)
+
+	var transitions []circuitTransition
+	if c.state == CircuitOpen && now.Sub(c.openedAt) >= b.conf.OpenTimeout {
+		transitions = append(transitions, b.transition(host, c, CircuitHalfOpen, now))
+	}
+
+	var permit *circuitPermit
+	var err error
+	switch c.state {
+	case CircuitOpen:
+		err = CircuitOpenError{
+			Host:  host,
+			State: c.state,
+			Until: c.openedAt.Add(b.conf.OpenTimeout),
+		}
+	case CircuitHalfOpen:
+		if c.probes >= b.conf.HalfOpenProbes {
+			err = CircuitOpenError{
+				Host:  host,
+				State: c.state,
+			}
+			break
+		}
+
+		c.probes++
+		permit = &circuitPermit{b: b, host: host, generation: c.generation, probe: true}
+	default:
+		permit = &circuitPermit{b: b, host: host, generation: c.generation}
+	}
+	b.mtx.Unlock()
+
+	b.notify(transitions)
+
+	return permit, err
+}
+
+// check reports a CircuitOpenError like allow, but does not take a permit. It
+// lets requests fail fast before they wait for something else, e.g. the rate
+// limiter, and take their permit afterwards.
+func (b *circuitBreaker) check(host string) error {
+	now := time.Now()
+
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+
+	c := b.circuit(host)
+	switch c.state {
+	case CircuitOpen:
+		// the next call to allow turns the circuit half-open
+		if now.Sub(c.openedAt) >= b.conf.OpenTimeout {
+			return nil
+		}
+
+		return CircuitOpenError{
+			Host:  host,
+			State: c.state,
+			Until: c.openedAt.Add(b.conf.OpenTimeout),
+		}
+	case CircuitHalfOpen:
+		if c.probes >= b.conf.HalfOpenProbes {
+			return CircuitOpenError{
+				Host:  host,
+				State: c.state,
+			}
+		}
+	}
+
+	return nil
+}
+
+// done reports the outcome of the request the permit was issued for.
+func (p *circuitPermit) done(res *http.Response, err error) {
+	if p.finished {
+		return
+	}
+
+	// a request cancelled by the caller says nothing about the host
+	if errors.Is(err, context.Canceled) {
+		p.release()
+		return
+	}
+
+	p.finished = true
+	p.b.record(p, p.b.conf.IsFailure(res, err))
+}
+
+// release gives back the permit without recording an outcome. It is a no-op
+// if the outcome was already reported.
+func (p *circuitPermit) release() {
+	if p.finished {
+		return
+	}
+	p.finished = true
+
+	if !p.probe {
+		return
+	}
+
+	p.b.mtx.Lock()
+	c := p.b.circuit(p.host)
+	if c.generation == p.generation && c.probes > 0 {
+		c.probes--
+	}
+	p.b.mtx.Unlock()
+}
+
+func (b *circuitBreaker) record(p *circuitPermit, failed bool) {
+	now := time.Now()
+
+	b.mtx.Lock()
+	c := b.circuit(p.host)
+
+	// the permit was issued for a state the circuit already left, its outcome
+	// must not influence the current state.
+	if c.generation != p.generation {
+		b.mtx.Unlock()
+		return
+	}
+
+	var transitions []circuitTransition
+	switch c.state {
+	case CircuitClosed:
+		bucket := b.bucket(c, now)
+		if failed {
+			bucket.failures++
+			c.consecutive++
+		} else {
+			bucket.successes++
+			c.consecutive = 0
+		}
+
+		if failed && b.tripped(c, now) {
+			transitions = append(transitions, b.transition(p.host, c, CircuitOpen, now))
+		}
+	case CircuitHalfOpen:
+		c.probes--
+		if failed {
+			transitions = append(transitions, b.transition(p.host, c, CircuitOpen, now))
+			break
+		}
+
+		c.probeSuccesses++
+		if c.probeSuccesses >= b.conf.HalfOpenProbes {
+			transitions = append(transitions, b.transition(p.host, c, CircuitClosed, now))
+		}
+	}
+	b.mtx.Unlock()
+
+	b.notify(transitions)
+}
+
+// state returns the current state of the circuit for the given host.
+func (b *circuitBreaker) state(host string) CircuitState {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+
+	return b.circuit(host).state
+}
+
+// circuit returns the circuit of the given host and creates it if needed. The
+// caller must hold the lock.
+func (b *circuitBreaker) circuit(host string) *circuit {
+	c, ok := b.hosts[host]
+	if !ok {
+		c = &circuit{
+			state:   CircuitClosed,
+			buckets: make([]circuitBucket, b.conf.Buckets),
+		}
+		b.hosts[host] = c
+	}
+
+	return c
+}
+
+// bucket returns the bucket of the rolling window for the given time. Stale
+// buckets are reset before they are returned.
+func (b *circuitBreaker) bucket(c *circuit, now time.Time) *circuitBucket {
+	size := b.conf.Window / time.Duration(b.conf.Buckets)
+	if size <= 0 {
+		size = 1
+	}
+
+	start := now.Truncate(size)
+	bucket := &c.buckets[int(start.UnixNano()/int64(size))%len(c.buckets)]
+	if !bucket.start.Equal(start) {
+		*bucket = circuitBucket{start: start}
+	}
+
+	return bucket
+}
+
+// tripped reports whether the circuit has to be opened
+func (b *circuitBreaker) tripped(c *circuit, now time.Time) bool {
+	if b.conf.ConsecutiveFailures > 0 && c.consecutive >= b.conf.ConsecutiveFailures {
+		return true
+	}
+
+	if b.conf.FailureRatio <= 0 {
+		return false
+	}
+
+	var total, failures int
+	for _, bucket := range c.buckets {
+		if now.Sub(bucket.start) >= b.conf.Window {
+			continue
+		}
+
+		total += bucket.successes + bucket.failures
+		failures += bucket.failures
+	}
+
+	if total < b.conf.MinRequests {
+		return false
+	}
+
+	return float64(failures)/float64(total) >= b.conf.FailureRatio
+}
+
+// transition moves the circuit into the given state and resets all counters.
+// The caller must hold the lock.
+func (b *circuitBreaker) transition(host string, c *circuit, to CircuitState, now time.Time) circuitTransition {
+	t := circuitTransition{host: host, from: c.state, to: to}
+
+	c.state = to
+	c.generation++
+	c.consecutive = 0
+	c.probes = 0
+	c.probeSuccesses = 0
+	c.buckets = make([]circuitBucket, b.conf.Buckets)
+	if to == CircuitOpen {
+		c.openedAt = now
+	}
+
+	return t
+}
+
+// notify calls the state change callback for all transitions. It must not be
+// called while holding the lock so callbacks may use the client.
+func (b *circuitBreaker) notify(transitions []circuitTransition) {
+	if b.conf.OnStateChange == nil {
+		return
+	}
+
+	for _, t := range transitions {
+		b.conf.OnStateChange(t.host, t.from, t.to)
+	}
+}
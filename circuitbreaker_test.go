@@ -0,0 +1,392 @@
+package lazyhttp_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/niksteff/lazyhttp"
+)
+
+type countingRateLimiter struct {
+	mtx   sync.Mutex
+	calls int
+}
+
+func (l *countingRateLimiter) Wait(ctx context.Context) error {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+
+	l.calls++
+	return nil
+}
+
+// TestCircuitBreakerOpensOnConsecutiveFailures checks that the circuit opens
+// after the configured consecutive failures and that an open circuit neither
+// touches the network nor the rate limiter.
+func TestCircuitBreakerOpensOnConsecutiveFailures(t *testing.T) {
+	requestCount := 0
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		requestCount++
+		w.WriteHeader(http.StatusInternalServerError)
+	})
+
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	addr, err := url.Parse(srv.URL)
+	if err != nil {
+		t.Errorf("did not expect error parsing url: %+v", err)
+		return
+	}
+
+	type transition struct {
+		from, to lazyhttp.CircuitState
+	}
+	var transitions []transition
+
+	limiter := &countingRateLimiter{}
+	client := lazyhttp.New(
+		lazyhttp.WithHost(addr),
+		lazyhttp.WithRateLimiter(limiter),
+		lazyhttp.WithCircuitBreaker(lazyhttp.CircuitBreakerConfig{
+			ConsecutiveFailures: 3,
+			OpenTimeout:         time.Hour,
+			OnStateChange: func(host string, from, to lazyhttp.CircuitState) {
+				if host != addr.Host {
+					t.Errorf("expected host %s but got: %s", addr.Host, host)
+				}
+				transitions = append(transitions, transition{from, to})
+			},
+		}),
+	)
+
+	for i := 0; i < 3; i++ {
+		req, err := http.NewRequestWithContext(context.Background(), http.MethodGet, "/", nil)
+		if err != nil {
+			t.Errorf("did not expect error creating request: %+v", err)
+			return
+		}
+
+		res, err := client.Do(req)
+		if err != nil {
+			t.Errorf("did not expect error making request: %+v", err)
+			return
+		}
+		lazyhttp.NoopBodyCloser(res.Body)
+	}
+
+	if client.CircuitState(addr.Host) != lazyhttp.CircuitOpen {
+		t.Errorf("expected circuit to be open but got: %s", client.CircuitState(addr.Host))
+		return
+	}
+
+	req, err := http.NewRequestWithContext(context.Background(), http.MethodGet, "/", nil)
+	if err != nil {
+		t.Errorf("did not expect error creating request: %+v", err)
+		return
+	}
+
+	_, err = client.Do(req)
+	var openErr lazyhttp.CircuitOpenError
+	if !errors.As(err, &openErr) {
+		t.Errorf("expected CircuitOpenError but got: %+v", err)
+		return
+	}
+
+	if openErr.Host != addr.Host {
+		t.Errorf("expected host %s but got: %s", addr.Host, openErr.Host)
+	}
+
+	if requestCount != 3 {
+		t.Errorf("expected 3 requests to hit the server but got: %d", requestCount)
+	}
+
+	if limiter.calls != 3 {
+		t.Errorf("expected 3 rate limiter calls but got: %d", limiter.calls)
+	}
+
+	if len(transitions) != 1 || transitions[0] != (transition{lazyhttp.CircuitClosed, lazyhttp.CircuitOpen}) {
+		t.Errorf("expected a single closed -> open transition but got: %v", transitions)
+	}
+}
+
+// TestCircuitBreakerHalfOpenProbes checks that the circuit lets probes through
+// after the open timeout and closes again once enough probes succeeded.
+func TestCircuitBreakerHalfOpenProbes(t *testing.T) {
+	var mtx sync.Mutex
+	healthy := false
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		mtx.Lock()
+		defer mtx.Unlock()
+
+		if !healthy {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+	})
+
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	addr, err := url.Parse(srv.URL)
+	if err != nil {
+		t.Errorf("did not expect error parsing url: %+v", err)
+		return
+	}
+
+	var states []lazyhttp.CircuitState
+	client := lazyhttp.New(
+		lazyhttp.WithHost(addr),
+		lazyhttp.WithCircuitBreaker(lazyhttp.CircuitBreakerConfig{
+			ConsecutiveFailures: 1,
+			OpenTimeout:         50 * time.Millisecond,
+			HalfOpenProbes:      2,
+			OnStateChange: func(host string, from, to lazyhttp.CircuitState) {
+				states = append(states, to)
+			},
+		}),
+	)
+
+	do := func() error {
+		req, err := http.NewRequestWithContext(context.Background(), http.MethodGet, "/", nil)
+		if err != nil {
+			return err
+		}
+
+		res, err := client.Do(req)
+		if err != nil {
+			return err
+		}
+		lazyhttp.NoopBodyCloser(res.Body)
+
+		return nil
+	}
+
+	// the first failure opens the circuit
+	if err := do(); err != nil {
+		t.Errorf("did not expect error making request: %+v", err)
+		return
+	}
+
+	mtx.Lock()
+	healthy = true
+	mtx.Unlock()
+
+	time.Sleep(75 * time.Millisecond)
+
+	// two successful probes close the circuit again
+	for i := 0; i < 2; i++ {
+		if err := do(); err != nil {
+			t.Errorf("did not expect error making probe request: %+v", err)
+			return
+		}
+	}
+
+	if client.CircuitState(addr.Host) != lazyhttp.CircuitClosed {
+		t.Errorf("expected circuit to be closed but got: %s", client.CircuitState(addr.Host))
+	}
+
+	expected := []lazyhttp.CircuitState{lazyhttp.CircuitOpen, lazyhttp.CircuitHalfOpen, lazyhttp.CircuitClosed}
+	if len(states) != len(expected) {
+		t.Errorf("expected transitions %v but got: %v", expected, states)
+		return
+	}
+
+	for i := range expected {
+		if states[i] != expected[i] {
+			t.Errorf("expected transitions %v but got: %v", expected, states)
+			return
+		}
+	}
+}
+
+// blockingRateLimiter blocks the next call to Wait once it was armed until
+// it is unblocked
+type blockingRateLimiter struct {
+	mtx     sync.Mutex
+	armed   bool
+	waiting chan struct{}
+	unblock chan struct{}
+}
+
+func (l *blockingRateLimiter) Wait(ctx context.Context) error {
+	l.mtx.Lock()
+	armed := l.armed
+	l.armed = false
+	l.mtx.Unlock()
+
+	if !armed {
+		return nil
+	}
+
+	close(l.waiting)
+	select {
+	case <-l.unblock:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// TestCircuitBreakerProbeAfterRateLimiter checks that a request waiting for
+// the rate limiter does not hold the probe slot of a half-open circuit.
+func TestCircuitBreakerProbeAfterRateLimiter(t *testing.T) {
+	var mtx sync.Mutex
+	healthy := false
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		mtx.Lock()
+		defer mtx.Unlock()
+
+		if !healthy {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+	})
+
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	addr, err := url.Parse(srv.URL)
+	if err != nil {
+		t.Errorf("did not expect error parsing url: %+v", err)
+		return
+	}
+
+	limiter := &blockingRateLimiter{
+		waiting: make(chan struct{}),
+		unblock: make(chan struct{}),
+	}
+
+	client := lazyhttp.New(
+		lazyhttp.WithHost(addr),
+		lazyhttp.WithRateLimiter(limiter),
+		lazyhttp.WithCircuitBreaker(lazyhttp.CircuitBreakerConfig{
+			ConsecutiveFailures: 1,
+			OpenTimeout:         50 * time.Millisecond,
+			HalfOpenProbes:      1,
+		}),
+	)
+
+	do := func() error {
+		req, err := http.NewRequestWithContext(context.Background(), http.MethodGet, "/", nil)
+		if err != nil {
+			return err
+		}
+
+		res, err := client.Do(req)
+		if err != nil {
+			return err
+		}
+		lazyhttp.NoopBodyCloser(res.Body)
+
+		return nil
+	}
+
+	// the first failure opens the circuit
+	if err := do(); err != nil {
+		t.Errorf("did not expect error making request: %+v", err)
+		return
+	}
+
+	mtx.Lock()
+	healthy = true
+	mtx.Unlock()
+
+	time.Sleep(75 * time.Millisecond)
+
+	// the first request after the open timeout waits for the rate limiter
+	limiter.mtx.Lock()
+	limiter.armed = true
+	limiter.mtx.Unlock()
+
+	waiting := make(chan error, 1)
+	go func() {
+		waiting <- do()
+	}()
+	<-limiter.waiting
+
+	// the probe slot is still free for a request that passed the rate limiter
+	if err := do(); err != nil {
+		t.Errorf("did not expect error making probe request: %+v", err)
+	}
+
+	if client.CircuitState(addr.Host) != lazyhttp.CircuitClosed {
+		t.Errorf("expected circuit to be closed but got: %s", client.CircuitState(addr.Host))
+	}
+
+	close(limiter.unblock)
+	if err := <-waiting; err != nil {
+		t.Errorf("did not expect error for the waiting request: %+v", err)
+	}
+}
+
+// TestCircuitBreakerFailureRatio checks that the circuit opens once the failure
+// ratio in the rolling window exceeds the threshold.
+func TestCircuitBreakerFailureRatio(t *testing.T) {
+	requestCount := 0
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		requestCount++
+		if requestCount%2 == 0 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+	})
+
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	addr, err := url.Parse(srv.URL)
+	if err != nil {
+		t.Errorf("did not expect error parsing url: %+v", err)
+		return
+	}
+
+	client := lazyhttp.New(
+		lazyhttp.WithHost(addr),
+		lazyhttp.WithCircuitBreaker(lazyhttp.CircuitBreakerConfig{
+			FailureRatio: 0.5,
+			MinRequests:  4,
+			Window:       time.Minute,
+			OpenTimeout:  time.Hour,
+		}),
+	)
+
+	for i := 0; i < 4; i++ {
+		req, err := http.NewRequestWithContext(context.Background(), http.MethodGet, "/", nil)
+		if err != nil {
+			t.Errorf("did not expect error creating request: %+v", err)
+			return
+		}
+
+		res, err := client.Do(req)
+		if err != nil {
+			t.Errorf("did not expect error in request %d: %+v", i, err)
+			return
+		}
+		lazyhttp.NoopBodyCloser(res.Body)
+	}
+
+	if client.CircuitState(addr.Host) != lazyhttp.CircuitOpen {
+		t.Errorf("expected circuit to be open but got: %s", client.CircuitState(addr.Host))
+	}
+}
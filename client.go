@@ -23,21 +23,15 @@ package lazyhttp
 
 import (
 	"context"
-	"fmt"
+	"errors"
+	"net"
 	"net/http"
 	"net/url"
+	"sync"
 	"time"
-)
-
-type BackoffError struct {
-	Err error
-}
 
-func (e BackoffError) Error() string {
-	return fmt.Sprintf("error backing off: %s", e.Err)
-}
-
-var ErrMaxRetriesReached error = fmt.Errorf("max retries reached")
+	"github.com/niksteff/lazyhttp/ratelimit"
+)
 
 // Authenticator is an interface that can be implemented to authenticate a given
 // request. If the request could not be authenticated an error is returend.
@@ -57,6 +51,25 @@ type RateLimiter interface {
 	Wait(ctx context.Context) error
 }
 
+// RequestRateLimiter is a RateLimiter that needs the request to decide how to
+// limit it, e.g. to limit per host or per API key. If the configured rate
+// limiter implements this interface, the client calls WaitRequest instead of
+// Wait. WaitRequest is called after the pre request hooks and the
+// authenticator, so it sees the headers they set, while Wait is called before
+// them.
+type RequestRateLimiter interface {
+	RateLimiter
+	WaitRequest(ctx context.Context, req *http.Request) error
+}
+
+// ConcurrencyLimiter limits the number of requests in flight. Acquire is called
+// before a request is sent and blocks until the request is admitted, the
+// returned listener is told about the outcome of the request. The ratelimit pkg
+// provides adaptive implementations.
+type ConcurrencyLimiter interface {
+	Acquire(ctx context.Context) (ratelimit.Listener, error)
+}
+
 // Option implements the functional options pattern for the client
 type Option func(*client) *client
 
@@ -74,23 +87,40 @@ type PostResponseHook func(*http.Response) error
 
 type Config struct {
 	MaxRateLimiterWaitTime time.Duration
+	OperationTimeout       time.Duration // the time a call to Do may take including all retries, 0 means no limit
+	AttemptTimeout         time.Duration // the time a single attempt may take, 0 means no limit
+	MaxResponseSize        int64         // the max bytes of a response body, 0 means no limit
 }
 
 type client struct {
 	conf             Config
 	httpClient       *http.Client       // the underlying http client, this can be configured
+	customHTTPClient bool               // whether the http client was passed with WithHttpClient
 	rateLimiter      RateLimiter        // the rate limiter, this can be configured
 	preReqHooks      []PreRequestHook   // functions that are ran before the request is made
 	retryPolicy      RetryPolicy        // function that is ran after the response is received to decide if the request should be retried
+	retryPolicyName  string             // name of the retry policy func, recorded in the attempt history
 	newBackoffPolicy func() Backoff     // a function that returns a new instance of a backoff implementation
 	postRespHooks    []PostResponseHook // functions that are ran after the response is received
 	authenticator    Authenticator      // authenticator that is used to authenticate each request
 	host             *url.URL           // the host url that is used for all requests
+	circuitBreaker   *circuitBreaker    // per host circuit breaker in front of the http client
+	hedger           *hedger            // sends additional copies of slow requests
+	coalescer        *coalescer         // shares a single upstream call between identical requests
+	bulkhead         *bulkhead          // limits the number of requests in flight
+	concurrencyLimit ConcurrencyLimiter // adaptive limit of the requests in flight
+	statusErrors     *StatusErrorConfig // turns responses with an error status into a StatusError
+
+	mtx      sync.Mutex    // protects the lifecycle fields below
+	closed   bool          // a closed client rejects new requests
+	inflight int           // number of running calls to Do
+	idle     chan struct{} // closed once the client is closed and no call to Do is running
 }
 
 func WithHttpClient(httpClient *http.Client) Option {
 	return func(c *client) *client {
 		c.httpClient = httpClient
+		c.customHTTPClient = true
 		return c
 	}
 }
@@ -141,9 +171,14 @@ func WithHost(host *url.URL) Option {
 // it decides whether to retry the request based on the response. You have to
 // implement this hook yourself. The pkg provides a basic NoopRetryHook that
 // will never perform a retry.
+//
+// A request with a body that can not be replayed, i.e. without GetBody, is
+// never retried. The response the retry policy rejected is then returned
+// without an error, so check its status code or use WithStatusErrors.
 func WithRetryPolicy(hook RetryPolicy) Option {
 	return func(c *client) *client {
 		c.retryPolicy = hook
+		c.retryPolicyName = funcName(hook)
 		return c
 	}
 }
@@ -159,6 +194,119 @@ func WithBackoffPolicy(backoff func() Backoff) Option {
 	}
 }
 
+// WithOperationTimeout sets the time a call to Do may take in total. The
+// deadline covers waiting for the rate limiter, all attempts and the backoffs
+// between them as well as reading the body of the returned response. A
+// shorter deadline of the request context still applies.
+func WithOperationTimeout(d time.Duration) Option {
+	return func(c *client) *client {
+		c.conf.OperationTimeout = d
+		return c
+	}
+}
+
+// WithMaxResponseSize limits the body of every response received, including
+// responses handed out with a RetryExhaustedError, the start of the body kept
+// in a StatusError and bodies shared by request coalescing. Reading beyond the
+// limit fails with ErrBodyTooLarge, also in post response hooks.
+func WithMaxResponseSize(n int64) Option {
+	return func(c *client) *client {
+		c.conf.MaxResponseSize = n
+		return c
+	}
+}
+
+// WithAttemptTimeout sets the time a single attempt may take including reading
+// its response body. An attempt that times out is not retried, as the retry
+// policy only decides on responses.
+func WithAttemptTimeout(d time.Duration) Option {
+	return func(c *client) *client {
+		c.conf.AttemptTimeout = d
+		return c
+	}
+}
+
+// WithCircuitBreaker enables a per host circuit breaker in front of the
+// underlying http client. While the circuit of a host is open, requests to that
+// host fail fast with a CircuitOpenError without waiting for the rate limiter
+// or touching the network.
+func WithCircuitBreaker(conf CircuitBreakerConfig) Option {
+	return func(c *client) *client {
+		c.circuitBreaker = newCircuitBreaker(conf)
+		return c
+	}
+}
+
+// WithHedging enables hedged requests. If a hedgeable request did not receive a
+// response within the hedging delay, another copy is sent. The first response
+// wins and all other copies are cancelled. Only idempotent requests should be
+// hedged, by default only GET and HEAD requests without a body are. Hedges
+// wait for the rate limiter, but share the bulkhead slot, the circuit breaker
+// permit and the concurrency limiter slot of the request they copy, so a
+// single slot may cover up to MaxHedges+1 connections.
+func WithHedging(conf HedgingConfig) Option {
+	return func(c *client) *client {
+		c.hedger = newHedger(conf)
+		return c
+	}
+}
+
+// WithRequestCoalescing lets concurrent identical requests share a single
+// upstream call. Each caller receives its own copy of the response with a
+// readable body. The key function decides which requests are identical, if it
+// is nil requests are identical if they share the method, the url and the
+// Authorization and Cookie headers, see CoalesceKey. A custom key function
+// must tell requests with different credentials apart itself. Only safe
+// methods like GET and HEAD are ever coalesced.
+func WithRequestCoalescing(key CoalesceKeyFunc) Option {
+	return func(c *client) *client {
+		c.coalescer = newCoalescer(key)
+		return c
+	}
+}
+
+// WithBulkhead limits the number of requests in flight per client and per
+// host, so a slow dependency can not exhaust goroutines and sockets. Requests
+// exceeding the limits are queued, if the queue is full or the wait takes too
+// long, the request fails with a BulkheadError.
+func WithBulkhead(conf BulkheadConfig) Option {
+	return func(c *client) *client {
+		c.bulkhead = newBulkhead(conf)
+		return c
+	}
+}
+
+// WithStatusErrors makes Do return a StatusError for responses with an error
+// status, by default every status that is not 2xx. The error carries the
+// status, headers and the start of the body. The body of the response is
+// closed, the response returned with the error replays the start of the body.
+func WithStatusErrors(conf StatusErrorConfig) Option {
+	return func(c *client) *client {
+		if conf.IsError == nil {
+			conf.IsError = IsErrorStatus
+		}
+
+		if conf.MaxBodySize <= 0 {
+			conf.MaxBodySize = 1 << 10
+		}
+
+		c.statusErrors = &conf
+		return c
+	}
+}
+
+// WithConcurrencyLimiter sets a limiter for the number of requests in flight.
+// Every attempt is admitted by the limiter before it is sent and holds its
+// slot until the response body is closed. Timeouts and responses with status
+// 429 or 503 are reported as dropped, other errors and server errors as
+// failure and everything else as success.
+func WithConcurrencyLimiter(limiter ConcurrencyLimiter) Option {
+	return func(c *client) *client {
+		c.concurrencyLimit = limiter
+		return c
+	}
+}
+
 // New creates a new client with the given options. If no options are
 // given sensible defaults are selected.
 func New(opts ...Option) *client {
@@ -187,41 +335,70 @@ func New(opts ...Option) *client {
 }
 
 func (c *client) Do(req *http.Request) (*http.Response, error) {
-	// first the get the context from the request so we operate on the same
-	ctx := req.Context()
+	// a closed client does not accept any new requests
+	if !c.enter() {
+		return nil, ErrClientClosed
+	}
+	defer c.leave()
+
+	if c.conf.OperationTimeout <= 0 {
+		return c.do(req)
+	}
+
+	// the operation deadline covers the rate limiter, all attempts and the
+	// backoffs between them. It ends once the body of the returned response
+	// is closed, so the body can still be read after Do returned.
+	ctx, cancel := context.WithTimeout(req.Context(), c.conf.OperationTimeout)
+	res, err := c.do(req.WithContext(ctx))
+	if res == nil || res.Body == nil {
+		cancel()
+		return res, err
+	}
+	res.Body = &cancelOnClose{ReadCloser: res.Body, cancel: cancel}
+
+	return res, err
+}
 
-	// if a rate limiter is set we got to wait for allowance. Run the
-	// ratelimiter before everything else because of there is no free token we
-	// do not bother.
-	if c.rateLimiter != nil {
-		// if the given context has no deadline we se the default deadline from
-		// the client to protect the user from never ending waits.
-		_, ok := req.Context().Deadline()
-		if !ok {
-			// wrap the request context with a deadline to provide a timeout
-			// for rate limit waits even if the user does not provide a deadline in
-			// the context.
-			var cancel context.CancelFunc
-			ctx, cancel = context.WithDeadline(req.Context(), time.Now().Add(c.conf.MaxRateLimiterWaitTime))
-			defer cancel()
+// do runs the whole pipeline of a request.
+func (c *client) do(req *http.Request) (*http.Response, error) {
+	// set the host first, the circuit breaker and the rate limiter may depend
+	// on it
+	if c.host != nil {
+		if req.URL.Scheme == "" {
+			req.URL.Scheme = c.host.Scheme
 		}
 
-		err := c.rateLimiter.Wait(ctx)
+		if req.URL.Host == "" {
+			req.URL.Host = c.host.Host
+		}
+	}
+
+	// an open circuit fails fast, so check it before the rate limiter. The
+	// permit is only taken once the rate limiter admitted the request, so a
+	// half-open circuit does not hold its probe slot while waiting.
+	if c.circuitBreaker != nil {
+		err := c.circuitBreaker.check(req.URL.Host)
 		if err != nil {
-			return nil, RateLimitError{
-				Err:         err,
-				RateLimiter: c.rateLimiter,
-			}
+			return nil, err
+		}
+	}
+
+	// a rate limiter that does not look at the request is waited for before
+	// everything else, because if there is no free token we do not bother.
+	if _, ok := c.rateLimiter.(RequestRateLimiter); c.rateLimiter != nil && !ok {
+		err := c.rateLimit(req)
+		if err != nil {
+			return nil, err
 		}
 	}
 
 	// run all the pre request hooks
 	if c.preReqHooks != nil {
-		for _, hook := range c.preReqHooks {
+		for i, hook := range c.preReqHooks {
 			err := hook(req)
 			if err != nil {
 				return &http.Response{}, RequestError{
-					Err:     fmt.Errorf("error running pre request hook: %w", err),
+					Err:     HookError{Stage: HookPreRequest, Index: i, Err: err},
 					Request: req,
 				}
 			}
@@ -239,94 +416,383 @@ func (c *client) Do(req *http.Request) (*http.Response, error) {
 		}
 	}
 
-	// set the host
-	if c.host != nil {
-		if req.URL.Scheme == "" {
-			req.URL.Scheme = c.host.Scheme
+	// a request aware rate limiter is waited for once the hooks and the
+	// authenticator are done, so it sees the final request, e.g. the API key
+	// the authenticator set
+	if _, ok := c.rateLimiter.(RequestRateLimiter); ok {
+		err := c.rateLimit(req)
+		if err != nil {
+			return nil, err
 		}
+	}
 
-		if req.URL.Host == "" {
-			req.URL.Host = c.host.Host
+	// the permit is used for the first attempt and released if the request
+	// never reaches the network
+	var permit *circuitPermit
+	if c.circuitBreaker != nil {
+		var err error
+		permit, err = c.circuitBreaker.allow(req.URL.Host)
+		if err != nil {
+			return nil, err
 		}
+		defer permit.release()
+	}
+
+	// record the attempts in the history of the caller or in our own, which
+	// is handed out with the errors of the retry loop
+	history := attemptHistoryFromContext(req.Context())
+	if history == nil {
+		history = &attemptHistory{}
 	}
 
 	// now execute the request
-	res, err := c.httpClient.Do(req)
+	res, err := c.recordAttempt(req, permit, history, 0)
 	if err != nil {
-		return nil, RequestError{
-			Err:     err,
-			Request: req,
-		}
+		return nil, err
 	}
 
 	// handle all retry operations
 	if c.retryPolicy != nil {
-		// create a new backoff instance for this request
-		bop := c.newBackoffPolicy()
-
-		// check if the retry hook wants to perform a retry
-		for c.retryPolicy(res) {
-			var err error
-
-			// want to perform a retry so check the backoff implementation if
-			// a retry is still possible
-			t, ok := bop.Backoff()
-			if !ok {
-				return res, BackoffError{
-					Err: fmt.Errorf("error backing off: %w", err),
-				}
-			}
-
-			// wait for the backoff deadline
-			timer := time.NewTimer(t)
-
-			// run a func that returns as soon as either the context is done or
-			// we received a response from the request.
-			res, err = func(res *http.Response) (*http.Response, error) {
-				for {
-					// we are using a timer so we are able to concurrently listen
-					// on the context and the timer. This is not possible with
-					// a sleep.
-					select {
-					case <-ctx.Done():
-						return res, ctx.Err()
-					case <-timer.C:
-						// TODO: timeout? memory leak?
-						// TODO: retry authentication etc?
-						// now execute the request without all prior hooks etc.
-						// because we already did that.
-						res, err = c.httpClient.Do(req)
-						if err != nil {
-							return nil, RequestError{
-								Err:     fmt.Errorf("error making http request: %w", err),
-								Request: req,
-							}
-						}
-
-						// stop the timer because we got our one tick
-						timer.Stop()
-
-						// return from the inner function and overwrite the
-						// existing response object.
-						return res, nil
-					}
-				}
-			}(res)
+		res, err = c.retry(req, res, history)
+		if err != nil {
+			return res, err
 		}
 	}
 
 	// run all the post response hooks
 	if c.postRespHooks != nil {
-		for _, hook := range c.postRespHooks {
+		for i, hook := range c.postRespHooks {
 			err := hook(res)
 			if err != nil {
 				return res, ResponseError{
-					Err:      fmt.Errorf("error running post response hook: %w", err),
+					Err:      HookError{Stage: HookPostResponse, Index: i, Err: err},
 					Response: res,
 				}
 			}
 		}
 	}
 
+	// the hooks see every response, e.g. for metrics, before an error status
+	// is turned into an error
+	return c.checkStatus(res)
+}
+
+// attempt executes a single attempt of the request. If an attempt timeout is
+// configured, it covers the attempt until its response body is closed.
+func (c *client) attempt(req *http.Request, permit *circuitPermit) (*http.Response, error) {
+	if c.conf.AttemptTimeout <= 0 {
+		return c.roundTrip(req, permit)
+	}
+
+	ctx, cancel := context.WithTimeout(req.Context(), c.conf.AttemptTimeout)
+	res, err := c.roundTrip(req.WithContext(ctx), permit)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	res.Body = &cancelOnClose{ReadCloser: res.Body, cancel: cancel}
+
 	return res, nil
 }
+
+// roundTrip executes a single attempt of the request with the underlying http
+// client. If a circuit breaker is configured, the outcome is recorded with the
+// given permit. A nil permit makes roundTrip ask the circuit breaker for a new
+// one.
+func (c *client) roundTrip(req *http.Request, permit *circuitPermit) (*http.Response, error) {
+	if c.circuitBreaker != nil && permit == nil {
+		var err error
+		permit, err = c.circuitBreaker.allow(req.URL.Host)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// the bulkhead slot is held until the response body is closed
+	var release func()
+	if c.bulkhead != nil {
+		var err error
+		release, err = c.bulkhead.acquire(req.Context(), req.URL.Host)
+		if err != nil {
+			if permit != nil {
+				permit.release()
+			}
+			return nil, err
+		}
+	}
+
+	var listener ratelimit.Listener
+	if c.concurrencyLimit != nil {
+		var err error
+		listener, err = c.concurrencyLimit.Acquire(req.Context())
+		if err != nil {
+			if permit != nil {
+				permit.release()
+			}
+			if release != nil {
+				release()
+			}
+			return nil, err
+		}
+	}
+
+	res, shared, err := c.send(req)
+	if shared {
+		// the outcome of a coalesced call is recorded once by the request
+		// that made it, a failed upstream call must not count as many
+		if permit != nil {
+			permit.release()
+		}
+		if listener != nil {
+			listener.OnFailure() // releases the slot without a sample
+		}
+	} else if permit != nil {
+		permit.done(res, err)
+	}
+
+	if err != nil {
+		if release != nil {
+			release()
+		}
+		if listener != nil && !shared {
+			reportOutcome(listener, nil, err)
+		}
+
+		return nil, TransportError{
+			Err:     err,
+			Request: req,
+		}
+	}
+
+	if release != nil {
+		res.Body = &releaseOnClose{ReadCloser: res.Body, release: release}
+	}
+
+	// like the bulkhead slot, the slot of the concurrency limiter is held
+	// until the body is closed, so the latency sample includes the body
+	if listener != nil && !shared {
+		res.Body = &releaseOnClose{ReadCloser: res.Body, release: func() {
+			reportOutcome(listener, res, nil)
+		}}
+	}
+
+	// limit the body of every attempt, so hooks, status errors, retries and
+	// the caller never read more than the max response size
+	if c.conf.MaxResponseSize > 0 && res.Body != nil && res.Body != http.NoBody {
+		res.Body = &readCloser{
+			Reader: &maxBytesReader{r: res.Body, n: c.conf.MaxResponseSize},
+			Closer: res.Body,
+		}
+	}
+
+	return res, nil
+}
+
+// rateLimit waits for the rate limiter. Without a deadline of the request
+// context the wait is limited to the max rate limiter wait time.
+func (c *client) rateLimit(req *http.Request) error {
+	ctx := req.Context()
+
+	// if the given context has no deadline we se the default deadline from
+	// the client to protect the user from never ending waits.
+	_, ok := ctx.Deadline()
+	if !ok {
+		// wrap the request context with a deadline to provide a timeout
+		// for rate limit waits even if the user does not provide a deadline in
+		// the context.
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithDeadline(ctx, time.Now().Add(c.conf.MaxRateLimiterWaitTime))
+		defer cancel()
+	}
+
+	start := time.Now()
+	err := c.waitRateLimiter(ctx, req)
+	if err != nil {
+		var name string
+		if named, ok := c.rateLimiter.(interface{ Name() string }); ok {
+			name = named.Name()
+		}
+
+		return RateLimitError{
+			Err:         err,
+			RateLimiter: c.rateLimiter,
+			Name:        name,
+			Waited:      time.Since(start),
+		}
+	}
+
+	return nil
+}
+
+// waitRateLimiter waits for the rate limiter and hands it the request if the
+// rate limiter needs it.
+func (c *client) waitRateLimiter(ctx context.Context, req *http.Request) error {
+	if rl, ok := c.rateLimiter.(RequestRateLimiter); ok {
+		return rl.WaitRequest(ctx, req)
+	}
+
+	return c.rateLimiter.Wait(ctx)
+}
+
+// reportOutcome tells the listener of a concurrency limiter about the outcome
+// of a request.
+func reportOutcome(listener ratelimit.Listener, res *http.Response, err error) {
+	if err != nil {
+		var netErr net.Error
+		if errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &netErr) && netErr.Timeout()) {
+			listener.OnDropped()
+			return
+		}
+
+		listener.OnFailure()
+		return
+	}
+
+	switch {
+	case res.StatusCode == http.StatusTooManyRequests || res.StatusCode == http.StatusServiceUnavailable:
+		listener.OnDropped()
+	case res.StatusCode >= http.StatusInternalServerError:
+		listener.OnFailure()
+	default:
+		listener.OnSuccess()
+	}
+}
+
+// send hands the request to the underlying http client. Identical requests
+// in flight are coalesced if configured, it reports whether the request joined
+// the call of another request.
+func (c *client) send(req *http.Request) (*http.Response, bool, error) {
+	if c.coalescer != nil {
+		return c.coalescer.do(req, c.transmit, c.conf.MaxResponseSize)
+	}
+
+	res, err := c.transmit(req)
+	return res, false, err
+}
+
+// transmit sends the request with the underlying http client, hedging it if
+// configured.
+func (c *client) transmit(req *http.Request) (*http.Response, error) {
+	if c.hedger != nil {
+		// the max ratio of hedges is measured against all requests
+		c.hedger.total.Add(1)
+
+		if c.hedger.conf.ShouldHedge(req) {
+			return c.hedgedDo(req)
+		}
+	}
+
+	return c.httpClient.Do(req)
+}
+
+// BulkheadStats returns the metrics of the bulkhead. Without a configured
+// bulkhead all metrics are zero.
+func (c *client) BulkheadStats() BulkheadStats {
+	if c.bulkhead == nil {
+		return BulkheadStats{}
+	}
+
+	return c.bulkhead.stats()
+}
+
+// CircuitState returns the state of the circuit for the given host. Without a
+// configured circuit breaker the circuit is always closed.
+func (c *client) CircuitState(host string) CircuitState {
+	if c.circuitBreaker == nil {
+		return CircuitClosed
+	}
+
+	return c.circuitBreaker.state(host)
+}
+
+// enter registers a call to Do. It reports false if the client is closed.
+func (c *client) enter() bool {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	if c.closed {
+		return false
+	}
+
+	c.inflight++
+	return true
+}
+
+// leave unregisters a call to Do and signals a waiting Shutdown once the last
+// call returned.
+func (c *client) leave() {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	c.inflight--
+	if c.closed && c.inflight == 0 {
+		c.signalIdle()
+	}
+}
+
+// signalIdle closes the idle channel once. The caller must hold the lock.
+func (c *client) signalIdle() {
+	select {
+	case <-c.idle:
+	default:
+		close(c.idle)
+	}
+}
+
+// Close closes the client immediately. New requests are rejected with
+// ErrClientClosed, requests in flight are not waited for. Rate limiters and
+// other dependencies passed as options are owned by the caller and have to be
+// closed separately. The idle connections of a http client passed with
+// WithHttpClient are closed, the shared http.DefaultClient used by default is
+// left alone. Closing the client multiple times is a no-op.
+func (c *client) Close() error {
+	c.close()
+	c.closeIdleConnections()
+
+	return nil
+}
+
+// Shutdown closes the client and waits for all requests in flight to finish.
+// If the context is done before, Shutdown returns the context error. A request
+// is in flight until Do returns, reading the response body is up to the
+// caller. Idle connections are closed like for Close.
+func (c *client) Shutdown(ctx context.Context) error {
+	idle := c.close()
+
+	select {
+	case <-idle:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	c.closeIdleConnections()
+
+	return nil
+}
+
+// closeIdleConnections closes the idle connections of a http client passed
+// with WithHttpClient. The default http client is shared with the rest of the
+// process, closing its connections would affect other users.
+func (c *client) closeIdleConnections() {
+	if c.customHTTPClient {
+		c.httpClient.CloseIdleConnections()
+	}
+}
+
+// close marks the client as closed and returns a channel that is closed once
+// no call to Do is running anymore.
+func (c *client) close() <-chan struct{} {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	if c.idle == nil {
+		c.idle = make(chan struct{})
+	}
+
+	c.closed = true
+	if c.inflight == 0 {
+		c.signalIdle()
+	}
+
+	return c.idle
+}
@@ -3,6 +3,7 @@ package lazyhttp_test
 import (
 	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
@@ -623,3 +624,471 @@ func TestRateLimiterWithEmptyContext(t *testing.T) {
 		return
 	}
 }
+
+func TestConcurrencyLimiter(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	})
+
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	addr, err := url.Parse(srv.URL)
+	if err != nil {
+		t.Errorf("did not expect error parsing url: %+v", err)
+		return
+	}
+
+	limiter := ratelimit.NewAIMDLimiter(ratelimit.AIMDConfig{InitialLimit: 10, BackoffRatio: 0.5})
+	client := lazyhttp.New(
+		lazyhttp.WithHost(addr),
+		lazyhttp.WithConcurrencyLimiter(limiter),
+	)
+
+	req, err := http.NewRequestWithContext(context.Background(), http.MethodGet, "/", nil)
+	if err != nil {
+		t.Errorf("did not expect error creating request: %+v", err)
+		return
+	}
+
+	res, err := client.Do(req)
+	if err != nil {
+		t.Errorf("did not expect error making request: %+v", err)
+		return
+	}
+
+	// the request is in flight until its body is closed
+	if limiter.InFlight() != 1 || limiter.Limit() != 10 {
+		t.Errorf("expected the request in flight until the body is closed but got: %d", limiter.InFlight())
+	}
+	lazyhttp.NoopBodyCloser(res.Body)
+
+	// the 503 is reported as drop and halves the limit
+	if limiter.Limit() != 5 {
+		t.Errorf("expected limit of 5 but got: %d", limiter.Limit())
+	}
+
+	if limiter.InFlight() != 0 {
+		t.Errorf("expected no requests in flight but got: %d", limiter.InFlight())
+	}
+}
+
+func TestTokenBucket(t *testing.T) {
+	requestCount := 0
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		requestCount += 1
+		w.WriteHeader(http.StatusOK)
+	})
+
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	addr, err := url.Parse(srv.URL)
+	if err != nil {
+		t.Errorf("did not expect error parsing url: %+v", err)
+		return
+	}
+
+	client := lazyhttp.New(
+		lazyhttp.WithHost(addr),
+		lazyhttp.WithRateLimiter(ratelimit.NewTokenBucket(ratelimit.Every(20*time.Millisecond), 1)),
+	)
+
+	start := time.Now()
+	for i := 0; i < 5; i++ {
+		req, err := http.NewRequestWithContext(context.Background(), http.MethodGet, "/", nil)
+		if err != nil {
+			t.Errorf("did not expect error creating request: %+v", err)
+			return
+		}
+
+		res, err := client.Do(req)
+		if err != nil {
+			t.Errorf("did not expect error making request: %+v", err)
+			return
+		}
+		lazyhttp.NoopBodyCloser(res.Body)
+	}
+
+	// the first request uses the initial token, the others wait 20ms each
+	if time.Since(start) < 80*time.Millisecond {
+		t.Errorf("expected requests to be paced but took: %s", time.Since(start))
+	}
+
+	if requestCount != 5 {
+		t.Errorf("expected 5 requests but got: %d", requestCount)
+	}
+}
+
+func TestShutdown(t *testing.T) {
+	unblock := make(chan struct{})
+	started := make(chan struct{})
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-unblock
+		w.WriteHeader(http.StatusOK)
+	})
+
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	addr, err := url.Parse(srv.URL)
+	if err != nil {
+		t.Errorf("did not expect error parsing url: %+v", err)
+		return
+	}
+
+	client := lazyhttp.New(lazyhttp.WithHost(addr))
+
+	inflight := make(chan error, 1)
+	go func() {
+		req, err := http.NewRequestWithContext(context.Background(), http.MethodGet, "/", nil)
+		if err != nil {
+			inflight <- err
+			return
+		}
+
+		res, err := client.Do(req)
+		if err == nil {
+			lazyhttp.NoopBodyCloser(res.Body)
+		}
+		inflight <- err
+	}()
+	<-started
+
+	// the request in flight keeps shutdown from finishing
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	err = client.Shutdown(ctx)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected deadline exceeded but got: %+v", err)
+	}
+
+	// new requests are rejected
+	req, err := http.NewRequestWithContext(context.Background(), http.MethodGet, "/", nil)
+	if err != nil {
+		t.Errorf("did not expect error creating request: %+v", err)
+		return
+	}
+
+	_, err = client.Do(req)
+	if !errors.Is(err, lazyhttp.ErrClientClosed) {
+		t.Errorf("expected ErrClientClosed but got: %+v", err)
+	}
+
+	close(unblock)
+	err = client.Shutdown(context.Background())
+	if err != nil {
+		t.Errorf("did not expect error shutting down: %+v", err)
+	}
+
+	if err := <-inflight; err != nil {
+		t.Errorf("did not expect error for the request in flight: %+v", err)
+	}
+}
+
+// idleTransport counts how often its idle connections were closed
+type idleTransport struct {
+	http.RoundTripper
+	closed int
+}
+
+func (t *idleTransport) CloseIdleConnections() {
+	t.closed++
+}
+
+func TestCloseIdleConnections(t *testing.T) {
+	// the default http client is shared and left alone
+	transport := http.DefaultClient.Transport
+	defer func() { http.DefaultClient.Transport = transport }()
+
+	shared := &idleTransport{RoundTripper: http.DefaultTransport}
+	http.DefaultClient.Transport = shared
+
+	if err := lazyhttp.New().Close(); err != nil {
+		t.Errorf("did not expect error closing: %+v", err)
+	}
+
+	if shared.closed != 0 {
+		t.Errorf("expected the idle connections of the default client to be kept but got %d closes", shared.closed)
+	}
+
+	// a http client passed by the caller is closed
+	own := &idleTransport{RoundTripper: http.DefaultTransport}
+	client := lazyhttp.New(lazyhttp.WithHttpClient(&http.Client{Transport: own}))
+
+	if err := client.Shutdown(context.Background()); err != nil {
+		t.Errorf("did not expect error shutting down: %+v", err)
+	}
+
+	if own.closed != 1 {
+		t.Errorf("expected the idle connections to be closed once but got: %d", own.closed)
+	}
+}
+
+func TestKeyedRateLimiter(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	addr, err := url.Parse(srv.URL)
+	if err != nil {
+		t.Errorf("did not expect error parsing url: %+v", err)
+		return
+	}
+
+	// every api key may send a single request
+	client := lazyhttp.New(
+		lazyhttp.WithHost(addr),
+		lazyhttp.WithRateLimiter(ratelimit.NewKeyedLimiter(ratelimit.KeyedConfig{
+			Key: ratelimit.HeaderKey("X-Api-Key"),
+			New: func(key string) ratelimit.Limiter {
+				return ratelimit.NewTokenBucket(ratelimit.Every(time.Hour), 1)
+			},
+		})),
+		lazyhttp.WithMaxRateLimiterWaitTime(10*time.Millisecond),
+	)
+
+	do := func(key string) error {
+		req, err := http.NewRequestWithContext(context.Background(), http.MethodGet, "/", nil)
+		if err != nil {
+			return err
+		}
+		req.Header.Set("X-Api-Key", key)
+
+		res, err := client.Do(req)
+		if err != nil {
+			return err
+		}
+		lazyhttp.NoopBodyCloser(res.Body)
+
+		return nil
+	}
+
+	for _, key := range []string{"a", "b"} {
+		if err := do(key); err != nil {
+			t.Errorf("did not expect error for key %s: %+v", key, err)
+		}
+	}
+
+	var rateLimitErr lazyhttp.RateLimitError
+	if err := do("a"); !errors.As(err, &rateLimitErr) {
+		t.Errorf("expected RateLimitError but got: %+v", err)
+	}
+}
+
+type tenantKey struct{}
+
+// TestKeyedRateLimiterSeesAuthenticator checks that a keyed rate limiter sees
+// the API key set by the authenticator of the client.
+func TestKeyedRateLimiterSeesAuthenticator(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	addr, err := url.Parse(srv.URL)
+	if err != nil {
+		t.Errorf("did not expect error parsing url: %+v", err)
+		return
+	}
+
+	// every tenant may send a single request, its api key is set by the
+	// authenticator
+	client := lazyhttp.New(
+		lazyhttp.WithHost(addr),
+		lazyhttp.WithAuthenticator(lazyhttp.AuthenticatorFunc(func(r *http.Request) error {
+			r.Header.Set("X-Api-Key", r.Context().Value(tenantKey{}).(string))
+			return nil
+		})),
+		lazyhttp.WithRateLimiter(ratelimit.NewKeyedLimiter(ratelimit.KeyedConfig{
+			Key: ratelimit.HeaderKey("X-Api-Key"),
+			New: func(key string) ratelimit.Limiter {
+				return ratelimit.NewTokenBucket(ratelimit.Every(time.Hour), 1)
+			},
+		})),
+		lazyhttp.WithMaxRateLimiterWaitTime(10*time.Millisecond),
+	)
+
+	do := func(tenant string) error {
+		ctx := context.WithValue(context.Background(), tenantKey{}, tenant)
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, "/", nil)
+		if err != nil {
+			return err
+		}
+
+		res, err := client.Do(req)
+		if err != nil {
+			return err
+		}
+		lazyhttp.NoopBodyCloser(res.Body)
+
+		return nil
+	}
+
+	for _, tenant := range []string{"a", "b"} {
+		if err := do(tenant); err != nil {
+			t.Errorf("did not expect error for tenant %s: %+v", tenant, err)
+		}
+	}
+
+	var rateLimitErr lazyhttp.RateLimitError
+	if err := do("a"); !errors.As(err, &rateLimitErr) {
+		t.Errorf("expected RateLimitError but got: %+v", err)
+	}
+}
+
+func TestWeightedRateLimiter(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	addr, err := url.Parse(srv.URL)
+	if err != nil {
+		t.Errorf("did not expect error parsing url: %+v", err)
+		return
+	}
+
+	// a search costs the whole quota
+	client := lazyhttp.New(
+		lazyhttp.WithHost(addr),
+		lazyhttp.WithRateLimiter(ratelimit.NewPriorityLimiter(ratelimit.PriorityConfig{
+			Rate:   ratelimit.Every(time.Hour),
+			Burst:  5,
+			Weight: ratelimit.PathWeights(map[string]int{"/search": 5}),
+		})),
+		lazyhttp.WithMaxRateLimiterWaitTime(10*time.Millisecond),
+	)
+
+	do := func(path string) error {
+		req, err := http.NewRequestWithContext(context.Background(), http.MethodGet, path, nil)
+		if err != nil {
+			return err
+		}
+
+		res, err := client.Do(req)
+		if err != nil {
+			return err
+		}
+		lazyhttp.NoopBodyCloser(res.Body)
+
+		return nil
+	}
+
+	if err := do("/search"); err != nil {
+		t.Errorf("did not expect error for search: %+v", err)
+	}
+
+	var rateLimitErr lazyhttp.RateLimitError
+	if err := do("/get"); !errors.As(err, &rateLimitErr) {
+		t.Errorf("expected RateLimitError but got: %+v", err)
+	}
+}
+
+func TestRateLimitErrorNameAndWaited(t *testing.T) {
+	client := lazyhttp.New(
+		lazyhttp.WithRateLimiter(ratelimit.Named("vendor", ratelimit.NewTokenBucket(ratelimit.Every(time.Hour), 1))),
+		lazyhttp.WithMaxRateLimiterWaitTime(20*time.Millisecond),
+	)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	do := func() error {
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		defer cancel()
+
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL, nil)
+		if err != nil {
+			return err
+		}
+
+		res, err := client.Do(req)
+		if err != nil {
+			return err
+		}
+		lazyhttp.NoopBodyCloser(res.Body)
+
+		return nil
+	}
+
+	if err := do(); err != nil {
+		t.Errorf("did not expect error: %+v", err)
+		return
+	}
+
+	var rateLimitErr lazyhttp.RateLimitError
+	if err := do(); !errors.As(err, &rateLimitErr) {
+		t.Errorf("expected RateLimitError but got: %+v", err)
+		return
+	}
+
+	if rateLimitErr.Name != "vendor" {
+		t.Errorf("expected limiter name vendor but got: %s", rateLimitErr.Name)
+	}
+
+	if rateLimitErr.Waited > 20*time.Millisecond {
+		t.Errorf("expected to fail fast but waited: %s", rateLimitErr.Waited)
+	}
+}
+
+func TestNamedRateLimiterBeforeHooks(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	hooks := 0
+	client := lazyhttp.New(
+		lazyhttp.WithRateLimiter(ratelimit.Named("vendor", ratelimit.NewTokenBucket(ratelimit.Every(time.Hour), 1))),
+		lazyhttp.WithMaxRateLimiterWaitTime(20*time.Millisecond),
+		lazyhttp.WithPreRequestHooks(func(*http.Request) error {
+			hooks++
+			return nil
+		}),
+	)
+
+	for i := 0; i < 2; i++ {
+		req, err := http.NewRequestWithContext(context.Background(), http.MethodGet, srv.URL, nil)
+		if err != nil {
+			t.Errorf("did not expect error creating request: %+v", err)
+			return
+		}
+
+		res, err := client.Do(req)
+		if err == nil {
+			lazyhttp.NoopBodyCloser(res.Body)
+		}
+	}
+
+	// naming the token bucket does not move its wait behind the hooks, so
+	// the rejected request never ran them
+	if hooks != 1 {
+		t.Errorf("expected the hooks to run once but got: %d", hooks)
+	}
+}
@@ -0,0 +1,201 @@
+package lazyhttp
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// CoalesceKeyFunc returns the key concurrent identical requests share. Requests
+// with the same key are coalesced into a single upstream call. An empty key
+// means the request is never coalesced.
+type CoalesceKeyFunc func(*http.Request) string
+
+// credentialHeaders are always part of the key of CoalesceKey, so requests
+// with different credentials never share a response.
+var credentialHeaders = []string{"Authorization", "Cookie"}
+
+// CoalesceKey returns a CoalesceKeyFunc that considers requests identical if
+// they share the method, the url, the credentials in the Authorization and
+// Cookie headers and the values of the given headers.
+func CoalesceKey(headers ...string) CoalesceKeyFunc {
+	keyHeaders := append([]string(nil), credentialHeaders...)
+	for _, h := range headers {
+		h = http.CanonicalHeaderKey(h)
+		if h != "Authorization" && h != "Cookie" {
+			keyHeaders = append(keyHeaders, h)
+		}
+	}
+
+	return func(req *http.Request) string {
+		var sb strings.Builder
+		sb.WriteString(req.Method)
+		sb.WriteString(" ")
+		sb.WriteString(req.URL.String())
+
+		for _, h := range keyHeaders {
+			sb.WriteString("\n")
+			sb.WriteString(h)
+			sb.WriteString(": ")
+			sb.WriteString(strings.Join(req.Header.Values(h), ","))
+		}
+
+		return sb.String()
+	}
+}
+
+// maxCoalescedBodySize is the max size of a shared body if the client has no
+// max response size.
+const maxCoalescedBodySize = 10 << 20
+
+// coalescedCall is a single upstream call shared by all identical requests
+type coalescedCall struct {
+	done     chan struct{}
+	res      *http.Response
+	body     []byte
+	err      error
+	tooLarge bool // the body exceeded the max size and was not shared
+}
+
+type coalescer struct {
+	key CoalesceKeyFunc
+
+	mtx   sync.Mutex
+	calls map[string]*coalescedCall
+}
+
+func newCoalescer(key CoalesceKeyFunc) *coalescer {
+	if key == nil {
+		key = CoalesceKey()
+	}
+
+	return &coalescer{
+		key:   key,
+		calls: map[string]*coalescedCall{},
+	}
+}
+
+// isSafeMethod reports whether the method is safe according to RFC 9110
+func isSafeMethod(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace:
+		return true
+	default:
+		return false
+	}
+}
+
+// do executes fn for the request or joins an identical call already in flight.
+// It reports whether the request joined the call of another request, only the
+// request that made the upstream call records its outcome. The shared call
+// runs with the context of the request that started it, if that context is
+// cancelled all waiting requests fail as well. At most maxBody bytes of the
+// shared body are buffered, the max response size of the client or
+// maxCoalescedBodySize. A larger body is kept by the request that started the
+// call and the waiting requests make their own calls.
+func (co *coalescer) do(req *http.Request, fn func(*http.Request) (*http.Response, error), maxBody int64) (*http.Response, bool, error) {
+	if maxBody <= 0 {
+		maxBody = maxCoalescedBodySize
+	}
+
+	if !isSafeMethod(req.Method) {
+		res, err := fn(req)
+		return res, false, err
+	}
+
+	key := co.key(req)
+	if key == "" {
+		res, err := fn(req)
+		return res, false, err
+	}
+
+	co.mtx.Lock()
+	if call, ok := co.calls[key]; ok {
+		co.mtx.Unlock()
+
+		select {
+		case <-call.done:
+			if call.tooLarge {
+				res, err := fn(req)
+				return res, false, err
+			}
+
+			res, err := call.response(req)
+			return res, true, err
+		case <-req.Context().Done():
+			return nil, true, req.Context().Err()
+		}
+	}
+
+	call := &coalescedCall{done: make(chan struct{})}
+	co.calls[key] = call
+	co.mtx.Unlock()
+
+	var own *http.Response
+	call.res, call.err = fn(req)
+	if call.err == nil {
+		// every caller gets its own copy of the body, so the body of the
+		// shared response has to be buffered.
+		own, call.err = call.buffer(maxBody)
+	}
+
+	co.mtx.Lock()
+	delete(co.calls, key)
+	co.mtx.Unlock()
+	close(call.done)
+
+	if own != nil {
+		return own, false, nil
+	}
+
+	res, err := call.response(req)
+	return res, false, err
+}
+
+// buffer reads the shared body up to maxBody bytes. If the body is larger, it
+// is not shared and the response is returned with a body that reads the
+// buffered start and then the rest.
+func (call *coalescedCall) buffer(maxBody int64) (*http.Response, error) {
+	body := call.res.Body
+
+	b, err := io.ReadAll(io.LimitReader(body, maxBody+1))
+	if err != nil {
+		body.Close()
+		return nil, fmt.Errorf("error reading response body: %w", err)
+	}
+
+	if int64(len(b)) > maxBody {
+		call.tooLarge = true
+
+		res := call.res
+		res.Body = &readCloser{
+			Reader: io.MultiReader(bytes.NewReader(b), body),
+			Closer: body,
+		}
+
+		return res, nil
+	}
+
+	body.Close()
+	call.body = b
+
+	return nil, nil
+}
+
+// response returns a copy of the shared response with its own readable body
+func (call *coalescedCall) response(req *http.Request) (*http.Response, error) {
+	if call.err != nil {
+		return nil, call.err
+	}
+
+	res := *call.res
+	res.Header = call.res.Header.Clone()
+	res.Trailer = call.res.Trailer.Clone()
+	res.Body = io.NopCloser(bytes.NewReader(call.body))
+	res.Request = req
+
+	return &res, nil
+}
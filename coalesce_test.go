@@ -0,0 +1,353 @@
+package lazyhttp_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/niksteff/lazyhttp"
+	"github.com/niksteff/lazyhttp/ratelimit"
+)
+
+func startSlowServer(t *testing.T, requestCount *atomic.Int32) (*httptest.Server, *url.URL) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		requestCount.Add(1)
+		time.Sleep(100 * time.Millisecond)
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write([]byte(`{"value": "test"}`))
+	})
+
+	srv := httptest.NewServer(mux)
+
+	addr, err := url.Parse(srv.URL)
+	if err != nil {
+		t.Fatalf("did not expect error parsing url: %+v", err)
+	}
+
+	return srv, addr
+}
+
+// TestCoalescingSharesUpstreamCall sends identical requests concurrently and
+// checks that only one reaches the server while every caller can read the
+// body.
+func TestCoalescingSharesUpstreamCall(t *testing.T) {
+	var requestCount atomic.Int32
+	srv, addr := startSlowServer(t, &requestCount)
+	defer srv.Close()
+
+	client := lazyhttp.New(
+		lazyhttp.WithHost(addr),
+		lazyhttp.WithRequestCoalescing(nil),
+	)
+
+	callers := 10
+	var wg sync.WaitGroup
+	for i := 0; i < callers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			req, err := http.NewRequestWithContext(context.Background(), http.MethodGet, "/", nil)
+			if err != nil {
+				t.Errorf("did not expect error creating request: %+v", err)
+				return
+			}
+
+			res, err := client.Do(req)
+			if err != nil {
+				t.Errorf("did not expect error making request: %+v", err)
+				return
+			}
+
+			b, err := lazyhttp.DecodeBytes(res.Body)
+			if err != nil {
+				t.Errorf("did not expect error reading body: %+v", err)
+				return
+			}
+
+			if string(b) != `{"value": "test"}` {
+				t.Errorf("unexpected body: %s", b)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if requestCount.Load() != 1 {
+		t.Errorf("expected 1 upstream request but got: %d", requestCount.Load())
+	}
+}
+
+// TestCoalescingSkipsUnsafeMethods checks that POST requests are never
+// coalesced.
+func TestCoalescingSkipsUnsafeMethods(t *testing.T) {
+	var requestCount atomic.Int32
+	srv, addr := startSlowServer(t, &requestCount)
+	defer srv.Close()
+
+	client := lazyhttp.New(
+		lazyhttp.WithHost(addr),
+		lazyhttp.WithRequestCoalescing(nil),
+	)
+
+	callers := 3
+	var wg sync.WaitGroup
+	for i := 0; i < callers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			req, err := http.NewRequestWithContext(context.Background(), http.MethodPost, "/", nil)
+			if err != nil {
+				t.Errorf("did not expect error creating request: %+v", err)
+				return
+			}
+
+			res, err := client.Do(req)
+			if err != nil {
+				t.Errorf("did not expect error making request: %+v", err)
+				return
+			}
+			_, _ = io.Copy(io.Discard, res.Body)
+			_ = res.Body.Close()
+		}()
+	}
+	wg.Wait()
+
+	if requestCount.Load() != int32(callers) {
+		t.Errorf("expected %d upstream requests but got: %d", callers, requestCount.Load())
+	}
+}
+
+// TestCoalescingKeyHeaders checks that requests differing in a selected header
+// do not share an upstream call.
+func TestCoalescingKeyHeaders(t *testing.T) {
+	var requestCount atomic.Int32
+	srv, addr := startSlowServer(t, &requestCount)
+	defer srv.Close()
+
+	client := lazyhttp.New(
+		lazyhttp.WithHost(addr),
+		lazyhttp.WithRequestCoalescing(lazyhttp.CoalesceKey("Authorization")),
+	)
+
+	var wg sync.WaitGroup
+	for _, token := range []string{"a", "a", "b", "b"} {
+		wg.Add(1)
+		go func(token string) {
+			defer wg.Done()
+
+			req, err := http.NewRequestWithContext(context.Background(), http.MethodGet, "/", nil)
+			if err != nil {
+				t.Errorf("did not expect error creating request: %+v", err)
+				return
+			}
+			req.Header.Set("Authorization", token)
+
+			res, err := client.Do(req)
+			if err != nil {
+				t.Errorf("did not expect error making request: %+v", err)
+				return
+			}
+			lazyhttp.NoopBodyCloser(res.Body)
+		}(token)
+	}
+	wg.Wait()
+
+	if requestCount.Load() != 2 {
+		t.Errorf("expected 2 upstream requests but got: %d", requestCount.Load())
+	}
+}
+
+// TestCoalescingSeparatesCredentials checks that the default key never shares
+// a response between callers with different credentials.
+func TestCoalescingSeparatesCredentials(t *testing.T) {
+	var requestCount atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestCount.Add(1)
+		time.Sleep(100 * time.Millisecond)
+		_, _ = w.Write([]byte(r.Header.Get("Authorization") + r.Header.Get("Cookie")))
+	}))
+	defer srv.Close()
+
+	addr, _ := url.Parse(srv.URL)
+	client := lazyhttp.New(
+		lazyhttp.WithHost(addr),
+		lazyhttp.WithRequestCoalescing(nil),
+	)
+
+	credentials := []struct {
+		header string
+		value  string
+	}{
+		{"Authorization", "Bearer user-a"},
+		{"Authorization", "Bearer user-b"},
+		{"Cookie", "session=user-c"},
+	}
+
+	var wg sync.WaitGroup
+	for _, cred := range credentials {
+		wg.Add(1)
+		go func(header string, value string) {
+			defer wg.Done()
+
+			req, err := http.NewRequestWithContext(context.Background(), http.MethodGet, "/", nil)
+			if err != nil {
+				t.Errorf("did not expect error creating request: %+v", err)
+				return
+			}
+			req.Header.Set(header, value)
+
+			res, err := client.Do(req)
+			if err != nil {
+				t.Errorf("did not expect error making request: %+v", err)
+				return
+			}
+
+			b, err := lazyhttp.DecodeBytes(res.Body)
+			if err != nil {
+				t.Errorf("did not expect error reading body: %+v", err)
+				return
+			}
+
+			if string(b) != value {
+				t.Errorf("expected the response of %s but got: %s", value, b)
+			}
+		}(cred.header, cred.value)
+	}
+	wg.Wait()
+
+	if requestCount.Load() != 3 {
+		t.Errorf("expected 3 upstream requests but got: %d", requestCount.Load())
+	}
+}
+
+// TestCoalescingLargeBody checks that a body exceeding the max response size
+// is not buffered for sharing and every caller makes its own call.
+func TestCoalescingLargeBody(t *testing.T) {
+	var requestCount atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestCount.Add(1)
+		time.Sleep(100 * time.Millisecond)
+		_, _ = w.Write(make([]byte, 2<<10))
+	}))
+	defer srv.Close()
+
+	addr, _ := url.Parse(srv.URL)
+	client := lazyhttp.New(
+		lazyhttp.WithHost(addr),
+		lazyhttp.WithRequestCoalescing(nil),
+		lazyhttp.WithMaxResponseSize(1<<10),
+	)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			req, err := http.NewRequestWithContext(context.Background(), http.MethodGet, "/", nil)
+			if err != nil {
+				t.Errorf("did not expect error creating request: %+v", err)
+				return
+			}
+
+			res, err := client.Do(req)
+			if err != nil {
+				t.Errorf("did not expect error making request: %+v", err)
+				return
+			}
+
+			_, err = lazyhttp.DecodeBytes(res.Body)
+			if !errors.Is(err, lazyhttp.ErrBodyTooLarge) {
+				t.Errorf("expected ErrBodyTooLarge but got: %+v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if requestCount.Load() != 3 {
+		t.Errorf("expected 3 upstream requests but got: %d", requestCount.Load())
+	}
+}
+
+// TestCoalescingRecordsOutcomeOnce checks that a failed upstream call shared
+// by many callers is recorded once by the circuit breaker and the concurrency
+// limiter.
+func TestCoalescingRecordsOutcomeOnce(t *testing.T) {
+	var requestCount atomic.Int32
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		requestCount.Add(1)
+		time.Sleep(100 * time.Millisecond)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	})
+
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	addr, err := url.Parse(srv.URL)
+	if err != nil {
+		t.Errorf("did not expect error parsing url: %+v", err)
+		return
+	}
+
+	limiter := ratelimit.NewAIMDLimiter(ratelimit.AIMDConfig{InitialLimit: 20})
+	client := lazyhttp.New(
+		lazyhttp.WithHost(addr),
+		lazyhttp.WithRequestCoalescing(nil),
+		lazyhttp.WithCircuitBreaker(lazyhttp.CircuitBreakerConfig{
+			ConsecutiveFailures: 3,
+		}),
+		lazyhttp.WithConcurrencyLimiter(limiter),
+	)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			req, err := http.NewRequestWithContext(context.Background(), http.MethodGet, "/", nil)
+			if err != nil {
+				t.Errorf("did not expect error creating request: %+v", err)
+				return
+			}
+
+			res, err := client.Do(req)
+			if err != nil {
+				t.Errorf("did not expect error making request: %+v", err)
+				return
+			}
+			lazyhttp.NoopBodyCloser(res.Body)
+		}()
+	}
+	wg.Wait()
+
+	if requestCount.Load() != 1 {
+		t.Errorf("expected 1 upstream request but got: %d", requestCount.Load())
+		return
+	}
+
+	// a single failure does not open the circuit
+	if client.CircuitState(addr.Host) != lazyhttp.CircuitClosed {
+		t.Errorf("expected circuit to be closed but got: %s", client.CircuitState(addr.Host))
+	}
+
+	// a single drop reduces the limit once
+	if limiter.Limit() != 18 {
+		t.Errorf("expected limit of 18 but got: %d", limiter.Limit())
+	}
+
+	if limiter.InFlight() != 0 {
+		t.Errorf("expected no requests in flight but got: %d", limiter.InFlight())
+	}
+}
@@ -0,0 +1,379 @@
+package lazyhttp
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strings"
+	"sync"
+)
+
+// Decoder decodes a body into the value out points to.
+type Decoder interface {
+	Decode(r io.Reader, out any) error
+}
+
+// DecoderFunc is a function that implements the Decoder interface
+type DecoderFunc func(r io.Reader, out any) error
+
+// Decode calls the decoder function to implement the interface
+func (f DecoderFunc) Decode(r io.Reader, out any) error {
+	return f(r, out)
+}
+
+// decodeOptions are the options of a call to decode a body
+type decodeOptions struct {
+	maxBytes              int64 // max bytes of the body, 0 means no limit
+	disallowUnknownFields bool
+	useNumber             bool
+	rejectTrailingData    bool
+}
+
+// DecodeOption implements the functional options pattern for decoding
+type DecodeOption func(*decodeOptions) *decodeOptions
+
+// WithMaxBytes limits the size of the body. Decoding a larger body fails with
+// ErrBodyTooLarge.
+func WithMaxBytes(n int64) DecodeOption {
+	return func(o *decodeOptions) *decodeOptions {
+		o.maxBytes = n
+		return o
+	}
+}
+
+// WithDisallowUnknownFields makes decoding JSON fail if an object has a member
+// that does not match a field of the destination struct.
+func WithDisallowUnknownFields() DecodeOption {
+	return func(o *decodeOptions) *decodeOptions {
+		o.disallowUnknownFields = true
+		return o
+	}
+}
+
+// WithUseNumber decodes JSON numbers into an interface{} as a json.Number
+// instead of a float64, so large integers keep their precision.
+func WithUseNumber() DecodeOption {
+	return func(o *decodeOptions) *decodeOptions {
+		o.useNumber = true
+		return o
+	}
+}
+
+// WithRejectTrailingData makes decoding JSON fail if there is more than
+// whitespace after the JSON value.
+func WithRejectTrailingData() DecodeOption {
+	return func(o *decodeOptions) *decodeOptions {
+		o.rejectTrailingData = true
+		return o
+	}
+}
+
+func newDecodeOptions(opts []DecodeOption) *decodeOptions {
+	o := &decodeOptions{}
+	for _, opt := range opts {
+		o = opt(o)
+	}
+
+	return o
+}
+
+// reader limits the reader to the max bytes if set
+func (o *decodeOptions) reader(r io.Reader) io.Reader {
+	if o.maxBytes <= 0 {
+		return r
+	}
+
+	return &maxBytesReader{r: r, n: o.maxBytes}
+}
+
+// decodeJSON decodes a single JSON value with the options.
+func (o *decodeOptions) decodeJSON(r io.Reader, out any) error {
+	dec := json.NewDecoder(r)
+	if o.disallowUnknownFields {
+		dec.DisallowUnknownFields()
+	}
+
+	if o.useNumber {
+		dec.UseNumber()
+	}
+
+	err := dec.Decode(out)
+	if err != nil {
+		return err
+	}
+
+	if o.rejectTrailingData {
+		_, err := dec.Token()
+		if err == nil {
+			return fmt.Errorf("unexpected data after the json value")
+		}
+
+		if !errors.Is(err, io.EOF) {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// unmarshal decodes a complete JSON document with the options. Like with
+// json.Unmarshal, data after the value is always an error.
+func (o *decodeOptions) unmarshal(b []byte, out any) error {
+	if !o.disallowUnknownFields && !o.useNumber {
+		return json.Unmarshal(b, out)
+	}
+
+	strict := *o
+	strict.rejectTrailingData = true
+
+	return strict.decodeJSON(bytes.NewReader(b), out)
+}
+
+// maxBytesReader fails with ErrBodyTooLarge once more than n bytes are read.
+type maxBytesReader struct {
+	r io.Reader
+	n int64 // the bytes that may still be read, negative once exceeded
+}
+
+func (m *maxBytesReader) Read(p []byte) (int, error) {
+	if m.n < 0 {
+		return 0, ErrBodyTooLarge
+	}
+
+	// read one more byte than allowed to tell a body of exactly the max size
+	// from a larger one
+	if int64(len(p)) > m.n+1 {
+		p = p[:m.n+1]
+	}
+
+	n, err := m.r.Read(p)
+	if int64(n) > m.n {
+		n = int(m.n)
+		m.n = -1
+		return n, ErrBodyTooLarge
+	}
+	m.n -= int64(n)
+
+	return n, err
+}
+
+// readCloser reads from a wrapper of a body and closes the body itself.
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// UnsupportedMediaTypeError is returned if there is no decoder for the media
+// type of a response.
+type UnsupportedMediaTypeError struct {
+	MediaType string
+}
+
+func (e UnsupportedMediaTypeError) Error() string {
+	if e.MediaType == "" {
+		return "no decoder for response without content type"
+	}
+
+	return fmt.Sprintf("no decoder for media type %s", e.MediaType)
+}
+
+// decoderRegistry maps media types to decoders.
+type decoderRegistry struct {
+	mtx      sync.RWMutex
+	decoders map[string]Decoder
+}
+
+// NewDecoderRegistry returns a registry with decoders for JSON, XML, form
+// urlencoded, plain text and NDJSON.
+func NewDecoderRegistry() *decoderRegistry {
+	r := &decoderRegistry{
+		decoders: map[string]Decoder{},
+	}
+
+	r.Register("application/json", jsonDecoder{})
+	r.Register("application/xml", DecoderFunc(decodeXML))
+	r.Register("text/xml", DecoderFunc(decodeXML))
+	r.Register("application/x-www-form-urlencoded", DecoderFunc(decodeForm))
+	r.Register("text/plain", DecoderFunc(decodeText))
+	r.Register("application/x-ndjson", DecoderFunc(decodeNDJSON))
+	r.Register("application/ndjson", DecoderFunc(decodeNDJSON))
+
+	return r
+}
+
+// DefaultDecoders is the registry used by Decode.
+var DefaultDecoders = NewDecoderRegistry()
+
+// Register sets the decoder of a media type, e.g. application/json. An
+// existing decoder of the media type is replaced.
+func (r *decoderRegistry) Register(mediaType string, d Decoder) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	r.decoders[strings.ToLower(mediaType)] = d
+}
+
+// Lookup returns the decoder of a media type. Media types with a structured
+// syntax suffix like application/vnd.api+json fall back to the decoder of
+// application/json.
+func (r *decoderRegistry) Lookup(mediaType string) (Decoder, bool) {
+	mediaType = strings.ToLower(mediaType)
+
+	r.mtx.RLock()
+	defer r.mtx.RUnlock()
+
+	if d, ok := r.decoders[mediaType]; ok {
+		return d, true
+	}
+
+	if i := strings.LastIndex(mediaType, "+"); i >= 0 {
+		d, ok := r.decoders["application/"+mediaType[i+1:]]
+		return d, ok
+	}
+
+	return nil, false
+}
+
+// Decode decodes the body of the response into the value out points to with
+// the decoder of its content type. The body is closed after reading. The max
+// bytes option applies to all decoders, the other options to the built in
+// JSON decoder only.
+func (r *decoderRegistry) Decode(res *http.Response, out any, opts ...DecodeOption) error {
+	// always close reader after reading
+	defer res.Body.Close()
+
+	var mediaType string
+	if ct := res.Header.Get("Content-Type"); ct != "" {
+		var err error
+		mediaType, _, err = mime.ParseMediaType(ct)
+		if err != nil {
+			return fmt.Errorf("error parsing content type %q: %w", ct, err)
+		}
+	}
+
+	d, ok := r.Lookup(mediaType)
+	if !ok {
+		return UnsupportedMediaTypeError{MediaType: mediaType}
+	}
+
+	o := newDecodeOptions(opts)
+	body := o.reader(res.Body)
+
+	var err error
+	if jd, ok := d.(jsonDecoder); ok {
+		err = jd.decode(body, out, o)
+	} else {
+		err = d.Decode(body, out)
+	}
+	if err != nil {
+		return fmt.Errorf("error decoding %s response body: %w", mediaType, err)
+	}
+
+	return nil
+}
+
+// Decode decodes the body of the response with the DefaultDecoders. The body
+// is closed after reading.
+//
+//	var user User
+//	err := lazyhttp.Decode(res, &user, lazyhttp.WithMaxBytes(1<<20))
+func Decode(res *http.Response, out any, opts ...DecodeOption) error {
+	return DefaultDecoders.Decode(res, out, opts...)
+}
+
+// jsonDecoder is the built in JSON decoder, it supports the decode options.
+type jsonDecoder struct{}
+
+func (d jsonDecoder) Decode(r io.Reader, out any) error {
+	return d.decode(r, out, &decodeOptions{})
+}
+
+func (d jsonDecoder) decode(r io.Reader, out any, o *decodeOptions) error {
+	return o.decodeJSON(r, out)
+}
+
+func decodeXML(r io.Reader, out any) error {
+	return xml.NewDecoder(r).Decode(out)
+}
+
+// decodeForm decodes into *url.Values or *map[string]string, the latter keeps
+// the first value of each key.
+func decodeForm(r io.Reader, out any) error {
+	b, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+
+	values, err := url.ParseQuery(string(b))
+	if err != nil {
+		return err
+	}
+
+	switch out := out.(type) {
+	case *url.Values:
+		*out = values
+	case *map[string][]string:
+		*out = values
+	case *map[string]string:
+		m := make(map[string]string, len(values))
+		for k := range values {
+			m[k] = values.Get(k)
+		}
+		*out = m
+	default:
+		return fmt.Errorf("can not decode form into %T", out)
+	}
+
+	return nil
+}
+
+// decodeText decodes into *string or *[]byte.
+func decodeText(r io.Reader, out any) error {
+	b, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+
+	switch out := out.(type) {
+	case *string:
+		*out = string(b)
+	case *[]byte:
+		*out = b
+	default:
+		return fmt.Errorf("can not decode text into %T", out)
+	}
+
+	return nil
+}
+
+// decodeNDJSON decodes newline delimited JSON values into a pointer to a
+// slice, one element per value.
+func decodeNDJSON(r io.Reader, out any) error {
+	v := reflect.ValueOf(out)
+	if v.Kind() != reflect.Pointer || v.Elem().Kind() != reflect.Slice {
+		return fmt.Errorf("can not decode ndjson into %T, need a pointer to a slice", out)
+	}
+	slice := v.Elem()
+
+	dec := json.NewDecoder(r)
+	for {
+		elem := reflect.New(slice.Type().Elem())
+		err := dec.Decode(elem.Interface())
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+
+		if err != nil {
+			return fmt.Errorf("error decoding value %d: %w", slice.Len(), err)
+		}
+
+		slice.Set(reflect.Append(slice, elem.Elem()))
+	}
+}
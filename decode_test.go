@@ -0,0 +1,214 @@
+package lazyhttp_test
+
+import (
+	"encoding/xml"
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/niksteff/lazyhttp"
+)
+
+type decodeUser struct {
+	XMLName xml.Name `json:"-" xml:"user"`
+	Name    string   `json:"name" xml:"name"`
+	Age     int      `json:"age" xml:"age"`
+}
+
+func newDecodeResponse(contentType string, body string) *http.Response {
+	header := http.Header{}
+	if contentType != "" {
+		header.Set("Content-Type", contentType)
+	}
+
+	return &http.Response{
+		StatusCode: http.StatusOK,
+		Header:     header,
+		Body:       io.NopCloser(strings.NewReader(body)),
+	}
+}
+
+func TestDecode(t *testing.T) {
+	tests := []struct {
+		contentType string
+		body        string
+	}{
+		{"application/json", `{"name":"gopher","age":13}`},
+		{"application/json; charset=utf-8", `{"name":"gopher","age":13}`},
+		{"application/vnd.api+json", `{"name":"gopher","age":13}`},
+		{"APPLICATION/JSON", `{"name":"gopher","age":13}`},
+		{"application/xml", `<user><name>gopher</name><age>13</age></user>`},
+		{"text/xml; charset=utf-8", `<user><name>gopher</name><age>13</age></user>`},
+		{"application/atom+xml", `<user><name>gopher</name><age>13</age></user>`},
+	}
+
+	for _, tt := range tests {
+		var user decodeUser
+		err := lazyhttp.Decode(newDecodeResponse(tt.contentType, tt.body), &user)
+		if err != nil {
+			t.Errorf("did not expect error decoding %s: %+v", tt.contentType, err)
+			continue
+		}
+
+		if user.Name != "gopher" || user.Age != 13 {
+			t.Errorf("expected gopher of 13 for %s but got: %+v", tt.contentType, user)
+		}
+	}
+}
+
+func TestDecodeForm(t *testing.T) {
+	var values url.Values
+	err := lazyhttp.Decode(newDecodeResponse("application/x-www-form-urlencoded", "a=1&a=2&b=3"), &values)
+	if err != nil {
+		t.Errorf("did not expect error decoding form: %+v", err)
+		return
+	}
+
+	if len(values["a"]) != 2 || values.Get("b") != "3" {
+		t.Errorf("expected form values but got: %+v", values)
+	}
+
+	var m map[string]string
+	err = lazyhttp.Decode(newDecodeResponse("application/x-www-form-urlencoded", "a=1&a=2&b=3"), &m)
+	if err != nil {
+		t.Errorf("did not expect error decoding form: %+v", err)
+		return
+	}
+
+	if m["a"] != "1" || m["b"] != "3" {
+		t.Errorf("expected first form values but got: %+v", m)
+	}
+}
+
+func TestDecodeText(t *testing.T) {
+	var s string
+	err := lazyhttp.Decode(newDecodeResponse("text/plain; charset=utf-8", "hello"), &s)
+	if err != nil {
+		t.Errorf("did not expect error decoding text: %+v", err)
+		return
+	}
+
+	if s != "hello" {
+		t.Errorf("expected hello but got: %+v", s)
+	}
+
+	var user decodeUser
+	err = lazyhttp.Decode(newDecodeResponse("text/plain", "hello"), &user)
+	if err == nil {
+		t.Errorf("expected error decoding text into a struct")
+	}
+}
+
+func TestDecodeNDJSON(t *testing.T) {
+	body := `{"name":"a","age":1}
+{"name":"b","age":2}
+
+{"name":"c","age":3}
+`
+
+	var users []decodeUser
+	err := lazyhttp.Decode(newDecodeResponse("application/x-ndjson", body), &users)
+	if err != nil {
+		t.Errorf("did not expect error decoding ndjson: %+v", err)
+		return
+	}
+
+	if len(users) != 3 || users[2].Name != "c" {
+		t.Errorf("expected 3 users but got: %+v", users)
+	}
+
+	var user decodeUser
+	err = lazyhttp.Decode(newDecodeResponse("application/x-ndjson", body), &user)
+	if err == nil {
+		t.Errorf("expected error decoding ndjson into a struct")
+	}
+}
+
+func TestDecodeUnsupportedMediaType(t *testing.T) {
+	tests := []struct {
+		contentType string
+		mediaType   string
+	}{
+		{"image/png", "image/png"},
+		{"application/vnd.foo+yaml", "application/vnd.foo+yaml"},
+		{"", ""},
+	}
+
+	for _, tt := range tests {
+		var out any
+		err := lazyhttp.Decode(newDecodeResponse(tt.contentType, "..."), &out)
+
+		var unsupported lazyhttp.UnsupportedMediaTypeError
+		if !errors.As(err, &unsupported) {
+			t.Errorf("expected UnsupportedMediaTypeError for %q but got: %+v", tt.contentType, err)
+			continue
+		}
+
+		if unsupported.MediaType != tt.mediaType {
+			t.Errorf("expected media type %q but got: %+v", tt.mediaType, unsupported.MediaType)
+		}
+
+		if tt.mediaType != "" && !strings.Contains(err.Error(), tt.mediaType) {
+			t.Errorf("expected error to name the media type but got: %+v", err)
+		}
+	}
+}
+
+func TestDecoderRegistry(t *testing.T) {
+	r := lazyhttp.NewDecoderRegistry()
+	r.Register("text/csv", lazyhttp.DecoderFunc(func(rd io.Reader, out any) error {
+		b, err := io.ReadAll(rd)
+		if err != nil {
+			return err
+		}
+
+		*out.(*[]string) = strings.Split(string(b), ",")
+		return nil
+	}))
+
+	var fields []string
+	err := r.Decode(newDecodeResponse("text/csv", "a,b,c"), &fields)
+	if err != nil {
+		t.Errorf("did not expect error decoding csv: %+v", err)
+		return
+	}
+
+	if len(fields) != 3 {
+		t.Errorf("expected 3 fields but got: %+v", fields)
+	}
+
+	// the default registry is not affected
+	err = lazyhttp.Decode(newDecodeResponse("text/csv", "a,b,c"), &fields)
+	if !errors.As(err, &lazyhttp.UnsupportedMediaTypeError{}) {
+		t.Errorf("expected UnsupportedMediaTypeError but got: %+v", err)
+	}
+}
+
+func TestDecodeOptions(t *testing.T) {
+	body := `{"name":"gopher","age":13,"extra":true} {}`
+
+	var user decodeUser
+	err := lazyhttp.Decode(newDecodeResponse("application/json", body), &user)
+	if err != nil {
+		t.Errorf("did not expect error decoding without options: %+v", err)
+	}
+
+	err = lazyhttp.Decode(newDecodeResponse("application/json", body), &user, lazyhttp.WithRejectTrailingData())
+	if err == nil {
+		t.Errorf("expected trailing data error")
+	}
+
+	err = lazyhttp.Decode(newDecodeResponse("application/vnd.api+json", body), &user, lazyhttp.WithDisallowUnknownFields())
+	if err == nil || !strings.Contains(err.Error(), "extra") {
+		t.Errorf("expected unknown field error but got: %+v", err)
+	}
+
+	var s string
+	err = lazyhttp.Decode(newDecodeResponse("text/plain", "hello"), &s, lazyhttp.WithMaxBytes(4))
+	if !errors.Is(err, lazyhttp.ErrBodyTooLarge) {
+		t.Errorf("expected ErrBodyTooLarge but got: %+v", err)
+	}
+}
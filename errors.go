@@ -1,10 +1,76 @@
 package lazyhttp
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"net"
 	"net/http"
+	"time"
+
+	"github.com/niksteff/lazyhttp/ratelimit"
 )
 
+// The errors of this pkg can be classified with IsTimeout and IsTemporary and
+// unwrap to their cause, so errors.Is and errors.As see through them:
+//
+//   - RequestError: the request could not be built, e.g. a pre request hook
+//     failed (HookError) or its body could not be rewound
+//   - AuthenticationError: the authenticator failed
+//   - RateLimitError: the rate limiter did not admit the request
+//   - CircuitOpenError and BulkheadError: the request was rejected before it
+//     hit the network
+//   - TransportError: the request was sent but no response was received
+//   - StatusError: a response with an unwanted status code was received
+//   - RetryExhaustedError: the retry policy still wanted to retry, but the
+//     backoff gave up
+//   - BackoffError: waiting for or making a retry failed
+//   - ResponseError: handling the response failed, e.g. a post response hook
+//     (HookError)
+
+// ErrMaxRetriesReached is matched by a RetryExhaustedError.
+var ErrMaxRetriesReached error = fmt.Errorf("max retries reached")
+
+// ErrClientClosed is returned for requests made after the client was closed.
+var ErrClientClosed error = fmt.Errorf("client closed")
+
+// ErrBodyTooLarge is returned reading a body that exceeds the max size set
+// with WithMaxBytes or WithMaxResponseSize.
+var ErrBodyTooLarge error = fmt.Errorf("body too large")
+
+// IsTimeout reports whether the error or one of the errors it wraps is a
+// timeout.
+func IsTimeout(err error) bool {
+	var t interface{ Timeout() bool }
+	if errors.As(err, &t) {
+		return t.Timeout()
+	}
+
+	return errors.Is(err, context.DeadlineExceeded)
+}
+
+// IsTemporary reports whether the error or one of the errors it wraps is
+// temporary, so the request may succeed if it is tried again later.
+func IsTemporary(err error) bool {
+	var t interface{ Temporary() bool }
+	if errors.As(err, &t) {
+		return t.Temporary()
+	}
+
+	return false
+}
+
+// isTimeout reports whether the error is a context deadline or a timeout of
+// the network.
+func isTimeout(err error) bool {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
 // RequestError is an error that occurs during a built up of a request. This
 // is not an error that results while performing a request.
 type RequestError struct {
@@ -16,15 +82,204 @@ func (e RequestError) Error() string {
 	return fmt.Sprintf("error making request: %s", e.Err.Error())
 }
 
+func (e RequestError) Unwrap() error {
+	return e.Err
+}
+
+// TransportError is returned if the request was sent but no response was
+// received, e.g. because the connection failed or the attempt timed out.
+type TransportError struct {
+	Err     error
+	Request *http.Request
+}
+
+func (e TransportError) Error() string {
+	return fmt.Sprintf("error making http request: %s", e.Err.Error())
+}
+
+func (e TransportError) Unwrap() error {
+	return e.Err
+}
+
+// Timeout reports whether the request timed out.
+func (e TransportError) Timeout() bool {
+	return isTimeout(e.Err)
+}
+
+// Temporary reports whether the request may succeed later. Only requests that
+// were cancelled by the caller are not temporary.
+func (e TransportError) Temporary() bool {
+	return !errors.Is(e.Err, context.Canceled)
+}
+
+// HookStage is the stage of the request a hook runs in.
+type HookStage string
+
+const (
+	HookPreRequest   HookStage = "pre request"
+	HookPostResponse HookStage = "post response"
+)
+
+// HookError is returned if a hook failed. It is wrapped in a RequestError for
+// pre request hooks and in a ResponseError for post response hooks.
+type HookError struct {
+	Stage HookStage
+	Index int // the position of the hook in the order of execution
+	Err   error
+}
+
+func (e HookError) Error() string {
+	return fmt.Sprintf("error running %s hook %d: %s", e.Stage, e.Index, e.Err.Error())
+}
+
+func (e HookError) Unwrap() error {
+	return e.Err
+}
+
+// StatusError describes a response with an unwanted status code. Header and
+// Body are only set for errors returned because of WithStatusErrors.
+type StatusError struct {
+	StatusCode int
+	Status     string
+	Method     string
+	URL        string // the request url with the password redacted
+	Header     http.Header
+	Body       []byte // the start of the body
+	BodyErr    error  // the error reading the start of the body, if any
+}
+
+// newStatusError returns the StatusError of the response.
+func newStatusError(res *http.Response) StatusError {
+	e := StatusError{
+		StatusCode: res.StatusCode,
+		Status:     res.Status,
+	}
+
+	if res.Request != nil {
+		e.Method = res.Request.Method
+		if res.Request.URL != nil {
+			e.URL = res.Request.URL.Redacted()
+		}
+	}
+
+	return e
+}
+
+func (e StatusError) Error() string {
+	if e.Method == "" {
+		return fmt.Sprintf("unexpected status %d", e.StatusCode)
+	}
+
+	return fmt.Sprintf("%s %s: unexpected status %d", e.Method, e.URL, e.StatusCode)
+}
+
+// Timeout reports whether the server reported a timeout.
+func (e StatusError) Timeout() bool {
+	return e.StatusCode == http.StatusRequestTimeout || e.StatusCode == http.StatusGatewayTimeout
+}
+
+// Temporary reports whether the status code suggests the request may succeed
+// if it is tried again later.
+func (e StatusError) Temporary() bool {
+	switch e.StatusCode {
+	case http.StatusRequestTimeout,
+		http.StatusTooEarly,
+		http.StatusTooManyRequests,
+		http.StatusInternalServerError,
+		http.StatusBadGateway,
+		http.StatusServiceUnavailable,
+		http.StatusGatewayTimeout:
+		return true
+	default:
+		return false
+	}
+}
+
+// RetryExhaustedError is returned if the retry policy still wanted to retry
+// the request but the backoff gave up. The last response is returned with the
+// error and its body has to be closed by the caller. The error matches
+// ErrMaxRetriesReached and the StatusError of the last response.
+type RetryExhaustedError struct {
+	Attempts []Attempt
+	Response *http.Response
+}
+
+func (e RetryExhaustedError) Error() string {
+	if e.Response == nil {
+		return fmt.Sprintf("max retries reached after %d attempts", len(e.Attempts))
+	}
+
+	return fmt.Sprintf("max retries reached after %d attempts: %s", len(e.Attempts), newStatusError(e.Response).Error())
+}
+
+// Unwrap returns ErrMaxRetriesReached and the StatusError of the last
+// response if there is one.
+func (e RetryExhaustedError) Unwrap() []error {
+	if e.Response == nil {
+		return []error{ErrMaxRetriesReached}
+	}
+
+	return []error{ErrMaxRetriesReached, newStatusError(e.Response)}
+}
+
+// Timeout reports false, the attempts did not time out but were not accepted.
+func (e RetryExhaustedError) Timeout() bool {
+	return false
+}
+
+// Temporary reports true, the request may succeed later.
+func (e RetryExhaustedError) Temporary() bool {
+	return true
+}
+
+// BackoffError is returned if the retry loop was interrupted, e.g. because the
+// context was done while waiting for the next attempt or an attempt failed.
+// Attempts holds the history of all attempts.
+type BackoffError struct {
+	Err      error
+	Attempts []Attempt
+}
+
+func (e BackoffError) Error() string {
+	return fmt.Sprintf("error backing off after %d attempts: %s", len(e.Attempts), e.Err)
+}
+
+func (e BackoffError) Unwrap() error {
+	return e.Err
+}
+
+// RateLimitError is returned if the rate limiter did not admit the request.
+// Name is the name of the rate limiter if it has one, see ratelimit.Named.
 type RateLimitError struct {
 	Err         error
 	RateLimiter RateLimiter
+	Name        string
+	Waited      time.Duration // the time spent waiting for the rate limiter
 }
 
 func (e RateLimitError) Error() string {
+	if e.Name != "" {
+		return fmt.Sprintf("rate limit error for %s after %s: %s", e.Name, e.Waited, e.Err.Error())
+	}
+
 	return fmt.Sprintf("rate limit error: %s", e.Err.Error())
 }
 
+func (e RateLimitError) Unwrap() error {
+	return e.Err
+}
+
+// Timeout reports whether no token became available in time.
+func (e RateLimitError) Timeout() bool {
+	return errors.Is(e.Err, context.DeadlineExceeded)
+}
+
+// Temporary reports whether the rate limiter may admit the request later. A
+// closed rate limiter never does.
+func (e RateLimitError) Temporary() bool {
+	return !errors.Is(e.Err, ratelimit.ErrClosed)
+}
+
 type ResponseError struct {
 	Err      error
 	Response *http.Response
@@ -34,6 +289,10 @@ func (e ResponseError) Error() string {
 	return fmt.Sprintf("error handling response: %s", e.Err.Error())
 }
 
+func (e ResponseError) Unwrap() error {
+	return e.Err
+}
+
 type AuthenticationError struct {
 	Err     error
 	Request *http.Request
@@ -42,3 +301,74 @@ type AuthenticationError struct {
 func (e AuthenticationError) Error() string {
 	return fmt.Sprintf("error authenticating request: %s", e.Err.Error())
 }
+
+func (e AuthenticationError) Unwrap() error {
+	return e.Err
+}
+
+// CircuitOpenError is returned if the circuit of the requested host is open or
+// all probe requests of a half-open circuit are in flight. The request did not
+// hit the network.
+type CircuitOpenError struct {
+	Host  string
+	State CircuitState
+	Until time.Time // the time the circuit is going to be half-open, zero if it already is
+}
+
+func (e CircuitOpenError) Error() string {
+	if e.Until.IsZero() {
+		return fmt.Sprintf("circuit breaker %s for host %s", e.State, e.Host)
+	}
+
+	return fmt.Sprintf("circuit breaker %s for host %s until %s", e.State, e.Host, e.Until.Format(time.RFC3339))
+}
+
+// Timeout reports false, the request was rejected right away.
+func (e CircuitOpenError) Timeout() bool {
+	return false
+}
+
+// Temporary reports true, the circuit closes again once the host recovered.
+func (e CircuitOpenError) Temporary() bool {
+	return true
+}
+
+// BulkheadReason describes why the bulkhead rejected a request.
+type BulkheadReason string
+
+const (
+	BulkheadQueueFull   BulkheadReason = "queue full"
+	BulkheadWaitTimeout BulkheadReason = "wait timeout"
+)
+
+// BulkheadError is returned if the bulkhead rejected a request because its
+// queue was full or no slot became free in time. The request did not hit the
+// network.
+type BulkheadError struct {
+	Host   string
+	Reason BulkheadReason
+	Waited time.Duration // the time the request waited for a slot
+	Err    error         // the context error if the wait timed out
+}
+
+func (e BulkheadError) Error() string {
+	if e.Err == nil {
+		return fmt.Sprintf("bulkhead rejected request to host %s: %s", e.Host, e.Reason)
+	}
+
+	return fmt.Sprintf("bulkhead rejected request to host %s after %s: %s: %s", e.Host, e.Waited, e.Reason, e.Err.Error())
+}
+
+func (e BulkheadError) Unwrap() error {
+	return e.Err
+}
+
+// Timeout reports whether no slot became free in time.
+func (e BulkheadError) Timeout() bool {
+	return e.Reason == BulkheadWaitTimeout
+}
+
+// Temporary reports true, slots become free once requests finish.
+func (e BulkheadError) Temporary() bool {
+	return true
+}
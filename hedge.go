@@ -0,0 +1,244 @@
+package lazyhttp
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// HedgingConfig configures hedged requests. If a request did not receive a
+// response within the hedging delay, another copy of the request is sent. The
+// first response wins, all other copies are cancelled.
+type HedgingConfig struct {
+	Delay       time.Duration            // fixed delay before a hedge is sent, also used until enough latency samples are collected
+	Percentile  float64                  // if set, the delay is this percentile of the observed latencies, e.g. 0.95
+	MaxHedges   int                      // max number of additional copies per request
+	MaxRatio    float64                  // max fraction of hedges compared to the total number of requests sent
+	ShouldHedge func(*http.Request) bool // decides whether a request may be hedged, defaults to GET and HEAD requests without a body
+}
+
+const (
+	hedgingSamples    = 512 // number of latencies the percentile is calculated on
+	hedgingMinSamples = 20  // number of latencies needed before the percentile is used
+)
+
+// DefaultShouldHedge only hedges idempotent GET and HEAD requests without a
+// body.
+func DefaultShouldHedge(req *http.Request) bool {
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		return false
+	}
+
+	return req.Body == nil || req.Body == http.NoBody
+}
+
+type hedger struct {
+	conf HedgingConfig
+
+	total  atomic.Int64 // number of requests sent, hedgeable or not
+	hedged atomic.Int64 // number of hedges sent
+
+	mtx       sync.Mutex
+	latencies []time.Duration // ring buffer of the latest latencies
+	next      int
+}
+
+func newHedger(conf HedgingConfig) *hedger {
+	if conf.Delay <= 0 {
+		conf.Delay = 100 * time.Millisecond
+	}
+
+	if conf.MaxHedges <= 0 {
+		conf.MaxHedges = 1
+	}
+
+	if conf.MaxRatio <= 0 {
+		conf.MaxRatio = 0.1
+	}
+
+	if conf.ShouldHedge == nil {
+		conf.ShouldHedge = DefaultShouldHedge
+	}
+
+	return &hedger{
+		conf:      conf,
+		latencies: make([]time.Duration, 0, hedgingSamples),
+	}
+}
+
+// delay returns the time to wait before the next hedge is sent
+func (h *hedger) delay() time.Duration {
+	if h.conf.Percentile <= 0 {
+		return h.conf.Delay
+	}
+
+	h.mtx.Lock()
+	if len(h.latencies) < hedgingMinSamples {
+		h.mtx.Unlock()
+		return h.conf.Delay
+	}
+	sorted := make([]time.Duration, len(h.latencies))
+	copy(sorted, h.latencies)
+	h.mtx.Unlock()
+
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
+	idx := int(float64(len(sorted)-1) * h.conf.Percentile)
+	if idx >= len(sorted) {
+		idx = len(sorted) - 1
+	}
+
+	return sorted[idx]
+}
+
+// observe records the latency of a successful request
+func (h *hedger) observe(d time.Duration) {
+	h.mtx.Lock()
+	defer h.mtx.Unlock()
+
+	if len(h.latencies) < cap(h.latencies) {
+		h.latencies = append(h.latencies, d)
+		return
+	}
+
+	h.latencies[h.next] = d
+	h.next = (h.next + 1) % len(h.latencies)
+}
+
+// allowHedge reserves a hedge if the hedge ratio permits it
+func (h *hedger) allowHedge() bool {
+	for {
+		hedged := h.hedged.Load()
+		if float64(hedged) >= h.conf.MaxRatio*float64(h.total.Load()) {
+			return false
+		}
+
+		if h.hedged.CompareAndSwap(hedged, hedged+1) {
+			return true
+		}
+	}
+}
+
+type hedgeResult struct {
+	idx     int
+	res     *http.Response
+	err     error
+	started time.Time
+	skipped bool // the hedge was never sent because the rate limiter denied it
+}
+
+// cancelOnClose cancels the context of the winning request copy once its body
+// is closed.
+type cancelOnClose struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b *cancelOnClose) Close() error {
+	defer b.cancel()
+	return b.ReadCloser.Close()
+}
+
+// hedgedDo sends the request and hedges it according to the hedging config.
+// Hedges wait for the rate limiter like any other request. A hedge the rate
+// limiter denied is given back, so it does not count against the max ratio.
+func (c *client) hedgedDo(req *http.Request) (*http.Response, error) {
+	h := c.hedger
+
+	ctx := req.Context()
+	results := make(chan hedgeResult, h.conf.MaxHedges+1)
+	cancels := make([]context.CancelFunc, 0, h.conf.MaxHedges+1)
+
+	launch := func(hedge bool) {
+		attemptCtx, cancel := context.WithCancel(ctx)
+		cancels = append(cancels, cancel)
+		idx := len(cancels) - 1
+
+		go func() {
+			if hedge && c.rateLimiter != nil {
+				err := c.waitRateLimiter(attemptCtx, req)
+				if err != nil {
+					results <- hedgeResult{idx: idx, err: err, skipped: true}
+					return
+				}
+			}
+
+			started := time.Now()
+			res, err := c.httpClient.Do(req.Clone(attemptCtx))
+			results <- hedgeResult{idx: idx, res: res, err: err, started: started}
+		}()
+	}
+
+	// abandon cancels all copies but the given one and cleans up their
+	// responses in the background.
+	abandon := func(winner int, inflight int) {
+		for i, cancel := range cancels {
+			if i != winner {
+				cancel()
+			}
+		}
+
+		go func() {
+			for i := 0; i < inflight; i++ {
+				r := <-results
+				if r.res != nil {
+					NoopBodyCloser(r.res.Body)
+				}
+			}
+		}()
+	}
+
+	launch(false)
+	inflight := 1
+
+	timer := time.NewTimer(h.delay())
+	defer timer.Stop()
+
+	var firstErr error
+	for {
+		select {
+		case r := <-results:
+			inflight--
+
+			if r.err == nil {
+				h.observe(time.Since(r.started))
+				abandon(r.idx, inflight)
+
+				// the context of the winner lives as long as its body
+				r.res.Body = &cancelOnClose{ReadCloser: r.res.Body, cancel: cancels[r.idx]}
+				return r.res, nil
+			}
+
+			cancels[r.idx]()
+			if r.skipped {
+				h.hedged.Add(-1)
+			}
+
+			if firstErr == nil && !r.skipped {
+				firstErr = r.err
+			}
+
+			if inflight == 0 {
+				if firstErr == nil {
+					firstErr = r.err
+				}
+				return nil, firstErr
+			}
+		case <-timer.C:
+			if len(cancels) > h.conf.MaxHedges || !h.allowHedge() {
+				continue
+			}
+
+			launch(true)
+			inflight++
+			timer.Reset(h.delay())
+		case <-ctx.Done():
+			abandon(-1, inflight)
+			return nil, ctx.Err()
+		}
+	}
+}
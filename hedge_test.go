@@ -0,0 +1,335 @@
+package lazyhttp_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/niksteff/lazyhttp"
+)
+
+// TestHedgingFirstResponseWins lets the first request hang and checks that the
+// hedge answers the request while the slow copy gets cancelled.
+func TestHedgingFirstResponseWins(t *testing.T) {
+	var requestCount atomic.Int32
+	cancelled := make(chan struct{}, 1)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if requestCount.Add(1) == 1 {
+			select {
+			case <-r.Context().Done():
+				cancelled <- struct{}{}
+			case <-time.After(5 * time.Second):
+			}
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write([]byte("hedge"))
+	})
+
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	addr, err := url.Parse(srv.URL)
+	if err != nil {
+		t.Errorf("did not expect error parsing url: %+v", err)
+		return
+	}
+
+	limiter := &countingRateLimiter{}
+	client := lazyhttp.New(
+		lazyhttp.WithHost(addr),
+		lazyhttp.WithRateLimiter(limiter),
+		lazyhttp.WithHedging(lazyhttp.HedgingConfig{
+			Delay:    50 * time.Millisecond,
+			MaxRatio: 1,
+		}),
+	)
+
+	req, err := http.NewRequestWithContext(context.Background(), http.MethodGet, "/", nil)
+	if err != nil {
+		t.Errorf("did not expect error creating request: %+v", err)
+		return
+	}
+
+	start := time.Now()
+	res, err := client.Do(req)
+	if err != nil {
+		t.Errorf("did not expect error making request: %+v", err)
+		return
+	}
+
+	b, err := io.ReadAll(res.Body)
+	if err != nil {
+		t.Errorf("did not expect error reading body: %+v", err)
+		return
+	}
+	_ = res.Body.Close()
+
+	if string(b) != "hedge" {
+		t.Errorf("expected body of the hedge but got: %s", b)
+	}
+
+	if time.Since(start) > time.Second {
+		t.Errorf("expected the hedge to answer fast but took: %s", time.Since(start))
+	}
+
+	select {
+	case <-cancelled:
+	case <-time.After(time.Second):
+		t.Errorf("expected the slow request to be cancelled")
+	}
+
+	if requestCount.Load() != 2 {
+		t.Errorf("expected 2 requests but got: %d", requestCount.Load())
+	}
+
+	// one wait for the request and one for the hedge
+	if limiter.calls != 2 {
+		t.Errorf("expected 2 rate limiter calls but got: %d", limiter.calls)
+	}
+}
+
+// TestHedgingSkipsUnsafeMethods checks that POST requests are never hedged.
+func TestHedgingSkipsUnsafeMethods(t *testing.T) {
+	var requestCount atomic.Int32
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		requestCount.Add(1)
+		time.Sleep(100 * time.Millisecond)
+		w.WriteHeader(http.StatusOK)
+	})
+
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	addr, err := url.Parse(srv.URL)
+	if err != nil {
+		t.Errorf("did not expect error parsing url: %+v", err)
+		return
+	}
+
+	client := lazyhttp.New(
+		lazyhttp.WithHost(addr),
+		lazyhttp.WithHedging(lazyhttp.HedgingConfig{
+			Delay:    10 * time.Millisecond,
+			MaxRatio: 1,
+		}),
+	)
+
+	req, err := http.NewRequestWithContext(context.Background(), http.MethodPost, "/", nil)
+	if err != nil {
+		t.Errorf("did not expect error creating request: %+v", err)
+		return
+	}
+
+	res, err := client.Do(req)
+	if err != nil {
+		t.Errorf("did not expect error making request: %+v", err)
+		return
+	}
+	lazyhttp.NoopBodyCloser(res.Body)
+
+	if requestCount.Load() != 1 {
+		t.Errorf("expected 1 request but got: %d", requestCount.Load())
+	}
+}
+
+// TestHedgingRespectsRatio checks that no more hedges are sent than the ratio
+// allows.
+func TestHedgingRespectsRatio(t *testing.T) {
+	var requestCount atomic.Int32
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		requestCount.Add(1)
+		time.Sleep(50 * time.Millisecond)
+		w.WriteHeader(http.StatusOK)
+	})
+
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	addr, err := url.Parse(srv.URL)
+	if err != nil {
+		t.Errorf("did not expect error parsing url: %+v", err)
+		return
+	}
+
+	client := lazyhttp.New(
+		lazyhttp.WithHost(addr),
+		lazyhttp.WithHedging(lazyhttp.HedgingConfig{
+			Delay:    5 * time.Millisecond,
+			MaxRatio: 0.25,
+		}),
+	)
+
+	for i := 0; i < 4; i++ {
+		req, err := http.NewRequestWithContext(context.Background(), http.MethodGet, "/", nil)
+		if err != nil {
+			t.Errorf("did not expect error creating request: %+v", err)
+			return
+		}
+
+		res, err := client.Do(req)
+		if err != nil {
+			t.Errorf("did not expect error making request: %+v", err)
+			return
+		}
+		lazyhttp.NoopBodyCloser(res.Body)
+	}
+
+	// give cancelled hedges the chance to reach the server
+	time.Sleep(50 * time.Millisecond)
+
+	if requestCount.Load() != 5 {
+		t.Errorf("expected 4 requests and 1 hedge but got: %d", requestCount.Load())
+	}
+}
+
+// TestHedgingRatioOfAllRequests checks that the max ratio is measured against
+// all requests, not only the hedgeable ones.
+func TestHedgingRatioOfAllRequests(t *testing.T) {
+	var slowCount atomic.Int32
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/fast", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	mux.HandleFunc("/slow", func(w http.ResponseWriter, r *http.Request) {
+		slowCount.Add(1)
+		time.Sleep(50 * time.Millisecond)
+		w.WriteHeader(http.StatusOK)
+	})
+
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	addr, err := url.Parse(srv.URL)
+	if err != nil {
+		t.Errorf("did not expect error parsing url: %+v", err)
+		return
+	}
+
+	client := lazyhttp.New(
+		lazyhttp.WithHost(addr),
+		lazyhttp.WithHedging(lazyhttp.HedgingConfig{
+			Delay:    5 * time.Millisecond,
+			MaxRatio: 0.5,
+		}),
+	)
+
+	do := func(method, path string) error {
+		req, err := http.NewRequestWithContext(context.Background(), method, path, nil)
+		if err != nil {
+			return err
+		}
+
+		res, err := client.Do(req)
+		if err != nil {
+			return err
+		}
+		lazyhttp.NoopBodyCloser(res.Body)
+
+		return nil
+	}
+
+	// requests that are not hedged count towards the total as well
+	for i := 0; i < 3; i++ {
+		if err := do(http.MethodPost, "/fast"); err != nil {
+			t.Errorf("did not expect error making request: %+v", err)
+			return
+		}
+	}
+
+	for i := 0; i < 2; i++ {
+		if err := do(http.MethodGet, "/slow"); err != nil {
+			t.Errorf("did not expect error making request: %+v", err)
+			return
+		}
+	}
+
+	// give cancelled hedges the chance to reach the server
+	time.Sleep(50 * time.Millisecond)
+
+	if slowCount.Load() != 4 {
+		t.Errorf("expected 2 requests and 2 hedges but got: %d", slowCount.Load())
+	}
+}
+
+// denyingRateLimiter denies the given call to Wait and admits all others
+type denyingRateLimiter struct {
+	calls atomic.Int32
+	deny  int32
+}
+
+func (l *denyingRateLimiter) Wait(ctx context.Context) error {
+	if l.calls.Add(1) == l.deny {
+		return errors.New("denied")
+	}
+
+	return nil
+}
+
+// TestHedgingGivesBackDeniedHedges checks that a hedge the rate limiter denied
+// does not count against the max ratio.
+func TestHedgingGivesBackDeniedHedges(t *testing.T) {
+	var requestCount atomic.Int32
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		requestCount.Add(1)
+		time.Sleep(50 * time.Millisecond)
+		w.WriteHeader(http.StatusOK)
+	})
+
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	addr, err := url.Parse(srv.URL)
+	if err != nil {
+		t.Errorf("did not expect error parsing url: %+v", err)
+		return
+	}
+
+	// the first call is the request, the second its hedge
+	client := lazyhttp.New(
+		lazyhttp.WithHost(addr),
+		lazyhttp.WithRateLimiter(&denyingRateLimiter{deny: 2}),
+		lazyhttp.WithHedging(lazyhttp.HedgingConfig{
+			Delay:    5 * time.Millisecond,
+			MaxRatio: 0.5,
+		}),
+	)
+
+	for i := 0; i < 2; i++ {
+		req, err := http.NewRequestWithContext(context.Background(), http.MethodGet, "/", nil)
+		if err != nil {
+			t.Errorf("did not expect error creating request: %+v", err)
+			return
+		}
+
+		res, err := client.Do(req)
+		if err != nil {
+			t.Errorf("did not expect error making request: %+v", err)
+			return
+		}
+		lazyhttp.NoopBodyCloser(res.Body)
+	}
+
+	// give cancelled hedges the chance to reach the server
+	time.Sleep(50 * time.Millisecond)
+
+	if requestCount.Load() != 3 {
+		t.Errorf("expected 2 requests and 1 hedge but got: %d", requestCount.Load())
+	}
+}
@@ -0,0 +1,270 @@
+package lazyhttp
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// ErrElementTooLarge is returned by the JSON array decoder if an element
+// exceeds the max element size.
+var ErrElementTooLarge = fmt.Errorf("json element too large")
+
+// elementReadAhead is the number of bytes the decoder may read beyond the max
+// element size, so the start of the next element can be buffered.
+const elementReadAhead = 4 << 10
+
+// JSONArrayConfig configures a JSON array decoder.
+type JSONArrayConfig struct {
+	Path           string // dot separated object keys leading to the array, e.g. data.items, empty for a top level array
+	MaxElementSize int64  // max bytes of a single element and of a single token skipped on the path, defaults to 1MiB
+}
+
+// limitReader fails reading beyond an absolute limit.
+type limitReader struct {
+	r     io.Reader
+	n     int64 // bytes read
+	limit int64 // max bytes that may be read, negative for no limit
+}
+
+func (l *limitReader) Read(p []byte) (int, error) {
+	if l.limit >= 0 {
+		if l.n >= l.limit {
+			return 0, ErrElementTooLarge
+		}
+
+		if rest := l.limit - l.n; int64(len(p)) > rest {
+			p = p[:rest]
+		}
+	}
+
+	n, err := l.r.Read(p)
+	l.n += int64(n)
+
+	return n, err
+}
+
+// jsonArrayDecoder decodes the elements of a JSON array one at a time.
+type jsonArrayDecoder[T any] struct {
+	ctx  context.Context
+	r    io.Reader
+	lr   *limitReader
+	dec  *json.Decoder
+	conf JSONArrayConfig
+
+	started bool
+	done    bool
+	index   int
+	value   T
+	err     error
+}
+
+// NewJSONArrayDecoder returns a decoder that yields the elements of a JSON
+// array in the reader one at a time, so memory stays flat regardless of the
+// size of the array:
+//
+//	dec := lazyhttp.NewJSONArrayDecoder[Record](ctx, res.Body, lazyhttp.JSONArrayConfig{
+//		Path: "data.records",
+//	})
+//	defer dec.Close()
+//
+//	for dec.Next() {
+//		record := dec.Value()
+//	}
+//	if err := dec.Err(); err != nil {
+//		...
+//	}
+//
+// A null instead of the array yields no elements. Decoding stops once the
+// context is done.
+func NewJSONArrayDecoder[T any](ctx context.Context, r io.Reader, conf JSONArrayConfig) *jsonArrayDecoder[T] {
+	if conf.MaxElementSize <= 0 {
+		conf.MaxElementSize = 1 << 20
+	}
+
+	lr := &limitReader{r: r, limit: -1}
+
+	return &jsonArrayDecoder[T]{
+		ctx:  ctx,
+		r:    r,
+		lr:   lr,
+		dec:  json.NewDecoder(lr),
+		conf: conf,
+	}
+}
+
+// Next decodes the next element. It returns false at the end of the array or
+// on an error, see Err.
+func (d *jsonArrayDecoder[T]) Next() bool {
+	if d.done {
+		return false
+	}
+
+	if err := d.ctx.Err(); err != nil {
+		return d.fail(err)
+	}
+
+	if !d.started {
+		d.started = true
+
+		err := d.open()
+		if err != nil {
+			return d.fail(err)
+		}
+
+		if d.done {
+			return false
+		}
+	}
+
+	// allow the decoder to read the element and a bit of what follows
+	start := d.dec.InputOffset()
+	d.lr.limit = d.lr.n + d.conf.MaxElementSize + elementReadAhead
+
+	if !d.dec.More() {
+		// consume the end of the array
+		_, err := d.dec.Token()
+		if err != nil {
+			return d.fail(fmt.Errorf("error reading end of array: %w", err))
+		}
+
+		d.done = true
+		return false
+	}
+
+	var v T
+	err := d.dec.Decode(&v)
+	if err == nil && d.dec.InputOffset()-start > d.conf.MaxElementSize {
+		err = ErrElementTooLarge
+	}
+
+	if err != nil {
+		return d.fail(fmt.Errorf("error decoding element %d: %w", d.index, err))
+	}
+
+	d.value = v
+	d.index++
+
+	return true
+}
+
+// open walks the path and consumes the start of the array.
+func (d *jsonArrayDecoder[T]) open() error {
+	var walked []string
+	if d.conf.Path != "" {
+		for _, key := range strings.Split(d.conf.Path, ".") {
+			tok, err := d.token()
+			if err != nil {
+				return fmt.Errorf("error reading json: %w", err)
+			}
+
+			if tok != json.Delim('{') {
+				return fmt.Errorf("expected object at %q but got %v", strings.Join(walked, "."), tok)
+			}
+			walked = append(walked, key)
+
+			err = d.findKey(key)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	tok, err := d.token()
+	if err != nil {
+		return fmt.Errorf("error reading json: %w", err)
+	}
+
+	switch tok {
+	case nil:
+		d.done = true
+		return nil
+	case json.Delim('['):
+		return nil
+	default:
+		return fmt.Errorf("expected array at %q but got %v", d.conf.Path, tok)
+	}
+}
+
+// findKey skips the members of the current object up to the value of the key.
+func (d *jsonArrayDecoder[T]) findKey(key string) error {
+	for d.dec.More() {
+		tok, err := d.token()
+		if err != nil {
+			return fmt.Errorf("error reading json: %w", err)
+		}
+
+		if tok == key {
+			return nil
+		}
+
+		err = d.skip()
+		if err != nil {
+			return err
+		}
+	}
+
+	return fmt.Errorf("path %q not found", d.conf.Path)
+}
+
+// token reads the next token of the path walk. Like elements, a single token
+// may not exceed the max element size, so a large value that is skipped is not
+// buffered either.
+func (d *jsonArrayDecoder[T]) token() (json.Token, error) {
+	d.lr.limit = d.lr.n + d.conf.MaxElementSize + elementReadAhead
+
+	return d.dec.Token()
+}
+
+// skip skips the next value token by token, so skipped arrays and objects
+// are not buffered.
+func (d *jsonArrayDecoder[T]) skip() error {
+	depth := 0
+	for {
+		tok, err := d.token()
+		if err != nil {
+			return fmt.Errorf("error reading json: %w", err)
+		}
+
+		switch tok {
+		case json.Delim('{'), json.Delim('['):
+			depth++
+		case json.Delim('}'), json.Delim(']'):
+			depth--
+		}
+
+		if depth == 0 {
+			return nil
+		}
+	}
+}
+
+func (d *jsonArrayDecoder[T]) fail(err error) bool {
+	d.err = err
+	d.done = true
+	return false
+}
+
+// Value returns the element decoded by the last call to Next.
+func (d *jsonArrayDecoder[T]) Value() T {
+	return d.value
+}
+
+// Err returns the error that stopped the decoder, nil at the end of the
+// array.
+func (d *jsonArrayDecoder[T]) Err() error {
+	return d.err
+}
+
+// Close closes the reader if it is an io.Closer.
+func (d *jsonArrayDecoder[T]) Close() error {
+	d.done = true
+
+	if c, ok := d.r.(io.Closer); ok {
+		return c.Close()
+	}
+
+	return nil
+}
@@ -0,0 +1,224 @@
+package lazyhttp_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/niksteff/lazyhttp"
+)
+
+type streamRecord struct {
+	ID   int    `json:"id"`
+	Name string `json:"name"`
+}
+
+func TestJSONArrayDecoder(t *testing.T) {
+	dec := lazyhttp.NewJSONArrayDecoder[streamRecord](context.Background(), strings.NewReader(`[
+		{"id": 1, "name": "a"},
+		{"id": 2, "name": "b"},
+		{"id": 3, "name": "c"}
+	]`), lazyhttp.JSONArrayConfig{})
+	defer dec.Close()
+
+	var ids []int
+	for dec.Next() {
+		ids = append(ids, dec.Value().ID)
+	}
+
+	if err := dec.Err(); err != nil {
+		t.Errorf("did not expect error decoding array: %+v", err)
+		return
+	}
+
+	if fmt.Sprint(ids) != "[1 2 3]" {
+		t.Errorf("expected ids 1, 2 and 3 but got: %+v", ids)
+	}
+}
+
+func TestJSONArrayDecoderPath(t *testing.T) {
+	body := `{
+		"meta": {"skipped": [1, [2, {"a": 3}]], "next": null},
+		"data": {"count": 2, "records": [{"id": 1}, {"id": 2}], "after": true}
+	}`
+
+	dec := lazyhttp.NewJSONArrayDecoder[streamRecord](context.Background(), strings.NewReader(body), lazyhttp.JSONArrayConfig{
+		Path: "data.records",
+	})
+
+	n := 0
+	for dec.Next() {
+		n++
+	}
+
+	if err := dec.Err(); err != nil || n != 2 {
+		t.Errorf("expected 2 records but got %d: %+v", n, err)
+	}
+
+	tests := []struct {
+		body string
+		path string
+		n    int
+		err  string
+	}{
+		{`{"data": null}`, "data", 0, ""},
+		{`{"data": []}`, "data", 0, ""},
+		{`{"other": []}`, "data", 0, "not found"},
+		{`{"data": {"id": 1}}`, "data", 0, "expected array"},
+		{`[{"id": 1}]`, "data", 0, "expected object"},
+		{`[{"id": 1}, "x"]`, "", 1, "element 1"},
+	}
+
+	for _, tt := range tests {
+		dec := lazyhttp.NewJSONArrayDecoder[streamRecord](context.Background(), strings.NewReader(tt.body), lazyhttp.JSONArrayConfig{
+			Path: tt.path,
+		})
+
+		n := 0
+		for dec.Next() {
+			n++
+		}
+
+		if n != tt.n {
+			t.Errorf("expected %d elements for %s but got: %d", tt.n, tt.body, n)
+		}
+
+		err := dec.Err()
+		if tt.err == "" && err != nil {
+			t.Errorf("did not expect error for %s: %+v", tt.body, err)
+		}
+
+		if tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)) {
+			t.Errorf("expected error %q for %s but got: %+v", tt.err, tt.body, err)
+		}
+	}
+}
+
+func TestJSONArrayDecoderElementTooLarge(t *testing.T) {
+	large := strings.Repeat("a", 64<<10)
+	body := `[{"id": 1}, {"name": "` + large + `"}, {"id": 3}]`
+
+	dec := lazyhttp.NewJSONArrayDecoder[streamRecord](context.Background(), strings.NewReader(body), lazyhttp.JSONArrayConfig{
+		MaxElementSize: 1 << 10,
+	})
+
+	n := 0
+	for dec.Next() {
+		n++
+	}
+
+	if n != 1 || !errors.Is(dec.Err(), lazyhttp.ErrElementTooLarge) {
+		t.Errorf("expected ErrElementTooLarge after 1 element but got %d: %+v", n, dec.Err())
+	}
+}
+
+func TestJSONArrayDecoderSkippedValueTooLarge(t *testing.T) {
+	large := strings.Repeat("a", 64<<10)
+	body := `{"meta": "` + large + `", "data": [{"id": 1}]}`
+
+	dec := lazyhttp.NewJSONArrayDecoder[streamRecord](context.Background(), strings.NewReader(body), lazyhttp.JSONArrayConfig{
+		Path:           "data",
+		MaxElementSize: 1 << 10,
+	})
+
+	if dec.Next() || !errors.Is(dec.Err(), lazyhttp.ErrElementTooLarge) {
+		t.Errorf("expected ErrElementTooLarge but got: %+v", dec.Err())
+	}
+
+	// a large object is skipped token by token
+	items := strings.Repeat(`{"id": 0}, `, 8<<10)
+	body = `{"meta": [` + items + `{"id": 0}], "data": [{"id": 1}]}`
+
+	dec = lazyhttp.NewJSONArrayDecoder[streamRecord](context.Background(), strings.NewReader(body), lazyhttp.JSONArrayConfig{
+		Path:           "data",
+		MaxElementSize: 1 << 10,
+	})
+
+	n := 0
+	for dec.Next() {
+		n++
+	}
+
+	if n != 1 || dec.Err() != nil {
+		t.Errorf("expected 1 element but got %d: %+v", n, dec.Err())
+	}
+}
+
+func TestJSONArrayDecoderCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	dec := lazyhttp.NewJSONArrayDecoder[streamRecord](ctx, strings.NewReader(`[{"id": 1}, {"id": 2}]`), lazyhttp.JSONArrayConfig{})
+
+	if !dec.Next() {
+		t.Errorf("expected first element but got: %+v", dec.Err())
+		return
+	}
+
+	cancel()
+
+	if dec.Next() || !errors.Is(dec.Err(), context.Canceled) {
+		t.Errorf("expected context canceled but got: %+v", dec.Err())
+	}
+}
+
+// countingReader counts the bytes read from a reader
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// arrayStream writes a JSON array of n records into a pipe
+func arrayStream(n int) io.Reader {
+	pr, pw := io.Pipe()
+	go func() {
+		_, _ = io.WriteString(pw, `{"records": [`)
+		for i := 0; i < n; i++ {
+			if i > 0 {
+				_, _ = io.WriteString(pw, ",")
+			}
+			_, _ = fmt.Fprintf(pw, `{"id": %d, "name": "%s"}`, i, strings.Repeat("x", 100))
+		}
+		_, _ = io.WriteString(pw, `]}`)
+		_ = pw.Close()
+	}()
+
+	return pr
+}
+
+func TestJSONArrayDecoderStreams(t *testing.T) {
+	// about 12MB of json
+	const n = 100_000
+
+	r := &countingReader{r: arrayStream(n)}
+	dec := lazyhttp.NewJSONArrayDecoder[streamRecord](context.Background(), r, lazyhttp.JSONArrayConfig{
+		Path: "records",
+	})
+
+	count := 0
+	for dec.Next() {
+		if dec.Value().ID != count {
+			t.Errorf("expected id %d but got: %+v", count, dec.Value())
+			return
+		}
+		count++
+
+		// the decoder only reads a little ahead of the current element
+		if count == 1000 && r.n > 1000*130+64<<10 {
+			t.Errorf("expected the stream to be read lazily but read %d bytes", r.n)
+		}
+	}
+
+	if err := dec.Err(); err != nil || count != n {
+		t.Errorf("expected %d records but got %d: %+v", n, count, err)
+	}
+}
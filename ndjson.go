@@ -0,0 +1,214 @@
+package lazyhttp
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// NDJSONConfig configures an NDJSON reader.
+type NDJSONConfig struct {
+	MaxLineSize int // max bytes of a single line, defaults to 1MiB
+}
+
+// ndjsonReader decodes newline delimited JSON one record at a time.
+type ndjsonReader[T any] struct {
+	ctx     context.Context
+	r       io.Reader
+	scanner *bufio.Scanner
+
+	done  bool
+	line  int
+	value T
+	err   error
+}
+
+// NewNDJSONReader returns a reader that decodes the newline delimited JSON of
+// the reader, also known as JSON Lines, one record at a time:
+//
+//	records := lazyhttp.NewNDJSONReader[Event](ctx, res.Body, lazyhttp.NDJSONConfig{})
+//	defer records.Close()
+//
+//	for records.Next() {
+//		event := records.Value()
+//	}
+//	if err := records.Err(); err != nil {
+//		...
+//	}
+//
+// Empty lines are skipped. A line longer than the max line size stops the
+// reader with ErrElementTooLarge.
+func NewNDJSONReader[T any](ctx context.Context, r io.Reader, conf NDJSONConfig) *ndjsonReader[T] {
+	if conf.MaxLineSize <= 0 {
+		conf.MaxLineSize = 1 << 20
+	}
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, min(64<<10, conf.MaxLineSize)), conf.MaxLineSize)
+
+	return &ndjsonReader[T]{
+		ctx:     ctx,
+		r:       r,
+		scanner: scanner,
+	}
+}
+
+// Next decodes the next record. It returns false at the end of the input or
+// on an error, see Err.
+func (d *ndjsonReader[T]) Next() bool {
+	for !d.done {
+		if err := d.ctx.Err(); err != nil {
+			return d.fail(err)
+		}
+
+		if !d.scanner.Scan() {
+			err := d.scanner.Err()
+			if errors.Is(err, bufio.ErrTooLong) {
+				err = ErrElementTooLarge
+			}
+
+			if err != nil {
+				return d.fail(fmt.Errorf("error reading line %d: %w", d.line+1, err))
+			}
+
+			d.done = true
+			return false
+		}
+		d.line++
+
+		line := bytes.TrimSpace(d.scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+
+		var v T
+		err := json.Unmarshal(line, &v)
+		if err != nil {
+			return d.fail(fmt.Errorf("error decoding line %d: %w", d.line, err))
+		}
+
+		d.value = v
+		return true
+	}
+
+	return false
+}
+
+func (d *ndjsonReader[T]) fail(err error) bool {
+	d.err = err
+	d.done = true
+	return false
+}
+
+// Value returns the record decoded by the last call to Next.
+func (d *ndjsonReader[T]) Value() T {
+	return d.value
+}
+
+// Err returns the error that stopped the reader, nil at the end of the input.
+func (d *ndjsonReader[T]) Err() error {
+	return d.err
+}
+
+// Close closes the reader if it is an io.Closer.
+func (d *ndjsonReader[T]) Close() error {
+	d.done = true
+
+	if c, ok := d.r.(io.Closer); ok {
+		return c.Close()
+	}
+
+	return nil
+}
+
+// ndjsonBody is a request body that is written by a producer while it is
+// read.
+type ndjsonBody struct {
+	*io.PipeReader
+	cancel context.CancelFunc
+}
+
+// Close stops the producer and closes the body.
+func (b *ndjsonBody) Close() error {
+	b.cancel()
+	return b.PipeReader.Close()
+}
+
+// NewNDJSONBodyFunc returns a request body that streams the values the
+// producer writes as newline delimited JSON, so a bulk upload is never held
+// in memory as a whole:
+//
+//	body := lazyhttp.NewNDJSONBodyFunc(ctx, func(ctx context.Context, write func(any) error) error {
+//		for rows.Next() {
+//			err := write(rows.Record())
+//			if err != nil {
+//				return err
+//			}
+//		}
+//		return rows.Err()
+//	})
+//	req, _ := http.NewRequestWithContext(ctx, http.MethodPost, "/bulk", body)
+//	req.Header.Set("Content-Type", "application/x-ndjson")
+//
+// The producer runs in its own goroutine. Its context is canceled and write
+// fails once the body is closed, e.g. because the request failed. An error
+// returned by the producer fails the request. The body can not be replayed,
+// so the client returns the first response as it is, even if its retry policy
+// wants to retry.
+func NewNDJSONBodyFunc(ctx context.Context, produce func(ctx context.Context, write func(v any) error) error) io.ReadCloser {
+	ctx, cancel := context.WithCancel(ctx)
+	pr, pw := io.Pipe()
+
+	go func() {
+		defer cancel()
+
+		enc := json.NewEncoder(pw)
+		err := produce(ctx, func(v any) error {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+
+			err := enc.Encode(v)
+			if err != nil {
+				return fmt.Errorf("error encoding ndjson value: %w", err)
+			}
+
+			return nil
+		})
+
+		// a nil error closes the pipe with io.EOF
+		pw.CloseWithError(err)
+	}()
+
+	return &ndjsonBody{
+		PipeReader: pr,
+		cancel:     cancel,
+	}
+}
+
+// NewNDJSONBody returns a request body that streams the values received from
+// the channel as newline delimited JSON until the channel is closed. See
+// NewNDJSONBodyFunc.
+func NewNDJSONBody[T any](ctx context.Context, values <-chan T) io.ReadCloser {
+	return NewNDJSONBodyFunc(ctx, func(ctx context.Context, write func(any) error) error {
+		for {
+			select {
+			case v, ok := <-values:
+				if !ok {
+					return nil
+				}
+
+				err := write(v)
+				if err != nil {
+					return err
+				}
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+	})
+}
@@ -0,0 +1,155 @@
+package lazyhttp_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/niksteff/lazyhttp"
+)
+
+func TestNDJSONReader(t *testing.T) {
+	long := strings.Repeat("x", 100<<10)
+	body := "{\"id\": 1, \"name\": \"a\"}\r\n\n{\"id\": 2, \"name\": \"" + long + "\"}\n{\"id\": 3}"
+
+	records := lazyhttp.NewNDJSONReader[streamRecord](context.Background(), strings.NewReader(body), lazyhttp.NDJSONConfig{})
+	defer records.Close()
+
+	var got []streamRecord
+	for records.Next() {
+		got = append(got, records.Value())
+	}
+
+	if err := records.Err(); err != nil {
+		t.Errorf("did not expect error reading ndjson: %+v", err)
+		return
+	}
+
+	if len(got) != 3 || got[1].Name != long || got[2].ID != 3 {
+		t.Errorf("expected 3 records but got: %d", len(got))
+	}
+}
+
+func TestNDJSONReaderErrors(t *testing.T) {
+	records := lazyhttp.NewNDJSONReader[streamRecord](context.Background(), strings.NewReader("{\"id\": 1}\n{\"id\": \"x\"}\n"), lazyhttp.NDJSONConfig{})
+
+	n := 0
+	for records.Next() {
+		n++
+	}
+
+	if n != 1 || records.Err() == nil || !strings.Contains(records.Err().Error(), "line 2") {
+		t.Errorf("expected error on line 2 but got: %+v", records.Err())
+	}
+
+	records = lazyhttp.NewNDJSONReader[streamRecord](context.Background(), strings.NewReader("{\"id\": 1}\n\""+strings.Repeat("x", 2<<10)+"\"\n"), lazyhttp.NDJSONConfig{
+		MaxLineSize: 1 << 10,
+	})
+
+	n = 0
+	for records.Next() {
+		n++
+	}
+
+	if n != 1 || !errors.Is(records.Err(), lazyhttp.ErrElementTooLarge) {
+		t.Errorf("expected ErrElementTooLarge but got: %+v", records.Err())
+	}
+}
+
+func TestNDJSONBody(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		records := lazyhttp.NewNDJSONReader[streamRecord](r.Context(), r.Body, lazyhttp.NDJSONConfig{})
+
+		n := 0
+		for records.Next() {
+			if records.Value().ID != n {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			n++
+		}
+
+		if records.Err() != nil || n != 1000 {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	values := make(chan streamRecord)
+	go func() {
+		defer close(values)
+		for i := 0; i < 1000; i++ {
+			values <- streamRecord{ID: i}
+		}
+	}()
+
+	body := lazyhttp.NewNDJSONBody(context.Background(), values)
+	req, err := http.NewRequestWithContext(context.Background(), http.MethodPost, srv.URL, body)
+	if err != nil {
+		t.Errorf("did not expect error creating request: %+v", err)
+		return
+	}
+
+	res, err := lazyhttp.New().Do(req)
+	if err != nil {
+		t.Errorf("did not expect error doing request: %+v", err)
+		return
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusNoContent {
+		t.Errorf("expected the server to read 1000 records but got: %+v", res.StatusCode)
+	}
+}
+
+func TestNDJSONBodyFunc(t *testing.T) {
+	body := lazyhttp.NewNDJSONBodyFunc(context.Background(), func(ctx context.Context, write func(any) error) error {
+		for i := 0; i < 2; i++ {
+			err := write(streamRecord{ID: i})
+			if err != nil {
+				return err
+			}
+		}
+
+		return errors.New("database gone")
+	})
+
+	b, err := io.ReadAll(body)
+	if err == nil || err.Error() != "database gone" {
+		t.Errorf("expected the producer error but got: %+v", err)
+	}
+
+	if string(b) != "{\"id\":0,\"name\":\"\"}\n{\"id\":1,\"name\":\"\"}\n" {
+		t.Errorf("expected 2 lines but got: %s", b)
+	}
+}
+
+func TestNDJSONBodyClose(t *testing.T) {
+	stopped := make(chan error, 1)
+	body := lazyhttp.NewNDJSONBodyFunc(context.Background(), func(ctx context.Context, write func(any) error) error {
+		<-ctx.Done()
+
+		err := write(streamRecord{})
+		stopped <- err
+		return err
+	})
+
+	_ = body.Close()
+
+	select {
+	case err := <-stopped:
+		if err == nil {
+			t.Errorf("expected write to fail after close")
+		}
+	case <-time.After(time.Second):
+		t.Errorf("expected the producer to stop after close")
+	}
+}
@@ -0,0 +1,142 @@
+package lazyhttp
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+)
+
+// maxProblemSize is the max size of a problem details body that is decoded.
+const maxProblemSize = 1 << 20
+
+// ProblemError is a problem details object as defined by RFC 9457, returned by
+// APIs with the media type application/problem+json.
+type ProblemError struct {
+	Type       string         // a URI reference identifying the problem type, defaults to about:blank
+	Title      string         // a short summary of the problem type
+	Status     int            // the status code of the response, 0 if missing
+	Detail     string         // an explanation specific to this occurrence
+	Instance   string         // a URI reference identifying this occurrence
+	Extensions map[string]any // all other members of the problem
+}
+
+func (e ProblemError) Error() string {
+	msg := fmt.Sprintf("problem %s", e.Type)
+	if e.Title != "" {
+		msg += ": " + e.Title
+	}
+
+	if e.Status != 0 {
+		msg += fmt.Sprintf(" (status %d)", e.Status)
+	}
+
+	if e.Detail != "" {
+		msg += ": " + e.Detail
+	}
+
+	return msg
+}
+
+// Timeout reports whether the status of the problem is a timeout.
+func (e ProblemError) Timeout() bool {
+	return StatusError{StatusCode: e.Status}.Timeout()
+}
+
+// Temporary reports whether the status of the problem suggests the request
+// may succeed later.
+func (e ProblemError) Temporary() bool {
+	return StatusError{StatusCode: e.Status}.Temporary()
+}
+
+// IsProblem reports whether the response carries problem details.
+func IsProblem(res *http.Response) bool {
+	mediaType, _, err := mime.ParseMediaType(res.Header.Get("Content-Type"))
+	return err == nil && mediaType == "application/problem+json"
+}
+
+// DecodeProblem reads the problem details of the response. The body is
+// closed after reading.
+func DecodeProblem(res *http.Response) (ProblemError, error) {
+	defer res.Body.Close()
+
+	b, err := io.ReadAll(io.LimitReader(res.Body, maxProblemSize))
+	if err != nil {
+		return ProblemError{}, fmt.Errorf("error reading response body: %w", err)
+	}
+
+	return parseProblem(b, res.StatusCode)
+}
+
+// parseProblem parses problem details. Members of the wrong type are ignored
+// as required by the RFC. A missing status is taken from the response.
+func parseProblem(b []byte, statusCode int) (ProblemError, error) {
+	var members map[string]json.RawMessage
+	err := json.Unmarshal(b, &members)
+	if err != nil {
+		return ProblemError{}, fmt.Errorf("error unmarshaling problem details: %w", err)
+	}
+
+	p := ProblemError{
+		Type:   "about:blank",
+		Status: statusCode,
+	}
+
+	for name, raw := range members {
+		var target any
+		switch name {
+		case "type":
+			target = &p.Type
+		case "title":
+			target = &p.Title
+		case "status":
+			target = &p.Status
+		case "detail":
+			target = &p.Detail
+		case "instance":
+			target = &p.Instance
+		default:
+			var v any
+			if json.Unmarshal(raw, &v) == nil {
+				if p.Extensions == nil {
+					p.Extensions = map[string]any{}
+				}
+				p.Extensions[name] = v
+			}
+			continue
+		}
+
+		// a member of the wrong type keeps its default
+		_ = json.Unmarshal(raw, target)
+	}
+
+	return p, nil
+}
+
+// ProblemHook is a post response hook that turns responses with problem
+// details into a ProblemError. The client returns it wrapped in a
+// ResponseError, so it can be matched with errors.As. The body of the response
+// is replaced with the decoded bytes and can be read again.
+//
+//	client := lazyhttp.New(lazyhttp.WithPostResponseHooks(lazyhttp.ProblemHook))
+func ProblemHook(res *http.Response) error {
+	if res.StatusCode < 400 || !IsProblem(res) {
+		return nil
+	}
+
+	b, err := io.ReadAll(io.LimitReader(res.Body, maxProblemSize))
+	_ = res.Body.Close()
+	res.Body = io.NopCloser(bytes.NewReader(b))
+	if err != nil {
+		return fmt.Errorf("error reading response body: %w", err)
+	}
+
+	p, err := parseProblem(b, res.StatusCode)
+	if err != nil {
+		return err
+	}
+
+	return p
+}
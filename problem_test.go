@@ -0,0 +1,114 @@
+package lazyhttp_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/niksteff/lazyhttp"
+)
+
+const testProblem = `{
+	"type": "https://example.com/probs/out-of-credit",
+	"title": "You do not have enough credit.",
+	"status": 403,
+	"detail": "Your current balance is 30, but that costs 50.",
+	"instance": "/account/12345/msgs/abc",
+	"balance": 30,
+	"accounts": ["/account/12345", "/account/67890"]
+}`
+
+func TestDecodeProblem(t *testing.T) {
+	res := &http.Response{
+		StatusCode: http.StatusForbidden,
+		Header:     http.Header{"Content-Type": []string{"application/problem+json; charset=utf-8"}},
+		Body:       io.NopCloser(strings.NewReader(testProblem)),
+	}
+
+	if !lazyhttp.IsProblem(res) {
+		t.Errorf("expected response to be a problem")
+	}
+
+	p, err := lazyhttp.DecodeProblem(res)
+	if err != nil {
+		t.Errorf("did not expect error decoding problem: %+v", err)
+		return
+	}
+
+	if p.Type != "https://example.com/probs/out-of-credit" || p.Title != "You do not have enough credit." {
+		t.Errorf("expected type and title but got: %+v", p)
+	}
+
+	if p.Status != http.StatusForbidden || p.Instance != "/account/12345/msgs/abc" {
+		t.Errorf("expected status and instance but got: %+v", p)
+	}
+
+	if p.Extensions["balance"] != float64(30) {
+		t.Errorf("expected balance extension but got: %+v", p.Extensions)
+	}
+
+	if accounts, ok := p.Extensions["accounts"].([]any); !ok || len(accounts) != 2 {
+		t.Errorf("expected accounts extension but got: %+v", p.Extensions)
+	}
+}
+
+func TestDecodeProblemDefaults(t *testing.T) {
+	// the status has the wrong type and is taken from the response instead
+	res := &http.Response{
+		StatusCode: http.StatusServiceUnavailable,
+		Body:       io.NopCloser(strings.NewReader(`{"status": "503", "title": "Unavailable"}`)),
+	}
+
+	p, err := lazyhttp.DecodeProblem(res)
+	if err != nil {
+		t.Errorf("did not expect error decoding problem: %+v", err)
+		return
+	}
+
+	if p.Type != "about:blank" || p.Status != http.StatusServiceUnavailable {
+		t.Errorf("expected defaults but got: %+v", p)
+	}
+
+	if !lazyhttp.IsTemporary(p) {
+		t.Errorf("expected a 503 problem to be temporary")
+	}
+}
+
+func TestProblemHook(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/problem+json")
+		w.WriteHeader(http.StatusForbidden)
+		_, _ = w.Write([]byte(testProblem))
+	}))
+	defer srv.Close()
+
+	client := lazyhttp.New(lazyhttp.WithPostResponseHooks(lazyhttp.ProblemHook))
+
+	req, err := http.NewRequestWithContext(context.Background(), http.MethodGet, srv.URL, nil)
+	if err != nil {
+		t.Errorf("did not expect error creating request: %+v", err)
+		return
+	}
+
+	res, err := client.Do(req)
+
+	var problem lazyhttp.ProblemError
+	if !errors.As(err, &problem) {
+		t.Errorf("expected ProblemError but got: %+v", err)
+		return
+	}
+
+	if problem.Detail != "Your current balance is 30, but that costs 50." {
+		t.Errorf("expected the detail but got: %s", problem.Detail)
+	}
+
+	// the body can be read again
+	b, err := lazyhttp.DecodeBytes(res.Body)
+	if err != nil || string(b) != testProblem {
+		t.Errorf("expected the body to be readable but got: %q, %+v", b, err)
+	}
+}
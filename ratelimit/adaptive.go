@@ -0,0 +1,326 @@
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+)
+
+// Listener is handed out for every request admitted by a concurrency limiter.
+// Exactly one of its methods has to be called once the outcome of the request
+// is known. Calling more than one method or a method multiple times is a no-op.
+type Listener interface {
+	// OnSuccess reports that the request succeeded. The time since the request
+	// was admitted is used as latency sample.
+	OnSuccess()
+	// OnDropped reports that the request timed out or was rejected by an
+	// overloaded server. This is a strong signal to reduce the limit.
+	OnDropped()
+	// OnFailure reports that the request failed for a reason unrelated to the
+	// load of the server. The request is not used as sample.
+	OnFailure()
+}
+
+// ConcurrencyLimitError is returned if a request could not be admitted before
+// its context was done.
+type ConcurrencyLimitError struct {
+	Limit int
+	Err   error
+}
+
+func (e ConcurrencyLimitError) Error() string {
+	return fmt.Sprintf("concurrency limit of %d reached: %s", e.Limit, e.Err.Error())
+}
+
+func (e ConcurrencyLimitError) Unwrap() error {
+	return e.Err
+}
+
+type outcome int
+
+const (
+	outcomeSuccess outcome = iota
+	outcomeDropped
+	outcomeFailure
+)
+
+// limitAlgorithm calculates the new limit from a single sample. It is always
+// called while the limiter holds its lock.
+type limitAlgorithm interface {
+	update(limit int, inflight int, rtt time.Duration, o outcome) int
+}
+
+// adaptiveLimiter limits the number of requests in flight and adjusts the limit
+// with the given algorithm based on the observed latency and drops.
+type adaptiveLimiter struct {
+	algorithm limitAlgorithm
+	min       int
+	max       int
+
+	mtx      *sync.Mutex
+	limit    int
+	inflight int
+	changed  chan struct{} // closed and replaced whenever a slot may be free
+	closed   bool
+}
+
+func newAdaptiveLimiter(algorithm limitAlgorithm, initial, min, max int) *adaptiveLimiter {
+	if min <= 0 {
+		min = 1
+	}
+
+	if max < min {
+		max = 1000
+	}
+
+	if initial < min {
+		initial = min
+	}
+
+	if initial > max {
+		initial = max
+	}
+
+	return &adaptiveLimiter{
+		algorithm: algorithm,
+		min:       min,
+		max:       max,
+		mtx:       &sync.Mutex{},
+		limit:     initial,
+		changed:   make(chan struct{}),
+	}
+}
+
+// Acquire blocks until the request can be admitted or the context is done.
+func (l *adaptiveLimiter) Acquire(ctx context.Context) (Listener, error) {
+	for {
+		l.mtx.Lock()
+		if l.closed {
+			l.mtx.Unlock()
+			return nil, ErrClosed
+		}
+
+		if l.inflight < l.limit {
+			l.inflight++
+			l.mtx.Unlock()
+
+			return &adaptiveListener{l: l, start: time.Now()}, nil
+		}
+		changed := l.changed
+		limit := l.limit
+		l.mtx.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return nil, ConcurrencyLimitError{
+				Limit: limit,
+				Err:   ctx.Err(),
+			}
+		case <-changed:
+		}
+	}
+}
+
+// Close closes the limiter. Waiting and future calls to Acquire return
+// ErrClosed, requests already admitted may still report their outcome.
+// Closing the limiter multiple times is a no-op.
+func (l *adaptiveLimiter) Close() error {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+
+	if l.closed {
+		return nil
+	}
+	l.closed = true
+
+	// wake up all waiters so they see the limiter is closed
+	close(l.changed)
+	l.changed = make(chan struct{})
+
+	return nil
+}
+
+// Limit returns the current concurrency limit.
+func (l *adaptiveLimiter) Limit() int {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+
+	return l.limit
+}
+
+// InFlight returns the number of admitted requests that did not report their
+// outcome yet.
+func (l *adaptiveLimiter) InFlight() int {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+
+	return l.inflight
+}
+
+func (l *adaptiveLimiter) release(rtt time.Duration, o outcome) {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+
+	// the inflight count includes the request reporting its outcome, so the
+	// algorithm can tell whether the limit was actually used.
+	if o != outcomeFailure {
+		limit := l.algorithm.update(l.limit, l.inflight, rtt, o)
+		l.limit = int(math.Max(float64(l.min), math.Min(float64(l.max), float64(limit))))
+	}
+	l.inflight--
+
+	// wake up all waiters, they check for a free slot themselves
+	close(l.changed)
+	l.changed = make(chan struct{})
+}
+
+type adaptiveListener struct {
+	l     *adaptiveLimiter
+	start time.Time
+	once  sync.Once
+}
+
+func (a *adaptiveListener) OnSuccess() {
+	a.once.Do(func() { a.l.release(time.Since(a.start), outcomeSuccess) })
+}
+
+func (a *adaptiveListener) OnDropped() {
+	a.once.Do(func() { a.l.release(time.Since(a.start), outcomeDropped) })
+}
+
+func (a *adaptiveListener) OnFailure() {
+	a.once.Do(func() { a.l.release(time.Since(a.start), outcomeFailure) })
+}
+
+// AIMDConfig configures an additive increase multiplicative decrease limiter.
+type AIMDConfig struct {
+	InitialLimit int           // the limit to start with
+	MinLimit     int           // the limit never drops below this value
+	MaxLimit     int           // the limit never grows above this value, defaults to 1000
+	BackoffRatio float64       // the limit is multiplied with this ratio on a drop, between 0.5 and 1
+	Timeout      time.Duration // successful requests slower than this count as dropped
+}
+
+type aimd struct {
+	backoffRatio float64
+	timeout      time.Duration
+}
+
+// NewAIMDLimiter returns a concurrency limiter that increases the limit by one
+// for every successful request while the limit is used and multiplies it with
+// the backoff ratio on every drop.
+func NewAIMDLimiter(conf AIMDConfig) *adaptiveLimiter {
+	if conf.InitialLimit <= 0 {
+		conf.InitialLimit = 20
+	}
+
+	if conf.BackoffRatio < 0.5 || conf.BackoffRatio >= 1 {
+		conf.BackoffRatio = 0.9
+	}
+
+	if conf.Timeout <= 0 {
+		conf.Timeout = 5 * time.Second
+	}
+
+	return newAdaptiveLimiter(&aimd{
+		backoffRatio: conf.BackoffRatio,
+		timeout:      conf.Timeout,
+	}, conf.InitialLimit, conf.MinLimit, conf.MaxLimit)
+}
+
+func (a *aimd) update(limit int, inflight int, rtt time.Duration, o outcome) int {
+	if o == outcomeDropped || rtt > a.timeout {
+		return int(float64(limit) * a.backoffRatio)
+	}
+
+	// only grow if the limit is actually used, otherwise an idle client would
+	// grow its limit endlessly.
+	if inflight*2 >= limit {
+		return limit + 1
+	}
+
+	return limit
+}
+
+// GradientConfig configures a gradient limiter.
+type GradientConfig struct {
+	InitialLimit int     // the limit to start with
+	MinLimit     int     // the limit never drops below this value
+	MaxLimit     int     // the limit never grows above this value, defaults to 1000
+	Smoothing    float64 // how fast the limit follows the calculated limit, between 0 and 1
+	Tolerance    float64 // how much the latency may exceed the long term latency before the limit is reduced, at least 1
+	BackoffRatio float64 // the limit is multiplied with this ratio on a drop, between 0.5 and 1
+}
+
+type gradient struct {
+	smoothing    float64
+	tolerance    float64
+	backoffRatio float64
+	longRTT      float64 // exponentially weighted long term latency in nanoseconds
+}
+
+// NewGradientLimiter returns a concurrency limiter in the style of a Vegas or
+// gradient limiter. It compares every latency sample with the long term
+// latency. While the latency stays within the tolerance the limit grows by the
+// square root of the limit, an increasing latency reduces the limit
+// proportionally.
+func NewGradientLimiter(conf GradientConfig) *adaptiveLimiter {
+	if conf.InitialLimit <= 0 {
+		conf.InitialLimit = 20
+	}
+
+	if conf.Smoothing <= 0 || conf.Smoothing > 1 {
+		conf.Smoothing = 0.2
+	}
+
+	if conf.Tolerance < 1 {
+		conf.Tolerance = 1.5
+	}
+
+	if conf.BackoffRatio < 0.5 || conf.BackoffRatio >= 1 {
+		conf.BackoffRatio = 0.9
+	}
+
+	return newAdaptiveLimiter(&gradient{
+		smoothing:    conf.Smoothing,
+		tolerance:    conf.Tolerance,
+		backoffRatio: conf.BackoffRatio,
+	}, conf.InitialLimit, conf.MinLimit, conf.MaxLimit)
+}
+
+func (g *gradient) update(limit int, inflight int, rtt time.Duration, o outcome) int {
+	if o == outcomeDropped {
+		return int(float64(limit) * g.backoffRatio)
+	}
+
+	sample := float64(rtt)
+	if sample <= 0 {
+		sample = 1
+	}
+
+	if g.longRTT == 0 {
+		g.longRTT = sample
+	}
+	// the long term latency adapts slowly, so a sustained latency increase is
+	// detected before it becomes the new normal
+	g.longRTT = g.longRTT*0.95 + sample*0.05
+
+	// do not grow the limit if it is not used
+	if inflight*2 < limit && sample <= g.longRTT*g.tolerance {
+		return limit
+	}
+
+	grad := math.Max(0.5, math.Min(1, g.tolerance*g.longRTT/sample))
+	queue := math.Sqrt(float64(limit))
+	target := float64(limit)*grad + queue
+
+	next := float64(limit)*(1-g.smoothing) + target*g.smoothing
+	if next > float64(limit) {
+		// round up, otherwise small limits would never grow
+		return int(math.Ceil(next))
+	}
+
+	return int(next)
+}
@@ -0,0 +1,144 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestAdaptiveLimiterBlocksAtLimit(t *testing.T) {
+	limiter := NewAIMDLimiter(AIMDConfig{InitialLimit: 2, MaxLimit: 2})
+
+	first, err := limiter.Acquire(context.Background())
+	if err != nil {
+		t.Errorf("Expected no error, but got %v", err)
+		return
+	}
+
+	_, err = limiter.Acquire(context.Background())
+	if err != nil {
+		t.Errorf("Expected no error, but got %v", err)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	_, err = limiter.Acquire(ctx)
+	var limitErr ConcurrencyLimitError
+	if !errors.As(err, &limitErr) {
+		t.Errorf("Expected ConcurrencyLimitError, but got %v", err)
+		return
+	}
+
+	// releasing a slot unblocks a waiting request
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		first.OnFailure()
+	}()
+
+	_, err = limiter.Acquire(context.Background())
+	if err != nil {
+		t.Errorf("Expected no error, but got %v", err)
+	}
+
+	if limiter.InFlight() != 2 {
+		t.Errorf("Expected 2 requests in flight, but got %d", limiter.InFlight())
+	}
+}
+
+func TestAIMDLimiterAdjustsLimit(t *testing.T) {
+	limiter := NewAIMDLimiter(AIMDConfig{InitialLimit: 2, BackoffRatio: 0.5})
+
+	// a fully used limit grows on success
+	a, _ := limiter.Acquire(context.Background())
+	b, _ := limiter.Acquire(context.Background())
+	a.OnSuccess()
+
+	if limiter.Limit() != 3 {
+		t.Errorf("Expected limit to grow to 3, but got %d", limiter.Limit())
+	}
+
+	// an unused limit does not grow
+	b.OnSuccess()
+
+	if limiter.Limit() != 3 {
+		t.Errorf("Expected limit to stay at 3, but got %d", limiter.Limit())
+	}
+
+	// a drop halves the limit
+	c, _ := limiter.Acquire(context.Background())
+	d, _ := limiter.Acquire(context.Background())
+	c.OnDropped()
+
+	if limiter.Limit() != 1 {
+		t.Errorf("Expected limit to drop to 1, but got %d", limiter.Limit())
+	}
+
+	// a failure releases the slot without changing the limit
+	d.OnFailure()
+	if limiter.Limit() != 1 || limiter.InFlight() != 0 {
+		t.Errorf("Expected limit 1 and nothing in flight, but got %d and %d", limiter.Limit(), limiter.InFlight())
+	}
+
+	// reporting twice is a no-op
+	c.OnDropped()
+	if limiter.Limit() != 1 || limiter.InFlight() != 0 {
+		t.Errorf("Expected limit 1 and nothing in flight, but got %d and %d", limiter.Limit(), limiter.InFlight())
+	}
+}
+
+func TestGradientLimiterFollowsLatency(t *testing.T) {
+	g := &gradient{smoothing: 0.5, tolerance: 1.5, backoffRatio: 0.9}
+
+	limit := 20
+	for i := 0; i < 20; i++ {
+		limit = g.update(limit, limit, 10*time.Millisecond, outcomeSuccess)
+	}
+
+	if limit <= 20 {
+		t.Errorf("Expected limit to grow with a stable latency, but got %d", limit)
+	}
+
+	grown := limit
+	for i := 0; i < 5; i++ {
+		limit = g.update(limit, limit, 100*time.Millisecond, outcomeSuccess)
+	}
+
+	if limit >= grown {
+		t.Errorf("Expected limit to shrink with an increasing latency, but got %d", limit)
+	}
+
+	shrunk := limit
+	limit = g.update(limit, limit, 10*time.Millisecond, outcomeDropped)
+	if limit >= shrunk {
+		t.Errorf("Expected limit to shrink on a drop, but got %d", limit)
+	}
+}
+
+func TestAdaptiveLimiterClose(t *testing.T) {
+	limiter := NewAIMDLimiter(AIMDConfig{InitialLimit: 1, MaxLimit: 1})
+
+	admitted, err := limiter.Acquire(context.Background())
+	if err != nil {
+		t.Errorf("Expected no error, but got %v", err)
+		return
+	}
+
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		_ = limiter.Close()
+	}()
+
+	_, err = limiter.Acquire(context.Background())
+	if !errors.Is(err, ErrClosed) {
+		t.Errorf("Expected ErrClosed, but got %v", err)
+	}
+
+	// admitted requests may still report their outcome
+	admitted.OnSuccess()
+	if limiter.InFlight() != 0 {
+		t.Errorf("Expected nothing in flight, but got %d", limiter.InFlight())
+	}
+}
@@ -0,0 +1,290 @@
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+)
+
+// Rate is the number of tokens added to a bucket per second.
+type Rate float64
+
+// Every returns the rate of one token per the given interval.
+func Every(interval time.Duration) Rate {
+	if interval <= 0 {
+		return Rate(math.Inf(1))
+	}
+
+	return Rate(float64(time.Second) / float64(interval))
+}
+
+// Per returns the rate of n tokens per the given interval.
+func Per(n int, interval time.Duration) Rate {
+	return Every(interval) * Rate(n)
+}
+
+// durationFor returns the time it takes to add the given tokens
+func (r Rate) durationFor(tokens float64) time.Duration {
+	if r <= 0 {
+		return time.Duration(math.MaxInt64)
+	}
+
+	return time.Duration(tokens / float64(r) * float64(time.Second))
+}
+
+// tokensFor returns the tokens added in the given duration
+func (r Rate) tokensFor(d time.Duration) float64 {
+	return d.Seconds() * float64(r)
+}
+
+// tokenBucket is a token bucket defined by a rate and a burst. Tokens are
+// calculated from the elapsed time whenever the bucket is used, so there is no
+// background goroutine and tokens are added continuously instead of all at
+// once.
+type tokenBucket struct {
+	rate  Rate
+	burst int
+	now   func() time.Time // replaceable for tests
+	*recorder
+
+	mtx    *sync.Mutex
+	tokens float64
+	last   time.Time // the last time tokens were calculated
+
+	done      chan struct{} // closed once the bucket is closed
+	closeOnce *sync.Once
+}
+
+// NewTokenBucket returns a token bucket that is refilled with the given rate
+// and holds at most burst tokens. The bucket starts full.
+func NewTokenBucket(rate Rate, burst int) *tokenBucket {
+	return &tokenBucket{
+		rate:     rate,
+		burst:    burst,
+		now:      time.Now,
+		recorder: newRecorder(),
+		mtx:      &sync.Mutex{},
+		tokens:   float64(burst),
+		last:     time.Now(),
+
+		done:      make(chan struct{}),
+		closeOnce: &sync.Once{},
+	}
+}
+
+// closed reports whether the bucket was closed
+func (b *tokenBucket) closed() bool {
+	select {
+	case <-b.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// Close closes the bucket. Waiting and future calls to Wait and WaitN return
+// ErrClosed, Allow and AllowN report false. Closing the bucket multiple times
+// is a no-op.
+func (b *tokenBucket) Close() error {
+	b.closeOnce.Do(func() {
+		close(b.done)
+	})
+
+	return nil
+}
+
+// advance adds the tokens for the time passed since the last call. The caller
+// must hold the lock.
+func (b *tokenBucket) advance(now time.Time) {
+	if now.Before(b.last) {
+		return
+	}
+
+	if math.IsInf(float64(b.rate), 1) {
+		b.tokens = float64(b.burst)
+		b.last = now
+		return
+	}
+
+	b.tokens = math.Min(float64(b.burst), b.tokens+b.rate.tokensFor(now.Sub(b.last)))
+	b.last = now
+}
+
+// Allow reports whether a token is available now and takes it if so.
+func (b *tokenBucket) Allow() bool {
+	return b.AllowN(1)
+}
+
+// AllowN reports whether n tokens are available now and takes them if so.
+func (b *tokenBucket) AllowN(n int) bool {
+	if b.closed() {
+		return false
+	}
+
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+
+	b.advance(b.now())
+	if b.tokens < float64(n) {
+		return false
+	}
+
+	b.tokens -= float64(n)
+	return true
+}
+
+// Reservation holds tokens that become available after a delay. A reservation
+// that is not used has to be cancelled to give its tokens back.
+type Reservation struct {
+	ok     bool
+	tokens int
+	at     time.Time // the time the tokens are available
+	bucket *tokenBucket
+	mtx    sync.Mutex
+	done   bool
+}
+
+// OK reports whether the tokens could be reserved. A reservation of more tokens
+// than the burst of the bucket never succeeds.
+func (r *Reservation) OK() bool {
+	return r.ok
+}
+
+// Delay returns the time until the reserved tokens are available.
+func (r *Reservation) Delay() time.Duration {
+	if !r.ok {
+		return time.Duration(math.MaxInt64)
+	}
+
+	d := r.at.Sub(r.bucket.now())
+	if d < 0 {
+		return 0
+	}
+
+	return d
+}
+
+// Cancel gives the reserved tokens back to the bucket. Cancelling a reservation
+// multiple times is a no-op.
+func (r *Reservation) Cancel() {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	if !r.ok || r.done {
+		return
+	}
+	r.done = true
+
+	r.bucket.mtx.Lock()
+	defer r.bucket.mtx.Unlock()
+
+	r.bucket.advance(r.bucket.now())
+	r.bucket.tokens = math.Min(float64(r.bucket.burst), r.bucket.tokens+float64(r.tokens))
+}
+
+// Reserve reserves a single token. See ReserveN.
+func (b *tokenBucket) Reserve() *Reservation {
+	return b.ReserveN(1)
+}
+
+// ReserveN reserves n tokens and returns a reservation that tells the caller
+// how long to wait until the tokens are available. The tokens are taken from
+// the bucket immediately, so later callers wait behind this reservation.
+func (b *tokenBucket) ReserveN(n int) *Reservation {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+
+	if n > b.burst {
+		return &Reservation{ok: false, bucket: b}
+	}
+
+	now := b.now()
+	b.advance(now)
+	b.tokens -= float64(n)
+
+	var wait time.Duration
+	if b.tokens < 0 {
+		wait = b.rate.durationFor(-b.tokens)
+	}
+
+	return &Reservation{
+		ok:     true,
+		tokens: n,
+		at:     now.Add(wait),
+		bucket: b,
+	}
+}
+
+// Wait blocks until a token is available or the context is done.
+func (b *tokenBucket) Wait(ctx context.Context) error {
+	return b.WaitN(ctx, 1)
+}
+
+// WaitN blocks until n tokens are available or the context is done. If the
+// tokens can not become available before the deadline of the context, WaitN
+// returns immediately without taking any tokens.
+func (b *tokenBucket) WaitN(ctx context.Context, n int) error {
+	record := b.recorder.begin()
+	err := b.waitN(ctx, n)
+	record(err)
+
+	return err
+}
+
+func (b *tokenBucket) waitN(ctx context.Context, n int) error {
+	if b.closed() {
+		return ErrClosed
+	}
+
+	r := b.ReserveN(n)
+	if !r.OK() {
+		return NoTokenError{
+			Err: fmt.Errorf("%d tokens exceed the burst of %d", n, b.burst),
+		}
+	}
+
+	delay := r.Delay()
+	if delay == 0 {
+		return nil
+	}
+
+	if deadline, ok := ctx.Deadline(); ok && deadline.Before(b.now().Add(delay)) {
+		r.Cancel()
+		return NoTokenError{
+			Err: context.DeadlineExceeded,
+		}
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		r.Cancel()
+		return NoTokenError{
+			Err: ctx.Err(),
+		}
+	case <-b.done:
+		r.Cancel()
+		return ErrClosed
+	case <-timer.C:
+		return nil
+	}
+}
+
+// Tokens returns the number of tokens currently available. The value is
+// negative if tokens are reserved in advance.
+func (b *tokenBucket) Tokens() float64 {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+
+	b.advance(b.now())
+	return b.tokens
+}
+
+// Stats returns the stats of the bucket.
+func (b *tokenBucket) Stats() Stats {
+	return b.recorder.stats(b.Tokens())
+}
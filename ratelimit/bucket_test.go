@@ -0,0 +1,159 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// fakeClock is a manually advanced clock for deterministic bucket tests
+type fakeClock struct {
+	t time.Time
+}
+
+func (c *fakeClock) now() time.Time {
+	return c.t
+}
+
+func (c *fakeClock) advance(d time.Duration) {
+	c.t = c.t.Add(d)
+}
+
+func newTestBucket(rate Rate, burst int) (*tokenBucket, *fakeClock) {
+	clock := &fakeClock{t: time.Now()}
+
+	b := NewTokenBucket(rate, burst)
+	b.now = clock.now
+	b.last = clock.t
+
+	return b, clock
+}
+
+func TestTokenBucketAllow(t *testing.T) {
+	b, clock := newTestBucket(Every(100*time.Millisecond), 3)
+
+	// the bucket starts full
+	for i := 0; i < 3; i++ {
+		if !b.Allow() {
+			t.Errorf("Expected token %d to be available", i)
+		}
+	}
+
+	if b.Allow() {
+		t.Errorf("Expected no token to be available after emptying the bucket")
+	}
+
+	// tokens are added continuously
+	clock.advance(50 * time.Millisecond)
+	if b.Allow() {
+		t.Errorf("Expected no token to be available after half the interval")
+	}
+
+	clock.advance(50 * time.Millisecond)
+	if !b.Allow() {
+		t.Errorf("Expected a token to be available after the interval")
+	}
+
+	// the bucket never holds more than the burst
+	clock.advance(time.Hour)
+	if b.Tokens() != 3 {
+		t.Errorf("Expected 3 tokens, but got %f", b.Tokens())
+	}
+
+	if b.AllowN(4) {
+		t.Errorf("Expected 4 tokens to exceed the burst")
+	}
+}
+
+func TestTokenBucketReserve(t *testing.T) {
+	b, clock := newTestBucket(Per(10, time.Second), 1)
+
+	r := b.Reserve()
+	if !r.OK() || r.Delay() != 0 {
+		t.Errorf("Expected an immediate reservation, but got ok=%v delay=%s", r.OK(), r.Delay())
+	}
+
+	// the next reservation waits behind the first one
+	r = b.Reserve()
+	if !r.OK() || r.Delay() != 100*time.Millisecond {
+		t.Errorf("Expected a reservation after 100ms, but got ok=%v delay=%s", r.OK(), r.Delay())
+	}
+
+	clock.advance(40 * time.Millisecond)
+	if r.Delay() != 60*time.Millisecond {
+		t.Errorf("Expected remaining delay of 60ms, but got %s", r.Delay())
+	}
+
+	// cancelling gives the token back
+	r.Cancel()
+	r.Cancel()
+	clock.advance(60 * time.Millisecond)
+	if !b.Allow() {
+		t.Errorf("Expected a token after cancelling the reservation")
+	}
+
+	if b.ReserveN(2).OK() {
+		t.Errorf("Expected a reservation exceeding the burst to fail")
+	}
+}
+
+func TestTokenBucketWaitN(t *testing.T) {
+	b := NewTokenBucket(Every(50*time.Millisecond), 2)
+
+	start := time.Now()
+	err := b.WaitN(context.Background(), 2)
+	if err != nil {
+		t.Errorf("Expected no error, but got %v", err)
+	}
+
+	err = b.Wait(context.Background())
+	if err != nil {
+		t.Errorf("Expected no error, but got %v", err)
+	}
+
+	if time.Since(start) < 40*time.Millisecond {
+		t.Errorf("Expected wait to block for about 50ms, but blocked for %s", time.Since(start))
+	}
+
+	// a deadline that can not be met fails immediately without taking tokens
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	start = time.Now()
+	err = b.WaitN(ctx, 2)
+	var noTokenError NoTokenError
+	if !errors.As(err, &noTokenError) {
+		t.Errorf("Expected NoTokenError, but got %v", err)
+	}
+
+	if time.Since(start) > 5*time.Millisecond {
+		t.Errorf("Expected WaitN to fail fast, but took %s", time.Since(start))
+	}
+
+	err = b.WaitN(context.Background(), 3)
+	if !errors.As(err, &noTokenError) {
+		t.Errorf("Expected NoTokenError for tokens exceeding the burst, but got %v", err)
+	}
+}
+
+func TestTokenBucketClose(t *testing.T) {
+	b := NewTokenBucket(Every(time.Hour), 1)
+	if !b.Allow() {
+		t.Errorf("Expected the initial token to be available")
+	}
+
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		_ = b.Close()
+	}()
+
+	err := b.Wait(context.Background())
+	if !errors.Is(err, ErrClosed) {
+		t.Errorf("Expected ErrClosed, but got %v", err)
+	}
+
+	if b.Allow() {
+		t.Errorf("Expected a closed bucket to deny tokens")
+	}
+}
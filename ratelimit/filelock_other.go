@@ -0,0 +1,9 @@
+//go:build !unix
+
+package ratelimit
+
+// tryPlatformLock falls back to exclusively created lock files on platforms
+// without flock.
+func (s *fileStore) tryPlatformLock(path string) (func(), bool, error) {
+	return s.tryExclusiveLock(path)
+}
@@ -0,0 +1,36 @@
+//go:build unix
+
+package ratelimit
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"syscall"
+)
+
+// tryPlatformLock tries to lock the lock file with flock. The lock file is
+// never removed, the kernel releases the lock once the file is closed, also if
+// the process crashed.
+func (s *fileStore) tryPlatformLock(path string) (func(), bool, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0o644)
+	if err != nil {
+		return nil, false, fmt.Errorf("error opening lock file: %w", err)
+	}
+
+	err = syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+	if errors.Is(err, syscall.EWOULDBLOCK) {
+		_ = f.Close()
+		return nil, false, nil
+	}
+
+	if err != nil {
+		_ = f.Close()
+		return nil, false, fmt.Errorf("error locking lock file: %w", err)
+	}
+
+	return func() {
+		_ = syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+		_ = f.Close()
+	}, true, nil
+}
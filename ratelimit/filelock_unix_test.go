@@ -0,0 +1,54 @@
+//go:build unix
+
+package ratelimit
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestFileStoreFlock(t *testing.T) {
+	dir := t.TempDir()
+
+	holder, err := NewFileStore(FileStoreConfig{Dir: dir})
+	if err != nil {
+		t.Errorf("Expected no error, but got %v", err)
+		return
+	}
+
+	other, err := NewFileStore(FileStoreConfig{Dir: dir})
+	if err != nil {
+		t.Errorf("Expected no error, but got %v", err)
+		return
+	}
+
+	// a lock file left behind by a crashed process does not block
+	lock := holder.path("vendor") + ".lock"
+	if err := os.WriteFile(lock, []byte("crashed"), 0o644); err != nil {
+		t.Errorf("Expected no error, but got %v", err)
+		return
+	}
+
+	unlock, err := holder.lock(context.Background(), lock)
+	if err != nil {
+		t.Errorf("Expected the lock to be acquired, but got %v", err)
+		return
+	}
+
+	// a held lock blocks everyone else
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	noop := func(value []byte) ([]byte, error) { return value, nil }
+	if err := other.Update(ctx, "vendor", noop); err == nil {
+		t.Errorf("Expected an error while the lock is held")
+	}
+
+	unlock()
+
+	if err := other.Update(context.Background(), "vendor", noop); err != nil {
+		t.Errorf("Expected the lock to be released, but got %v", err)
+	}
+}
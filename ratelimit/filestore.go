@@ -0,0 +1,190 @@
+package ratelimit
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// FileStoreConfig configures a file store.
+type FileStoreConfig struct {
+	Dir       string        // the directory holding the state, has to be shared by all processes
+	StaleLock time.Duration // a lock file older than this is considered abandoned by a crashed process and removed, only used on platforms without flock, defaults to 10s
+	Poll      time.Duration // the interval to check a held lock again, defaults to 1ms
+}
+
+// fileStore keeps the state of every key in a file of a shared directory. The
+// files are protected by a lock file per key. On unix the lock file is locked
+// with flock, so the lock is released by the kernel if a process crashes.
+// Other platforms fall back to lock files that are created exclusively. It is
+// meant for processes on the same machine.
+type fileStore struct {
+	conf    FileStoreConfig
+	tryLock func(path string) (unlock func(), ok bool, err error) // replaceable for tests
+}
+
+// NewFileStore returns a store that keeps the state in files in the given
+// directory. The directory is created if it does not exist.
+func NewFileStore(conf FileStoreConfig) (*fileStore, error) {
+	if conf.StaleLock <= 0 {
+		conf.StaleLock = 10 * time.Second
+	}
+
+	if conf.Poll <= 0 {
+		conf.Poll = time.Millisecond
+	}
+
+	err := os.MkdirAll(conf.Dir, 0o755)
+	if err != nil {
+		return nil, fmt.Errorf("error creating store directory: %w", err)
+	}
+
+	s := &fileStore{
+		conf: conf,
+	}
+	s.tryLock = s.tryPlatformLock
+
+	return s, nil
+}
+
+// path returns the path of the state file of the key. Keys are hex encoded, so
+// any key is a valid file name.
+func (s *fileStore) path(key string) string {
+	return filepath.Join(s.conf.Dir, hex.EncodeToString([]byte(key)))
+}
+
+// Update implements Store.
+func (s *fileStore) Update(ctx context.Context, key string, fn func(value []byte) ([]byte, error)) error {
+	path := s.path(key)
+
+	unlock, err := s.lock(ctx, path+".lock")
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	value, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("error reading state: %w", err)
+	}
+
+	newValue, err := fn(value)
+	if err != nil {
+		return err
+	}
+
+	if bytes.Equal(value, newValue) {
+		return nil
+	}
+
+	// write the new state to a temporary file first, so a crash never leaves
+	// a partially written state behind
+	tmp := path + ".tmp"
+	err = os.WriteFile(tmp, newValue, 0o644)
+	if err != nil {
+		return fmt.Errorf("error writing state: %w", err)
+	}
+
+	err = os.Rename(tmp, path)
+	if err != nil {
+		return fmt.Errorf("error writing state: %w", err)
+	}
+
+	return nil
+}
+
+// lock acquires the lock of the given lock file and returns a func releasing
+// it. It polls until the lock is acquired or the context is done.
+func (s *fileStore) lock(ctx context.Context, path string) (func(), error) {
+	for {
+		unlock, ok, err := s.tryLock(path)
+		if err != nil {
+			return nil, err
+		}
+
+		if ok {
+			return unlock, nil
+		}
+
+		timer := time.NewTimer(s.conf.Poll)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, fmt.Errorf("error acquiring lock: %w", ctx.Err())
+		case <-timer.C:
+		}
+	}
+}
+
+// tryExclusiveLock tries to create the lock file exclusively. The lock file
+// holds a unique token, so a lock is only removed by its owner, or as stale
+// if it still is the lock that was found to be stale. A stale lock may still
+// be taken over by two processes in a narrow window, use flock where it is
+// available.
+func (s *fileStore) tryExclusiveLock(path string) (func(), bool, error) {
+	token, err := newLockToken()
+	if err != nil {
+		return nil, false, err
+	}
+
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o644)
+	if err == nil {
+		_, err = f.WriteString(token)
+		closeErr := f.Close()
+		if err == nil {
+			err = closeErr
+		}
+
+		if err != nil {
+			_ = os.Remove(path)
+			return nil, false, fmt.Errorf("error writing lock file: %w", err)
+		}
+
+		return func() {
+			removeLock(path, token)
+		}, true, nil
+	}
+
+	if !errors.Is(err, fs.ErrExist) {
+		return nil, false, fmt.Errorf("error creating lock file: %w", err)
+	}
+
+	// a process crashed while holding the lock
+	info, err := os.Stat(path)
+	if err == nil && time.Since(info.ModTime()) > s.conf.StaleLock {
+		stale, err := os.ReadFile(path)
+		if err == nil {
+			removeLock(path, string(stale))
+		}
+	}
+
+	return nil, false, nil
+}
+
+// removeLock removes the lock file if it holds the token.
+func removeLock(path string, token string) {
+	b, err := os.ReadFile(path)
+	if err != nil || string(b) != token {
+		return
+	}
+
+	_ = os.Remove(path)
+}
+
+// newLockToken returns a random token identifying the owner of a lock file
+func newLockToken() (string, error) {
+	b := make([]byte, 16)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", fmt.Errorf("error creating lock token: %w", err)
+	}
+
+	return hex.EncodeToString(b), nil
+}
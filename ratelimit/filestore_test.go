@@ -0,0 +1,132 @@
+package ratelimit
+
+import (
+	"context"
+	"os"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestFileStoreRemovesStaleLock(t *testing.T) {
+	store, err := NewFileStore(FileStoreConfig{
+		Dir:       t.TempDir(),
+		StaleLock: 50 * time.Millisecond,
+	})
+	if err != nil {
+		t.Errorf("Expected no error, but got %v", err)
+		return
+	}
+	store.tryLock = store.tryExclusiveLock
+
+	// a crashed process left its lock behind
+	lock := store.path("vendor") + ".lock"
+	if err := os.WriteFile(lock, []byte("crashed"), 0o644); err != nil {
+		t.Errorf("Expected no error, but got %v", err)
+		return
+	}
+
+	// the lock is respected while it is fresh
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	noop := func(value []byte) ([]byte, error) { return value, nil }
+	if err := store.Update(ctx, "vendor", noop); err == nil {
+		t.Errorf("Expected an error while the lock is held")
+	}
+
+	past := time.Now().Add(-time.Second)
+	if err := os.Chtimes(lock, past, past); err != nil {
+		t.Errorf("Expected no error, but got %v", err)
+		return
+	}
+
+	if err := store.Update(context.Background(), "vendor", noop); err != nil {
+		t.Errorf("Expected the stale lock to be removed, but got %v", err)
+	}
+
+	if _, err := os.Stat(lock); !os.IsNotExist(err) {
+		t.Errorf("Expected the lock to be released, but got %v", err)
+	}
+}
+
+func TestFileStoreLockToken(t *testing.T) {
+	store, err := NewFileStore(FileStoreConfig{
+		Dir:       t.TempDir(),
+		StaleLock: 50 * time.Millisecond,
+	})
+	if err != nil {
+		t.Errorf("Expected no error, but got %v", err)
+		return
+	}
+
+	lock := store.path("vendor") + ".lock"
+	unlockSlow, ok, err := store.tryExclusiveLock(lock)
+	if err != nil || !ok {
+		t.Errorf("Expected the lock to be acquired, but got %v", err)
+		return
+	}
+
+	// the slow holder is considered crashed and its lock taken over
+	past := time.Now().Add(-time.Second)
+	if err := os.Chtimes(lock, past, past); err != nil {
+		t.Errorf("Expected no error, but got %v", err)
+		return
+	}
+
+	store.tryLock = store.tryExclusiveLock
+	unlock, err := store.lock(context.Background(), lock)
+	if err != nil {
+		t.Errorf("Expected the stale lock to be taken over, but got %v", err)
+		return
+	}
+
+	// the slow holder must not release the lock of its successor
+	unlockSlow()
+	if _, err := os.Stat(lock); err != nil {
+		t.Errorf("Expected the lock of the successor to be kept, but got %v", err)
+	}
+
+	unlock()
+	if _, err := os.Stat(lock); !os.IsNotExist(err) {
+		t.Errorf("Expected the lock to be released, but got %v", err)
+	}
+}
+
+func TestFileStoreMutualExclusion(t *testing.T) {
+	dir := t.TempDir()
+
+	// every store stands for a process sharing the directory
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		store, err := NewFileStore(FileStoreConfig{Dir: dir})
+		if err != nil {
+			t.Errorf("Expected no error, but got %v", err)
+			return
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for j := 0; j < 50; j++ {
+				err := store.Update(context.Background(), "counter", func(value []byte) ([]byte, error) {
+					n, _ := strconv.Atoi(string(value))
+					return []byte(strconv.Itoa(n + 1)), nil
+				})
+				if err != nil {
+					t.Errorf("Expected no error, but got %v", err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	store, _ := NewFileStore(FileStoreConfig{Dir: dir})
+	b, err := os.ReadFile(store.path("counter"))
+	if err != nil || string(b) != "200" {
+		t.Errorf("Expected 200 updates, but got %s (%v)", b, err)
+	}
+}
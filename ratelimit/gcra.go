@@ -0,0 +1,105 @@
+package ratelimit
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// gcraLimiter implements the generic cell rate algorithm. It only stores the
+// theoretical arrival time of the next request, which makes it a good fit for
+// shared state. It behaves like a token bucket that is refilled continuously.
+type gcraLimiter struct {
+	closer
+	interval time.Duration // emission interval, the time between two requests at the sustained rate
+	burst    int
+	now      func() time.Time // replaceable for tests
+	*recorder
+
+	mtx *sync.Mutex
+	tat time.Time // theoretical arrival time
+}
+
+// NewGCRALimiter returns a limiter that admits requests at the given rate and
+// allows bursts of up to burst requests.
+func NewGCRALimiter(rate Rate, burst int) *gcraLimiter {
+	if burst <= 0 {
+		burst = 1
+	}
+
+	return &gcraLimiter{
+		closer:   newCloser(),
+		interval: rate.durationFor(1),
+		burst:    burst,
+		now:      time.Now,
+		recorder: newRecorder(),
+		mtx:      &sync.Mutex{},
+	}
+}
+
+// gcra calculates the new theoretical arrival time for a request at now. It
+// returns the time to wait if the request does not conform.
+func gcra(tat time.Time, now time.Time, interval time.Duration, burst int) (time.Time, time.Duration) {
+	if tat.Before(now) {
+		tat = now
+	}
+
+	newTat := tat.Add(interval)
+	allowAt := newTat.Add(-interval * time.Duration(burst))
+	if wait := allowAt.Sub(now); wait > 0 {
+		return tat, wait
+	}
+
+	return newTat, 0
+}
+
+func (l *gcraLimiter) take() (time.Duration, error) {
+	if l.closed() {
+		return 0, ErrClosed
+	}
+
+	now := l.now()
+
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+
+	tat, wait := gcra(l.tat, now, l.interval, l.burst)
+	if wait > 0 {
+		return wait, nil
+	}
+	l.tat = tat
+
+	return 0, nil
+}
+
+// Allow reports whether a request may be sent now and accounts for it if so.
+func (l *gcraLimiter) Allow() bool {
+	wait, err := l.take()
+	return err == nil && wait == 0
+}
+
+// Wait blocks until the request may be sent or the context is done.
+func (l *gcraLimiter) Wait(ctx context.Context) error {
+	record := l.recorder.begin()
+	err := waitFor(ctx, l.done, l.now, l.take)
+	record(err)
+
+	return err
+}
+
+// Stats returns the stats of the limiter.
+func (l *gcraLimiter) Stats() Stats {
+	now := l.now()
+
+	l.mtx.Lock()
+	tat := l.tat
+	l.mtx.Unlock()
+
+	// every emission interval the tat is ahead of now takes a token
+	tokens := float64(l.burst)
+	if tat.After(now) {
+		tokens -= float64(tat.Sub(now)) / float64(l.interval)
+	}
+
+	return l.recorder.stats(tokens)
+}
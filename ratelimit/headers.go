@@ -0,0 +1,410 @@
+package ratelimit
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// headerLimiter paces requests according to the quota a server advertises in
+// its response headers. It understands the X-RateLimit-* headers, the IETF
+// RateLimit-* and RateLimit / RateLimit-Policy headers and Retry-After.
+type headerLimiter struct {
+	now func() time.Time // replaceable for tests
+	*recorder
+
+	mtx       *sync.Mutex
+	limit     int           // the advertised quota per window, 0 if unknown
+	window    time.Duration // the advertised window, 0 if unknown
+	remaining int           // the remaining quota, -1 if unknown
+	reset     time.Time     // the time the quota resets, zero if unknown
+	next      time.Time     // the earliest time the next request may be sent
+	closed    bool
+}
+
+// NewHeaderLimiter returns a rate limiter that paces requests to stay under the
+// quota advertised in response headers. Responses have to be passed to Observe,
+// which has the signature of a lazyhttp post response hook:
+//
+//	limiter := ratelimit.NewHeaderLimiter()
+//	client := lazyhttp.New(
+//		lazyhttp.WithRateLimiter(limiter),
+//		lazyhttp.WithPostResponseHooks(limiter.Observe),
+//	)
+//
+// Until the first response was observed requests are not limited. If the
+// remaining quota reaches 0 all requests wait until the quota resets.
+func NewHeaderLimiter() *headerLimiter {
+	return &headerLimiter{
+		now:       time.Now,
+		recorder:  newRecorder(),
+		mtx:       &sync.Mutex{},
+		remaining: -1,
+	}
+}
+
+// quota is the state advertised by a single response
+type quota struct {
+	limit     int
+	window    time.Duration
+	remaining int
+	reset     time.Duration
+	hasReset  bool
+}
+
+// Observe reads the rate limit headers of the response. It never fails, the
+// error is only returned to match the post response hook signature.
+func (l *headerLimiter) Observe(res *http.Response) error {
+	if res == nil {
+		return nil
+	}
+
+	now := l.now()
+	q, ok := parseQuota(res.Header, now)
+
+	// a server telling us to back off overrides everything else
+	retryAfter, hasRetryAfter := parseRetryAfter(res.Header.Get("Retry-After"), now)
+	if (res.StatusCode == http.StatusTooManyRequests || res.StatusCode == http.StatusServiceUnavailable) && hasRetryAfter {
+		q.remaining = 0
+		q.reset = retryAfter
+		q.hasReset = true
+		ok = true
+	}
+
+	if !ok {
+		return nil
+	}
+
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+
+	if q.limit > 0 {
+		l.limit = q.limit
+	}
+
+	if q.window > 0 {
+		l.window = q.window
+	}
+
+	l.remaining = q.remaining
+	if q.hasReset {
+		l.reset = now.Add(q.reset)
+	} else if l.window > 0 && (l.reset.IsZero() || !l.reset.After(now)) {
+		l.reset = now.Add(l.window)
+	}
+
+	return nil
+}
+
+// parseQuota reads the quota from the headers. It reports false if the headers
+// do not contain any quota.
+func parseQuota(h http.Header, now time.Time) (quota, bool) {
+	q := quota{remaining: -1}
+
+	// IETF draft with the structured RateLimit header, e.g.
+	// RateLimit: limit=100, remaining=50, reset=30 or
+	// RateLimit: "default";r=50;t=30
+	if v := h.Get("RateLimit"); v != "" {
+		params := parseParams(v)
+		if r, ok := firstInt(params, "remaining", "r"); ok {
+			q.remaining = r
+		}
+
+		if limit, ok := firstInt(params, "limit"); ok {
+			q.limit = limit
+		}
+
+		if t, ok := firstInt(params, "reset", "t"); ok {
+			q.reset = time.Duration(t) * time.Second
+			q.hasReset = true
+		}
+	}
+
+	// RateLimit-Policy: 100;w=60 or "default";q=100;w=60
+	if v := h.Get("RateLimit-Policy"); v != "" {
+		params := parseParams(strings.SplitN(v, ",", 2)[0])
+		if limit, ok := firstInt(params, "q", ""); ok {
+			q.limit = limit
+		}
+
+		if w, ok := firstInt(params, "w"); ok {
+			q.window = time.Duration(w) * time.Second
+		}
+	}
+
+	for _, prefix := range []string{"RateLimit-", "X-RateLimit-"} {
+		if v, err := strconv.Atoi(strings.TrimSpace(h.Get(prefix + "Limit"))); err == nil && q.limit == 0 {
+			q.limit = v
+		}
+
+		if v, err := strconv.Atoi(strings.TrimSpace(h.Get(prefix + "Remaining"))); err == nil && q.remaining < 0 {
+			q.remaining = v
+		}
+
+		if v, err := strconv.ParseInt(strings.TrimSpace(h.Get(prefix+"Reset")), 10, 64); err == nil && !q.hasReset {
+			q.reset = resetDuration(v, now)
+			q.hasReset = true
+		}
+	}
+
+	return q, q.remaining >= 0
+}
+
+// resetDuration interprets a reset value either as seconds until the reset or,
+// if it is too large for that, as unix timestamp of the reset.
+func resetDuration(v int64, now time.Time) time.Duration {
+	// no window is longer than a year, so bigger values are timestamps
+	if v > 365*24*60*60 {
+		d := time.Unix(v, 0).Sub(now)
+		if d < 0 {
+			return 0
+		}
+		return d
+	}
+
+	return time.Duration(v) * time.Second
+}
+
+// parseRetryAfter reads a Retry-After header in seconds or as http date.
+func parseRetryAfter(v string, now time.Time) (time.Duration, bool) {
+	v = strings.TrimSpace(v)
+	if v == "" {
+		return 0, false
+	}
+
+	if seconds, err := strconv.Atoi(v); err == nil {
+		return time.Duration(seconds) * time.Second, true
+	}
+
+	if t, err := http.ParseTime(v); err == nil {
+		d := t.Sub(now)
+		if d < 0 {
+			d = 0
+		}
+		return d, true
+	}
+
+	return 0, false
+}
+
+// parseParams splits a header value like `limit=100, remaining=50` or
+// `"default";r=50;t=30` into its parameters. Values without a name are stored
+// with an empty name.
+func parseParams(v string) map[string]string {
+	params := map[string]string{}
+	for _, part := range strings.FieldsFunc(v, func(r rune) bool { return r == ',' || r == ';' }) {
+		name, value, found := strings.Cut(strings.TrimSpace(part), "=")
+		if !found {
+			params[""] = strings.Trim(name, `"`)
+			continue
+		}
+
+		params[strings.ToLower(strings.TrimSpace(name))] = strings.Trim(strings.TrimSpace(value), `"`)
+	}
+
+	return params
+}
+
+// firstInt returns the first of the given parameters that is an integer
+func firstInt(params map[string]string, names ...string) (int, bool) {
+	for _, name := range names {
+		if v, err := strconv.Atoi(params[name]); err == nil {
+			return v, true
+		}
+	}
+
+	return 0, false
+}
+
+// Wait blocks until the next request may be sent according to the advertised
+// quota or the context is done. The remaining quota is spread evenly until the
+// reset, if it is exhausted Wait blocks until the reset.
+func (l *headerLimiter) Wait(ctx context.Context) error {
+	record := l.recorder.begin()
+	err := l.wait(ctx)
+	record(err)
+
+	return err
+}
+
+func (l *headerLimiter) wait(ctx context.Context) error {
+	r, err := l.reserve()
+	if err != nil {
+		return err
+	}
+
+	delay := r.at.Sub(l.now())
+	if delay <= 0 {
+		return nil
+	}
+
+	if deadline, ok := ctx.Deadline(); ok && deadline.Before(r.at) {
+		l.cancel(r)
+		return NoTokenError{
+			Err: context.DeadlineExceeded,
+		}
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		l.cancel(r)
+		return NoTokenError{
+			Err: ctx.Err(),
+		}
+	case <-timer.C:
+		return nil
+	}
+}
+
+// headerState is the part of the limiter a reservation changes.
+type headerState struct {
+	remaining int
+	reset     time.Time
+	next      time.Time
+}
+
+func (s headerState) equal(o headerState) bool {
+	return s.remaining == o.remaining && s.reset.Equal(o.reset) && s.next.Equal(o.next)
+}
+
+// headerReservation is the time a caller may send its request and the state
+// of the limiter before and after it was accounted for.
+type headerReservation struct {
+	at     time.Time
+	before headerState
+	after  headerState
+}
+
+func (l *headerLimiter) state() headerState {
+	return headerState{remaining: l.remaining, reset: l.reset, next: l.next}
+}
+
+// cancel gives an unused reservation back. If nothing happened since, the
+// state before the reservation is restored. Otherwise only the quota is given
+// back, as long as it still belongs to the same window.
+func (l *headerLimiter) cancel(r headerReservation) {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+
+	if l.state().equal(r.after) {
+		l.remaining = r.before.remaining
+		l.reset = r.before.reset
+		l.next = r.before.next
+		return
+	}
+
+	if l.remaining >= 0 && l.reset.Equal(r.after.reset) {
+		l.remaining++
+	}
+}
+
+// reserve returns the time the caller may send its request and accounts for
+// it in the local quota.
+func (l *headerLimiter) reserve() (headerReservation, error) {
+	now := l.now()
+
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+
+	if l.closed {
+		return headerReservation{}, ErrClosed
+	}
+
+	r := headerReservation{before: l.state()}
+	r.at = l.accountFor(now)
+	r.after = l.state()
+
+	return r, nil
+}
+
+// accountFor accounts for a request in the local quota and returns the time
+// it may be sent. The caller must hold the lock.
+func (l *headerLimiter) accountFor(now time.Time) time.Time {
+	// nothing advertised yet
+	if l.remaining < 0 || l.reset.IsZero() {
+		return now
+	}
+
+	// the quota was reset since the last response, assume the full quota if
+	// we know it.
+	if !l.reset.After(now) {
+		if l.limit <= 0 {
+			l.remaining = -1
+			l.reset = time.Time{}
+			return now
+		}
+
+		l.remaining = l.limit
+		if l.window > 0 {
+			l.reset = now.Add(l.window)
+		} else {
+			l.remaining = -1
+			l.reset = time.Time{}
+			return now
+		}
+	}
+
+	at := now
+	if l.next.After(at) {
+		at = l.next
+	}
+
+	if l.remaining <= 0 {
+		// wait for the reset
+		if l.reset.After(at) {
+			at = l.reset
+		}
+
+		// if we know the quota, the request is accounted for in the next
+		// window so waiting requests do not all fire at the reset
+		if l.limit > 0 && l.window > 0 {
+			l.remaining = l.limit - 1
+			l.reset = l.reset.Add(l.window)
+			l.next = at.Add(l.window / time.Duration(l.limit))
+			return at
+		}
+
+		l.next = at
+		return at
+	}
+
+	// spread the remaining quota evenly until the reset
+	if l.reset.After(at) {
+		l.next = at.Add(l.reset.Sub(at) / time.Duration(l.remaining))
+	} else {
+		l.next = at
+	}
+	l.remaining--
+
+	return at
+}
+
+// Stats returns the stats of the limiter. The tokens are the remaining quota
+// advertised by the server minus the requests sent since, NaN until a quota
+// was advertised.
+func (l *headerLimiter) Stats() Stats {
+	l.mtx.Lock()
+	tokens := math.NaN()
+	if l.remaining >= 0 {
+		tokens = float64(l.remaining)
+	}
+	l.mtx.Unlock()
+
+	return l.recorder.stats(tokens)
+}
+
+// Close closes the limiter. Future calls to Wait return ErrClosed.
+func (l *headerLimiter) Close() error {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+
+	l.closed = true
+	return nil
+}
@@ -0,0 +1,196 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func newTestHeaderLimiter() (*headerLimiter, *fakeClock) {
+	clock := &fakeClock{t: time.Now()}
+
+	l := NewHeaderLimiter()
+	l.now = clock.now
+
+	return l, clock
+}
+
+func responseWithHeaders(status int, headers map[string]string) *http.Response {
+	res := &http.Response{
+		StatusCode: status,
+		Header:     http.Header{},
+	}
+
+	for k, v := range headers {
+		res.Header.Set(k, v)
+	}
+
+	return res
+}
+
+func TestHeaderLimiterUnknownQuota(t *testing.T) {
+	l, clock := newTestHeaderLimiter()
+
+	r, err := l.reserve()
+	if err != nil || !r.at.Equal(clock.t) {
+		t.Errorf("Expected requests to pass without a quota, but got %s %v", r.at, err)
+	}
+
+	_ = l.Observe(responseWithHeaders(http.StatusOK, map[string]string{"Content-Type": "text/plain"}))
+
+	r, err = l.reserve()
+	if err != nil || !r.at.Equal(clock.t) {
+		t.Errorf("Expected requests to pass without rate limit headers, but got %s %v", r.at, err)
+	}
+}
+
+func TestHeaderLimiterPacesRemainingQuota(t *testing.T) {
+	l, clock := newTestHeaderLimiter()
+	start := clock.t
+
+	_ = l.Observe(responseWithHeaders(http.StatusOK, map[string]string{
+		"X-RateLimit-Limit":     "100",
+		"X-RateLimit-Remaining": "4",
+		"X-RateLimit-Reset":     "8",
+	}))
+
+	// 4 requests are spread evenly over the 8 seconds until the reset
+	for i := 0; i < 4; i++ {
+		r, _ := l.reserve()
+		expected := start.Add(time.Duration(i) * 2 * time.Second)
+		if !r.at.Equal(expected) {
+			t.Errorf("Expected request %d at %s, but got %s", i, expected.Sub(start), r.at.Sub(start))
+		}
+	}
+
+	// the quota is exhausted so the next request waits for the reset
+	r, _ := l.reserve()
+	if !r.at.Equal(start.Add(8 * time.Second)) {
+		t.Errorf("Expected request at the reset, but got %s", r.at.Sub(start))
+	}
+}
+
+func TestHeaderLimiterGivesBackFailedWaits(t *testing.T) {
+	l, clock := newTestHeaderLimiter()
+	start := clock.t
+
+	_ = l.Observe(responseWithHeaders(http.StatusOK, map[string]string{
+		"X-RateLimit-Limit":     "100",
+		"X-RateLimit-Remaining": "4",
+		"X-RateLimit-Reset":     "8",
+	}))
+
+	if r, _ := l.reserve(); !r.at.Equal(start) {
+		t.Errorf("Expected the first request right away, but got %s", r.at.Sub(start))
+	}
+
+	// waits with a deadline before their turn fail fast
+	for i := 0; i < 3; i++ {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		err := l.Wait(ctx)
+		cancel()
+
+		var noTokenError NoTokenError
+		if !errors.As(err, &noTokenError) {
+			t.Errorf("Expected NoTokenError, but got %v", err)
+		}
+	}
+
+	// waits that are cancelled while waiting fail as well
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	var noTokenError NoTokenError
+	if err := l.Wait(ctx); !errors.As(err, &noTokenError) {
+		t.Errorf("Expected NoTokenError, but got %v", err)
+	}
+
+	// the failed waits did not use up the quota, the pacing is unchanged
+	for i := 1; i < 4; i++ {
+		r, _ := l.reserve()
+		expected := start.Add(time.Duration(i) * 2 * time.Second)
+		if !r.at.Equal(expected) {
+			t.Errorf("Expected request %d at %s, but got %s", i, expected.Sub(start), r.at.Sub(start))
+		}
+	}
+}
+
+func TestHeaderLimiterPausesUntilReset(t *testing.T) {
+	l, clock := newTestHeaderLimiter()
+	start := clock.t
+
+	_ = l.Observe(responseWithHeaders(http.StatusOK, map[string]string{
+		"RateLimit-Policy": "10;w=60",
+		"RateLimit":        "limit=10, remaining=0, reset=30",
+	}))
+
+	r, _ := l.reserve()
+	if !r.at.Equal(start.Add(30 * time.Second)) {
+		t.Errorf("Expected request at the reset after 30s, but got %s", r.at.Sub(start))
+	}
+
+	// the next window is paced with the advertised policy
+	r, _ = l.reserve()
+	if !r.at.Equal(start.Add(36 * time.Second)) {
+		t.Errorf("Expected request 6s after the reset, but got %s", r.at.Sub(start))
+	}
+
+	// a deadline before the reset fails fast
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	var noTokenError NoTokenError
+	if err := l.Wait(ctx); !errors.As(err, &noTokenError) {
+		t.Errorf("Expected NoTokenError, but got %v", err)
+	}
+}
+
+func TestHeaderLimiterRetryAfter(t *testing.T) {
+	l, clock := newTestHeaderLimiter()
+	start := clock.t
+
+	_ = l.Observe(responseWithHeaders(http.StatusTooManyRequests, map[string]string{
+		"Retry-After": "5",
+	}))
+
+	r, _ := l.reserve()
+	if !r.at.Equal(start.Add(5 * time.Second)) {
+		t.Errorf("Expected request after Retry-After, but got %s", r.at.Sub(start))
+	}
+
+	// after the reset without a known quota requests pass again
+	clock.advance(10 * time.Second)
+	r, _ = l.reserve()
+	if !r.at.Equal(clock.t) {
+		t.Errorf("Expected request to pass after the reset, but got %s", r.at.Sub(clock.t))
+	}
+}
+
+func TestParseQuotaStructuredHeader(t *testing.T) {
+	h := http.Header{}
+	h.Set("RateLimit", `"default";r=50;t=30`)
+	h.Set("RateLimit-Policy", `"default";q=100;w=60`)
+
+	q, ok := parseQuota(h, time.Now())
+	if !ok {
+		t.Errorf("Expected quota to be parsed")
+		return
+	}
+
+	if q.remaining != 50 || q.reset != 30*time.Second || q.limit != 100 || q.window != time.Minute {
+		t.Errorf("Unexpected quota: %+v", q)
+	}
+
+	// reset as unix timestamp
+	now := time.Now()
+	h = http.Header{}
+	h.Set("X-RateLimit-Remaining", "1")
+	h.Set("X-RateLimit-Reset", "4102444800") // 2100-01-01
+
+	q, _ = parseQuota(h, now)
+	if q.reset != time.Unix(4102444800, 0).Sub(now) {
+		t.Errorf("Expected reset from timestamp, but got %s", q.reset)
+	}
+}
@@ -0,0 +1,239 @@
+package ratelimit
+
+import (
+	"context"
+	"io"
+	"math"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Limiter is the interface all rate limiters of this pkg implement. It matches
+// the RateLimiter interface of the lazyhttp client.
+type Limiter interface {
+	Wait(ctx context.Context) error
+}
+
+// KeyFunc derives the rate limiting key from a request.
+type KeyFunc func(*http.Request) string
+
+// HostKey limits requests per host.
+func HostKey(req *http.Request) string {
+	return req.URL.Host
+}
+
+// HeaderKey limits requests per value of the given header, e.g. per API key.
+// The lazyhttp client hands the limiter the request after its pre request
+// hooks and authenticator ran, so headers set by them are seen. Requests
+// without the header share the limiter of the empty key.
+func HeaderKey(header string) KeyFunc {
+	return func(req *http.Request) string {
+		return req.Header.Get(header)
+	}
+}
+
+// KeyedConfig configures a keyed rate limiter.
+type KeyedConfig struct {
+	Key         KeyFunc                  // derives the key from the request, defaults to HostKey
+	New         func(key string) Limiter // creates the limiter for a key seen for the first time
+	Static      map[string]Limiter       // limiters for specific keys, these are never evicted
+	IdleTimeout time.Duration            // limiters created by New are evicted after being idle this long, 0 never evicts
+}
+
+type keyedEntry struct {
+	limiter  Limiter
+	lastUsed time.Time
+	waiting  int // number of callers waiting for the limiter, these keep it from being evicted
+}
+
+// keyedLimiter holds a rate limiter per key. Limiters are created lazily and
+// evicted once they are idle. Eviction happens while the limiter is used, so
+// there is no background goroutine.
+type keyedLimiter struct {
+	conf KeyedConfig
+	now  func() time.Time // replaceable for tests
+	*recorder
+
+	mtx       *sync.Mutex
+	limiters  map[string]*keyedEntry
+	lastSweep time.Time
+	closed    bool
+}
+
+// NewKeyedLimiter returns a rate limiter that limits requests per key. The
+// lazyhttp client hands the request to the limiter, so the key can be derived
+// from it.
+func NewKeyedLimiter(conf KeyedConfig) *keyedLimiter {
+	if conf.Key == nil {
+		conf.Key = HostKey
+	}
+
+	return &keyedLimiter{
+		conf:      conf,
+		now:       time.Now,
+		recorder:  newRecorder(),
+		mtx:       &sync.Mutex{},
+		limiters:  map[string]*keyedEntry{},
+		lastSweep: time.Now(),
+	}
+}
+
+// Wait waits for the limiter of the empty key. It is only used if the limiter
+// is called without a request.
+func (l *keyedLimiter) Wait(ctx context.Context) error {
+	return l.WaitKey(ctx, "")
+}
+
+// WaitRequest derives the key from the request and waits for its limiter.
+func (l *keyedLimiter) WaitRequest(ctx context.Context, req *http.Request) error {
+	return l.WaitKey(ctx, l.conf.Key(req))
+}
+
+// WaitKey waits for the limiter of the given key.
+func (l *keyedLimiter) WaitKey(ctx context.Context, key string) error {
+	record := l.recorder.begin()
+	err := l.waitKey(ctx, key)
+	record(err)
+
+	return err
+}
+
+func (l *keyedLimiter) waitKey(ctx context.Context, key string) error {
+	if limiter, ok := l.conf.Static[key]; ok {
+		l.mtx.Lock()
+		closed := l.closed
+		l.mtx.Unlock()
+
+		if closed {
+			return ErrClosed
+		}
+
+		return limiter.Wait(ctx)
+	}
+
+	entry, err := l.entry(key)
+	if err != nil {
+		return err
+	}
+
+	if entry == nil {
+		return nil
+	}
+
+	defer func() {
+		l.mtx.Lock()
+		entry.waiting--
+		l.mtx.Unlock()
+	}()
+
+	return entry.limiter.Wait(ctx)
+}
+
+// entry returns the entry of the given key and creates it if needed. The
+// caller is registered as waiting and has to unregister once done. A nil entry
+// means the key is not limited.
+func (l *keyedLimiter) entry(key string) (*keyedEntry, error) {
+	now := l.now()
+
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+
+	if l.closed {
+		return nil, ErrClosed
+	}
+
+	l.sweep(now)
+
+	entry, ok := l.limiters[key]
+	if !ok {
+		if l.conf.New == nil {
+			return nil, nil
+		}
+
+		entry = &keyedEntry{limiter: l.conf.New(key)}
+		l.limiters[key] = entry
+	}
+	entry.lastUsed = now
+	entry.waiting++
+
+	return entry, nil
+}
+
+// sweep evicts idle limiters. It runs at most every half idle timeout. The
+// caller must hold the lock.
+func (l *keyedLimiter) sweep(now time.Time) {
+	if l.conf.IdleTimeout <= 0 || now.Sub(l.lastSweep) < l.conf.IdleTimeout/2 {
+		return
+	}
+	l.lastSweep = now
+
+	for key, entry := range l.limiters {
+		if entry.waiting == 0 && now.Sub(entry.lastUsed) >= l.conf.IdleTimeout {
+			delete(l.limiters, key)
+			closeLimiter(entry.limiter)
+		}
+	}
+}
+
+// Stats returns the stats of the waits of all keys. The tokens differ per key
+// and are NaN, see StatsKey.
+func (l *keyedLimiter) Stats() Stats {
+	return l.recorder.stats(math.NaN())
+}
+
+// StatsKey returns the stats of the limiter of the given key. It reports false
+// if there is no limiter for the key or the limiter does not provide stats.
+func (l *keyedLimiter) StatsKey(key string) (Stats, bool) {
+	limiter, ok := l.conf.Static[key]
+	if !ok {
+		l.mtx.Lock()
+		entry, ok := l.limiters[key]
+		l.mtx.Unlock()
+
+		if !ok {
+			return Stats{}, false
+		}
+		limiter = entry.limiter
+	}
+
+	s, ok := limiter.(interface{ Stats() Stats })
+	if !ok {
+		return Stats{}, false
+	}
+
+	return s.Stats(), true
+}
+
+// Len returns the number of limiters created by New that are not evicted yet.
+func (l *keyedLimiter) Len() int {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+
+	return len(l.limiters)
+}
+
+// Close closes all limiters created by New and rejects future waits with
+// ErrClosed. Static limiters are owned by the caller and are not closed.
+func (l *keyedLimiter) Close() error {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+
+	if l.closed {
+		return nil
+	}
+	l.closed = true
+
+	for key, entry := range l.limiters {
+		delete(l.limiters, key)
+		closeLimiter(entry.limiter)
+	}
+
+	return nil
+}
+
+func closeLimiter(limiter Limiter) {
+	if c, ok := limiter.(io.Closer); ok {
+		_ = c.Close()
+	}
+}
@@ -0,0 +1,102 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestKeyedLimiterLimitsPerKey(t *testing.T) {
+	limiter := NewKeyedLimiter(KeyedConfig{
+		New: func(key string) Limiter {
+			return NewTokenBucket(Every(time.Hour), 1)
+		},
+		Static: map[string]Limiter{
+			"vip.example.com": NewTokenBucket(Every(time.Hour), 3),
+		},
+	})
+
+	wait := func(host string) error {
+		req, err := http.NewRequest(http.MethodGet, "http://"+host+"/", nil)
+		if err != nil {
+			return err
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+
+		return limiter.WaitRequest(ctx, req)
+	}
+
+	// each host has its own bucket
+	for _, host := range []string{"a.example.com", "b.example.com"} {
+		if err := wait(host); err != nil {
+			t.Errorf("Expected no error for %s, but got %v", host, err)
+		}
+	}
+
+	var noTokenError NoTokenError
+	if err := wait("a.example.com"); !errors.As(err, &noTokenError) {
+		t.Errorf("Expected NoTokenError, but got %v", err)
+	}
+
+	// the static limiter allows more requests
+	for i := 0; i < 3; i++ {
+		if err := wait("vip.example.com"); err != nil {
+			t.Errorf("Expected no error for request %d, but got %v", i, err)
+		}
+	}
+
+	if limiter.Len() != 2 {
+		t.Errorf("Expected 2 lazily created limiters, but got %d", limiter.Len())
+	}
+}
+
+func TestKeyedLimiterEvictsIdleLimiters(t *testing.T) {
+	clock := &fakeClock{t: time.Now()}
+
+	var created []*tokenBucket
+	limiter := NewKeyedLimiter(KeyedConfig{
+		Key: HeaderKey("X-Api-Key"),
+		New: func(key string) Limiter {
+			b := NewTokenBucket(Every(time.Millisecond), 1)
+			created = append(created, b)
+			return b
+		},
+		IdleTimeout: time.Minute,
+	})
+	limiter.now = clock.now
+	limiter.lastSweep = clock.t
+
+	for _, key := range []string{"tenant-a", "tenant-b"} {
+		if err := limiter.WaitKey(context.Background(), key); err != nil {
+			t.Errorf("Expected no error, but got %v", err)
+		}
+	}
+
+	clock.advance(2 * time.Minute)
+
+	// using the limiter sweeps idle limiters
+	if err := limiter.WaitKey(context.Background(), "tenant-c"); err != nil {
+		t.Errorf("Expected no error, but got %v", err)
+	}
+
+	if limiter.Len() != 1 {
+		t.Errorf("Expected idle limiters to be evicted, but got %d limiters", limiter.Len())
+	}
+
+	if !created[0].closed() || !created[1].closed() {
+		t.Errorf("Expected evicted limiters to be closed")
+	}
+
+	_ = limiter.Close()
+	if err := limiter.WaitKey(context.Background(), "tenant-c"); !errors.Is(err, ErrClosed) {
+		t.Errorf("Expected ErrClosed, but got %v", err)
+	}
+
+	if !created[2].closed() {
+		t.Errorf("Expected limiters to be closed with the keyed limiter")
+	}
+}
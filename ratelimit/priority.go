@@ -0,0 +1,350 @@
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Priority is the class of a request. Requests of a lower value are served
+// first when the limiter is contended.
+type Priority int
+
+const (
+	PriorityInteractive Priority = iota // requests a user is waiting for
+	PriorityDefault                     // requests without a priority
+	PriorityBatch                       // background work that can wait
+)
+
+func (p Priority) String() string {
+	switch p {
+	case PriorityInteractive:
+		return "interactive"
+	case PriorityDefault:
+		return "default"
+	case PriorityBatch:
+		return "batch"
+	default:
+		return fmt.Sprintf("priority(%d)", int(p))
+	}
+}
+
+type priorityKey struct{}
+
+// WithPriority returns a context that carries the priority of the request.
+func WithPriority(ctx context.Context, p Priority) context.Context {
+	return context.WithValue(ctx, priorityKey{}, p)
+}
+
+// PriorityFromContext returns the priority of the context or PriorityDefault.
+func PriorityFromContext(ctx context.Context) Priority {
+	if p, ok := ctx.Value(priorityKey{}).(Priority); ok {
+		return p
+	}
+
+	return PriorityDefault
+}
+
+// WeightFunc returns the number of tokens a request consumes.
+type WeightFunc func(*http.Request) int
+
+// PriorityFunc returns the priority of a request.
+type PriorityFunc func(*http.Request) Priority
+
+// PathWeights returns a WeightFunc that looks up the weight by the request
+// path. Requests with an unknown path weigh 1.
+func PathWeights(weights map[string]int) WeightFunc {
+	return func(req *http.Request) int {
+		if w, ok := weights[req.URL.Path]; ok {
+			return w
+		}
+
+		return 1
+	}
+}
+
+// PriorityConfig configures a weighted priority limiter.
+type PriorityConfig struct {
+	Rate     Rate         // tokens added per second
+	Burst    int          // maximum number of tokens, a request may not weigh more than this
+	Weight   WeightFunc   // tokens consumed by a request, defaults to 1
+	Priority PriorityFunc // priority of a request, defaults to the priority of the request context
+	MaxSkips int          // times a waiting request may be overtaken by a higher priority before it is served next, defaults to 8
+}
+
+// priorityWaiter is a request waiting for its tokens
+type priorityWaiter struct {
+	tokens   int
+	priority Priority
+	skips    int           // number of times a higher priority request was served before this one
+	ready    chan struct{} // closed once the tokens were granted
+	granted  bool
+}
+
+// priorityLimiter is a token bucket that consumes a request dependent number
+// of tokens and serves waiting requests by priority. Waiting requests are
+// served strictly one after another, so a heavy request is not starved by
+// light ones. A request that was overtaken MaxSkips times is served next
+// regardless of its priority, so low priorities never starve completely.
+type priorityLimiter struct {
+	conf PriorityConfig
+	now  func() time.Time // replaceable for tests
+	*recorder
+
+	mtx     *sync.Mutex
+	tokens  float64
+	last    time.Time         // the last time tokens were calculated
+	waiters []*priorityWaiter // in order of arrival
+	timer   *time.Timer       // wakes the limiter once the next waiter can be served
+	closed  bool
+	done    chan struct{} // closed once the limiter is closed
+}
+
+// NewPriorityLimiter returns a limiter that lets requests consume a different
+// number of tokens and serves them by priority if the limiter is contended:
+//
+//	limiter := ratelimit.NewPriorityLimiter(ratelimit.PriorityConfig{
+//		Rate:   ratelimit.Per(100, time.Minute),
+//		Burst:  20,
+//		Weight: ratelimit.PathWeights(map[string]int{"/search": 5}),
+//	})
+//
+// The bucket starts full. The priority of a request is read from its context
+// by default, see WithPriority.
+func NewPriorityLimiter(conf PriorityConfig) *priorityLimiter {
+	if conf.Burst <= 0 {
+		conf.Burst = 1
+	}
+
+	if conf.Weight == nil {
+		conf.Weight = func(*http.Request) int { return 1 }
+	}
+
+	if conf.Priority == nil {
+		conf.Priority = func(req *http.Request) Priority {
+			return PriorityFromContext(req.Context())
+		}
+	}
+
+	if conf.MaxSkips <= 0 {
+		conf.MaxSkips = 8
+	}
+
+	return &priorityLimiter{
+		conf:     conf,
+		now:      time.Now,
+		recorder: newRecorder(),
+		mtx:      &sync.Mutex{},
+		tokens:   float64(conf.Burst),
+		last:     time.Now(),
+		done:     make(chan struct{}),
+	}
+}
+
+// Wait waits for a single token with the priority of the context.
+func (l *priorityLimiter) Wait(ctx context.Context) error {
+	return l.WaitN(ctx, 1, PriorityFromContext(ctx))
+}
+
+// WaitRequest waits for the tokens the request weighs with its priority.
+func (l *priorityLimiter) WaitRequest(ctx context.Context, req *http.Request) error {
+	return l.WaitN(ctx, l.conf.Weight(req), l.conf.Priority(req))
+}
+
+// WaitN blocks until n tokens were granted to the caller or the context is
+// done. Requests with a higher priority are served first.
+func (l *priorityLimiter) WaitN(ctx context.Context, n int, p Priority) error {
+	record := l.recorder.begin()
+	err := l.waitN(ctx, n, p)
+	record(err)
+
+	return err
+}
+
+func (l *priorityLimiter) waitN(ctx context.Context, n int, p Priority) error {
+	if n <= 0 {
+		return nil
+	}
+
+	if n > l.conf.Burst {
+		return NoTokenError{
+			Err: fmt.Errorf("%d tokens exceed the burst of %d", n, l.conf.Burst),
+		}
+	}
+
+	l.mtx.Lock()
+	if l.closed {
+		l.mtx.Unlock()
+		return ErrClosed
+	}
+
+	l.advance(l.now())
+	if len(l.waiters) == 0 && l.tokens >= float64(n) {
+		l.tokens -= float64(n)
+		l.mtx.Unlock()
+		return nil
+	}
+
+	w := &priorityWaiter{
+		tokens:   n,
+		priority: p,
+		ready:    make(chan struct{}),
+	}
+	l.waiters = append(l.waiters, w)
+	l.dispatch()
+	l.mtx.Unlock()
+
+	select {
+	case <-w.ready:
+		return nil
+	case <-ctx.Done():
+		l.abandon(w)
+		return NoTokenError{
+			Err: ctx.Err(),
+		}
+	case <-l.done:
+		return ErrClosed
+	}
+}
+
+// abandon removes a waiter that gave up. If it was granted its tokens in the
+// meantime, they are given back.
+func (l *priorityLimiter) abandon(w *priorityWaiter) {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+
+	if w.granted {
+		l.advance(l.now())
+		l.tokens = math.Min(float64(l.conf.Burst), l.tokens+float64(w.tokens))
+	} else {
+		for i, waiter := range l.waiters {
+			if waiter == w {
+				l.waiters = append(l.waiters[:i], l.waiters[i+1:]...)
+				break
+			}
+		}
+	}
+
+	if !l.closed {
+		l.dispatch()
+	}
+}
+
+// advance adds the tokens for the time passed since the last call. The caller
+// must hold the lock.
+func (l *priorityLimiter) advance(now time.Time) {
+	if now.Before(l.last) {
+		return
+	}
+
+	if math.IsInf(float64(l.conf.Rate), 1) {
+		l.tokens = float64(l.conf.Burst)
+	} else {
+		l.tokens = math.Min(float64(l.conf.Burst), l.tokens+l.conf.Rate.tokensFor(now.Sub(l.last)))
+	}
+	l.last = now
+}
+
+// next returns the index of the waiter to serve next. The oldest waiter that
+// was skipped too often wins, otherwise the oldest waiter of the highest
+// priority. The caller must hold the lock.
+func (l *priorityLimiter) next() int {
+	next := 0
+	for i, w := range l.waiters {
+		if w.skips >= l.conf.MaxSkips {
+			return i
+		}
+
+		if w.priority < l.waiters[next].priority {
+			next = i
+		}
+	}
+
+	return next
+}
+
+// dispatch grants tokens to waiters as long as there are enough and arms the
+// timer for the next waiter otherwise. The caller must hold the lock.
+func (l *priorityLimiter) dispatch() {
+	l.advance(l.now())
+
+	for len(l.waiters) > 0 {
+		i := l.next()
+		w := l.waiters[i]
+
+		if l.tokens < float64(w.tokens) {
+			wait := l.conf.Rate.durationFor(float64(w.tokens) - l.tokens)
+			if l.timer != nil {
+				l.timer.Stop()
+			}
+			l.timer = time.AfterFunc(wait, l.wake)
+			return
+		}
+
+		l.tokens -= float64(w.tokens)
+		l.waiters = append(l.waiters[:i], l.waiters[i+1:]...)
+
+		// waiters of a lower priority that arrived earlier were overtaken
+		for _, waiter := range l.waiters[:i] {
+			if waiter.priority > w.priority {
+				waiter.skips++
+			}
+		}
+
+		w.granted = true
+		close(w.ready)
+	}
+}
+
+// wake is called by the timer once the next waiter can be served
+func (l *priorityLimiter) wake() {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+
+	if l.closed {
+		return
+	}
+
+	l.dispatch()
+}
+
+// Waiting returns the number of requests waiting for tokens.
+func (l *priorityLimiter) Waiting() int {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+
+	return len(l.waiters)
+}
+
+// Stats returns the stats of the limiter.
+func (l *priorityLimiter) Stats() Stats {
+	l.mtx.Lock()
+	l.advance(l.now())
+	tokens := l.tokens
+	l.mtx.Unlock()
+
+	return l.recorder.stats(tokens)
+}
+
+// Close closes the limiter. Waiting and future calls to Wait return ErrClosed.
+// Closing the limiter multiple times is a no-op.
+func (l *priorityLimiter) Close() error {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+
+	if l.closed {
+		return nil
+	}
+	l.closed = true
+
+	if l.timer != nil {
+		l.timer.Stop()
+	}
+	l.waiters = nil
+	close(l.done)
+
+	return nil
+}
@@ -0,0 +1,257 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestPriorityLimiterWeights(t *testing.T) {
+	limiter := NewPriorityLimiter(PriorityConfig{
+		Rate:   Every(time.Hour),
+		Burst:  10,
+		Weight: PathWeights(map[string]int{"/search": 5}),
+	})
+	defer limiter.Close()
+
+	wait := func(path string) error {
+		req, err := http.NewRequest(http.MethodGet, "http://example.com"+path, nil)
+		if err != nil {
+			return err
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+
+		return limiter.WaitRequest(ctx, req)
+	}
+
+	// a search costs 5 tokens, a get 1
+	for _, path := range []string{"/search", "/get"} {
+		if err := wait(path); err != nil {
+			t.Errorf("Expected no error for %s, but got %v", path, err)
+		}
+	}
+
+	var noTokenError NoTokenError
+	if err := wait("/search"); !errors.As(err, &noTokenError) {
+		t.Errorf("Expected NoTokenError, but got %v", err)
+	}
+
+	// 4 tokens are left for gets
+	for i := 0; i < 4; i++ {
+		if err := wait("/get"); err != nil {
+			t.Errorf("Expected no error for get %d, but got %v", i, err)
+		}
+	}
+
+	if err := wait("/get"); !errors.As(err, &noTokenError) {
+		t.Errorf("Expected NoTokenError, but got %v", err)
+	}
+}
+
+func TestPriorityLimiterRejectsTooHeavyRequests(t *testing.T) {
+	limiter := NewPriorityLimiter(PriorityConfig{
+		Rate:  Every(time.Millisecond),
+		Burst: 2,
+	})
+	defer limiter.Close()
+
+	var noTokenError NoTokenError
+	if err := limiter.WaitN(context.Background(), 3, PriorityDefault); !errors.As(err, &noTokenError) {
+		t.Errorf("Expected NoTokenError, but got %v", err)
+	}
+}
+
+// served records the order in which waiters were served
+type served struct {
+	mtx   sync.Mutex
+	order []Priority
+}
+
+func (s *served) wait(t *testing.T, wg *sync.WaitGroup, limiter *priorityLimiter, p Priority) {
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+
+		if err := limiter.Wait(WithPriority(context.Background(), p)); err != nil {
+			t.Errorf("Expected no error, but got %v", err)
+			return
+		}
+
+		s.mtx.Lock()
+		s.order = append(s.order, p)
+		s.mtx.Unlock()
+	}()
+}
+
+// waitServed waits until n waiters were served
+func (s *served) waitServed(t *testing.T, n int) bool {
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		s.mtx.Lock()
+		done := len(s.order) >= n
+		s.mtx.Unlock()
+
+		if done {
+			return true
+		}
+
+		if time.Now().After(deadline) {
+			t.Errorf("Expected %d waiters to be served, but timed out", n)
+			return false
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// waitQueued waits until n requests are waiting for the limiter
+func waitQueued(t *testing.T, limiter *priorityLimiter, n int) bool {
+	deadline := time.Now().Add(5 * time.Second)
+	for limiter.Waiting() < n {
+		if time.Now().After(deadline) {
+			t.Errorf("Expected %d waiters, but got %d", n, limiter.Waiting())
+			return false
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	return true
+}
+
+// release adds a single token to the limiter, so the next waiter is served.
+// The tests use a rate of one token per hour and release tokens explicitly,
+// so no waiter is served before all are queued.
+func release(limiter *priorityLimiter) {
+	limiter.mtx.Lock()
+	defer limiter.mtx.Unlock()
+
+	limiter.tokens++
+	limiter.dispatch()
+}
+
+// serveAll releases one token after another and waits for each waiter to be
+// served, so the recorded order is the order of the limiter.
+func (s *served) serveAll(t *testing.T, limiter *priorityLimiter, n int) bool {
+	for i := 1; i <= n; i++ {
+		release(limiter)
+		if !s.waitServed(t, i) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func TestPriorityLimiterServesInteractiveFirst(t *testing.T) {
+	limiter := NewPriorityLimiter(PriorityConfig{
+		Rate:  Every(time.Hour),
+		Burst: 1,
+	})
+	defer limiter.Close()
+
+	// drain the bucket
+	if err := limiter.Wait(context.Background()); err != nil {
+		t.Errorf("Expected no error, but got %v", err)
+		return
+	}
+
+	s := &served{}
+	wg := &sync.WaitGroup{}
+	defer wg.Wait()
+	defer limiter.Close()
+
+	for i, p := range []Priority{PriorityBatch, PriorityBatch, PriorityInteractive} {
+		s.wait(t, wg, limiter, p)
+		if !waitQueued(t, limiter, i+1) {
+			return
+		}
+	}
+
+	if !s.serveAll(t, limiter, 3) {
+		return
+	}
+
+	expected := []Priority{PriorityInteractive, PriorityBatch, PriorityBatch}
+	for i, p := range expected {
+		if s.order[i] != p {
+			t.Errorf("Expected %s at position %d, but got %s", p, i, s.order[i])
+		}
+	}
+}
+
+func TestPriorityLimiterDoesNotStarveBatch(t *testing.T) {
+	limiter := NewPriorityLimiter(PriorityConfig{
+		Rate:     Every(time.Hour),
+		Burst:    1,
+		MaxSkips: 2,
+	})
+	defer limiter.Close()
+
+	if err := limiter.Wait(context.Background()); err != nil {
+		t.Errorf("Expected no error, but got %v", err)
+		return
+	}
+
+	s := &served{}
+	wg := &sync.WaitGroup{}
+	defer wg.Wait()
+	defer limiter.Close()
+
+	s.wait(t, wg, limiter, PriorityBatch)
+	if !waitQueued(t, limiter, 1) {
+		return
+	}
+
+	for i := 0; i < 5; i++ {
+		s.wait(t, wg, limiter, PriorityInteractive)
+		if !waitQueued(t, limiter, i+2) {
+			return
+		}
+	}
+
+	if !s.serveAll(t, limiter, 6) {
+		return
+	}
+
+	// the batch request is overtaken twice, then served
+	if len(s.order) < 3 || s.order[2] != PriorityBatch {
+		t.Errorf("Expected batch to be served third, but got %v", s.order)
+	}
+}
+
+func TestPriorityLimiterCancelAndClose(t *testing.T) {
+	limiter := NewPriorityLimiter(PriorityConfig{
+		Rate:  Every(time.Hour),
+		Burst: 1,
+	})
+
+	if err := limiter.Wait(context.Background()); err != nil {
+		t.Errorf("Expected no error, but got %v", err)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	var noTokenError NoTokenError
+	if err := limiter.Wait(ctx); !errors.As(err, &noTokenError) {
+		t.Errorf("Expected NoTokenError, but got %v", err)
+	}
+
+	if limiter.Waiting() != 0 {
+		t.Errorf("Expected no waiters, but got %d", limiter.Waiting())
+	}
+
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		_ = limiter.Close()
+	}()
+
+	if err := limiter.Wait(context.Background()); !errors.Is(err, ErrClosed) {
+		t.Errorf("Expected ErrClosed, but got %v", err)
+	}
+}
@@ -0,0 +1,193 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// DefaultWaitBounds are the upper bounds of the buckets of the wait histogram.
+// Changes apply to limiters created afterwards.
+var DefaultWaitBounds = []time.Duration{
+	0,
+	time.Millisecond,
+	5 * time.Millisecond,
+	10 * time.Millisecond,
+	50 * time.Millisecond,
+	100 * time.Millisecond,
+	500 * time.Millisecond,
+	time.Second,
+	5 * time.Second,
+	10 * time.Second,
+}
+
+// Stats is a snapshot of the state of a rate limiter.
+type Stats struct {
+	Tokens   float64       // tokens available now, negative if tokens are reserved in advance, NaN if unknown
+	Waiters  int           // callers currently waiting
+	Admitted uint64        // calls to Wait that were admitted
+	Timeouts uint64        // calls to Wait that failed because the context was done or its deadline too short
+	Waits    WaitHistogram // time spent in Wait, admitted or not
+}
+
+// WaitHistogram counts waits by their duration.
+type WaitHistogram struct {
+	Bounds []time.Duration // upper bounds of the buckets
+	Counts []uint64        // Counts[i] is the number of waits up to Bounds[i], the last count holds the longer waits
+	Sum    time.Duration   // total time spent waiting
+}
+
+// Count returns the number of recorded waits.
+func (h WaitHistogram) Count() uint64 {
+	var n uint64
+	for _, c := range h.Counts {
+		n += c
+	}
+
+	return n
+}
+
+// recorder collects the stats of a limiter.
+type recorder struct {
+	mtx      *sync.Mutex
+	bounds   []time.Duration // the bounds of the histogram buckets, fixed when the limiter is created
+	waiters  int
+	admitted uint64
+	timeouts uint64
+	counts   []uint64
+	sum      time.Duration
+}
+
+func newRecorder() *recorder {
+	return &recorder{
+		mtx:    &sync.Mutex{},
+		bounds: append([]time.Duration(nil), DefaultWaitBounds...),
+		counts: make([]uint64, len(DefaultWaitBounds)+1),
+	}
+}
+
+// begin registers a waiter. The returned func has to be called with the
+// result of the wait.
+func (r *recorder) begin() func(err error) {
+	start := time.Now()
+
+	r.mtx.Lock()
+	r.waiters++
+	r.mtx.Unlock()
+
+	return func(err error) {
+		waited := time.Since(start)
+
+		r.mtx.Lock()
+		defer r.mtx.Unlock()
+
+		r.waiters--
+		r.sum += waited
+
+		i := 0
+		for i < len(r.bounds) && waited > r.bounds[i] {
+			i++
+		}
+		r.counts[i]++
+
+		var noTokenError NoTokenError
+		if err == nil {
+			r.admitted++
+		} else if errors.As(err, &noTokenError) {
+			r.timeouts++
+		}
+	}
+}
+
+// stats returns a snapshot with the given tokens.
+func (r *recorder) stats(tokens float64) Stats {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	return Stats{
+		Tokens:   tokens,
+		Waiters:  r.waiters,
+		Admitted: r.admitted,
+		Timeouts: r.timeouts,
+		Waits: WaitHistogram{
+			Bounds: append([]time.Duration(nil), r.bounds...),
+			Counts: append([]uint64(nil), r.counts...),
+			Sum:    r.sum,
+		},
+	}
+}
+
+// NamedLimiter is a limiter with a name, see Named.
+type NamedLimiter interface {
+	Limiter
+	Name() string
+	Stats() Stats
+	Close() error
+}
+
+// namedLimiter attaches a name to a limiter.
+type namedLimiter struct {
+	Limiter
+	name string
+}
+
+// namedRequestLimiter attaches a name to a request aware limiter.
+type namedRequestLimiter struct {
+	*namedLimiter
+	requestLimiter interface {
+		WaitRequest(context.Context, *http.Request) error
+	}
+}
+
+// Named returns the limiter with a name. The lazyhttp client reports the name
+// in its RateLimitError, so throttling can be told apart per dependency. The
+// Stats and Close of the limiter are kept. The named limiter only has the
+// request aware WaitRequest if the limiter has it, so naming a limiter does not
+// change when the client waits for it.
+func Named(name string, limiter Limiter) NamedLimiter {
+	l := &namedLimiter{
+		Limiter: limiter,
+		name:    name,
+	}
+
+	if rl, ok := limiter.(interface {
+		WaitRequest(context.Context, *http.Request) error
+	}); ok {
+		return &namedRequestLimiter{namedLimiter: l, requestLimiter: rl}
+	}
+
+	return l
+}
+
+// Name returns the name of the limiter.
+func (l *namedLimiter) Name() string {
+	return l.name
+}
+
+// WaitRequest hands the request to the limiter.
+func (l *namedRequestLimiter) WaitRequest(ctx context.Context, req *http.Request) error {
+	return l.requestLimiter.WaitRequest(ctx, req)
+}
+
+// Stats returns the stats of the limiter. Tokens are NaN and all counters 0 if
+// the limiter does not provide stats.
+func (l *namedLimiter) Stats() Stats {
+	if s, ok := l.Limiter.(interface{ Stats() Stats }); ok {
+		return s.Stats()
+	}
+
+	return Stats{Tokens: math.NaN()}
+}
+
+// Close closes the limiter if it can be closed.
+func (l *namedLimiter) Close() error {
+	if c, ok := l.Limiter.(io.Closer); ok {
+		return c.Close()
+	}
+
+	return nil
+}
@@ -0,0 +1,175 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"math"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestStats(t *testing.T) {
+	for name, newLimiter := range limiters {
+		t.Run(name, func(t *testing.T) {
+			limiter := newLimiter(1, time.Hour)
+			defer closeLimiter(limiter)
+
+			s, ok := limiter.(interface{ Stats() Stats })
+			if !ok {
+				t.Errorf("Expected limiter to provide stats")
+				return
+			}
+
+			if err := limiter.Wait(context.Background()); err != nil {
+				t.Errorf("Expected no error, but got %v", err)
+				return
+			}
+
+			// a caller is waiting for the next token until the context is done
+			ctx, cancel := context.WithCancel(context.Background())
+			errs := make(chan error)
+			go func() {
+				errs <- limiter.Wait(ctx)
+			}()
+
+			time.Sleep(10 * time.Millisecond)
+			if stats := s.Stats(); stats.Waiters != 1 {
+				t.Errorf("Expected 1 waiter, but got %d", stats.Waiters)
+			}
+
+			cancel()
+			var noTokenError NoTokenError
+			if err := <-errs; !errors.As(err, &noTokenError) {
+				t.Errorf("Expected NoTokenError, but got %v", err)
+			}
+
+			stats := s.Stats()
+			if stats.Waiters != 0 {
+				t.Errorf("Expected 0 waiters, but got %d", stats.Waiters)
+			}
+
+			if stats.Admitted != 1 {
+				t.Errorf("Expected 1 admitted wait, but got %d", stats.Admitted)
+			}
+
+			if stats.Timeouts != 1 {
+				t.Errorf("Expected 1 timeout, but got %d", stats.Timeouts)
+			}
+
+			if stats.Waits.Count() != 2 {
+				t.Errorf("Expected 2 recorded waits, but got %d", stats.Waits.Count())
+			}
+
+			if stats.Waits.Sum < 10*time.Millisecond {
+				t.Errorf("Expected to have waited at least 10ms, but got %s", stats.Waits.Sum)
+			}
+
+			if stats.Tokens > 0.01 {
+				t.Errorf("Expected no tokens, but got %f", stats.Tokens)
+			}
+		})
+	}
+}
+
+func TestStatsHistogramBuckets(t *testing.T) {
+	r := newRecorder()
+
+	r.begin()(nil)
+	record := r.begin()
+	time.Sleep(2 * time.Millisecond)
+	record(nil)
+
+	stats := r.stats(0)
+	// an immediate wait is below the 1ms bound
+	if stats.Waits.Counts[1] != 1 {
+		t.Errorf("Expected the first wait in the 1ms bucket, but got %v", stats.Waits.Counts)
+	}
+
+	// 2ms is above the 1ms bound and below the 5ms bound
+	if stats.Waits.Counts[2] != 1 {
+		t.Errorf("Expected the second wait in the 5ms bucket, but got %v", stats.Waits.Counts)
+	}
+}
+
+func TestStatsBoundsChangedLater(t *testing.T) {
+	r := newRecorder()
+
+	bounds := DefaultWaitBounds
+	defer func() { DefaultWaitBounds = bounds }()
+	DefaultWaitBounds = append(append([]time.Duration(nil), bounds...), time.Minute, time.Hour)
+
+	// the recorder keeps the bounds it was created with
+	record := r.begin()
+	time.Sleep(2 * time.Millisecond)
+	record(nil)
+
+	stats := r.stats(0)
+	if len(stats.Waits.Bounds) != len(bounds) || len(stats.Waits.Counts) != len(bounds)+1 {
+		t.Errorf("Expected %d bounds, but got %v", len(bounds), stats.Waits.Bounds)
+	}
+
+	if stats.Waits.Count() != 1 {
+		t.Errorf("Expected 1 wait, but got %v", stats.Waits.Counts)
+	}
+}
+
+func TestNamed(t *testing.T) {
+	limiter := Named("vendor", NewKeyedLimiter(KeyedConfig{
+		New: func(key string) Limiter {
+			return NewTokenBucket(Every(time.Hour), 1)
+		},
+	}))
+
+	if limiter.Name() != "vendor" {
+		t.Errorf("Expected name vendor, but got %s", limiter.Name())
+	}
+
+	req, err := http.NewRequest(http.MethodGet, "http://a.example.com/", nil)
+	if err != nil {
+		t.Errorf("Expected no error, but got %v", err)
+		return
+	}
+
+	rl, ok := limiter.(interface {
+		WaitRequest(context.Context, *http.Request) error
+	})
+	if !ok {
+		t.Errorf("Expected the named keyed limiter to be request aware")
+		return
+	}
+
+	// the request is handed to the keyed limiter, so the host has its own
+	// bucket
+	if err := rl.WaitRequest(context.Background(), req); err != nil {
+		t.Errorf("Expected no error, but got %v", err)
+	}
+
+	if stats := limiter.Stats(); stats.Admitted != 1 || !math.IsNaN(stats.Tokens) {
+		t.Errorf("Expected the stats of the keyed limiter, but got %+v", stats)
+	}
+
+	if err := limiter.Close(); err != nil {
+		t.Errorf("Expected no error, but got %v", err)
+	}
+
+	if err := limiter.Wait(context.Background()); !errors.Is(err, ErrClosed) {
+		t.Errorf("Expected ErrClosed, but got %v", err)
+	}
+}
+
+func TestNamedPlainLimiter(t *testing.T) {
+	limiter := Named("vendor", NewTokenBucket(Every(time.Hour), 1))
+	defer closeLimiter(limiter)
+
+	// a limiter that does not look at the request does not get WaitRequest
+	if _, ok := limiter.(interface {
+		WaitRequest(context.Context, *http.Request) error
+	}); ok {
+		t.Errorf("Expected the named token bucket not to be request aware")
+	}
+
+	if stats := limiter.Stats(); stats.Tokens != 1 {
+		t.Errorf("Expected the stats of the token bucket, but got %+v", stats)
+	}
+}
@@ -0,0 +1,257 @@
+package ratelimit
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Store holds rate limit state that is shared between limiters, possibly in
+// different processes. The state of a key is an opaque value that is only
+// interpreted by the limiter.
+type Store interface {
+	// Update atomically replaces the value of the key with the value returned
+	// by fn. fn receives nil if the key does not exist yet. If fn returns an
+	// error the value is not changed. fn may be called multiple times if the
+	// store retries after a conflicting update, so it must not have side
+	// effects besides its return values.
+	Update(ctx context.Context, key string, fn func(value []byte) ([]byte, error)) error
+}
+
+// StoreError is returned if the state of a limiter could not be read from or
+// written to its store.
+type StoreError struct {
+	Key string
+	Err error
+}
+
+func (e StoreError) Error() string {
+	return fmt.Sprintf("error updating rate limit state of %q: %s", e.Key, e.Err.Error())
+}
+
+func (e StoreError) Unwrap() error {
+	return e.Err
+}
+
+// memoryStore is a Store inside of a single process. It is the backend of the
+// tcp store server and useful for tests.
+type memoryStore struct {
+	mtx    *sync.Mutex
+	values map[string]versionedValue
+}
+
+type versionedValue struct {
+	version uint64
+	value   []byte
+}
+
+// NewMemoryStore returns a store that keeps the state in memory.
+func NewMemoryStore() *memoryStore {
+	return &memoryStore{
+		mtx:    &sync.Mutex{},
+		values: map[string]versionedValue{},
+	}
+}
+
+// Update implements Store.
+func (s *memoryStore) Update(ctx context.Context, key string, fn func(value []byte) ([]byte, error)) error {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	v := s.values[key]
+	value, err := fn(v.value)
+	if err != nil {
+		return err
+	}
+
+	if !bytes.Equal(value, v.value) {
+		s.values[key] = versionedValue{version: v.version + 1, value: value}
+	}
+
+	return nil
+}
+
+// get returns the value of the key and its version. The version of a missing
+// key is 0.
+func (s *memoryStore) get(key string) (uint64, []byte) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	v := s.values[key]
+	return v.version, v.value
+}
+
+// cas stores the value if the key still has the given version. It returns the
+// new version and whether the value was stored.
+func (s *memoryStore) cas(key string, version uint64, value []byte) (uint64, bool) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	v := s.values[key]
+	if v.version != version {
+		return v.version, false
+	}
+
+	s.values[key] = versionedValue{version: version + 1, value: value}
+	return version + 1, true
+}
+
+// storeLimiter runs a limiter algorithm on state kept in a store, so multiple
+// processes share the same limit. The clocks of all processes should be in
+// sync, as the state contains absolute times.
+type storeLimiter struct {
+	closer
+	store Store
+	key   string
+	now   func() time.Time // replaceable for tests
+	*recorder
+
+	// step calculates the new state for a request at now. It returns the time
+	// to wait if the request can not be admitted yet.
+	step func(state []byte, now time.Time) ([]byte, time.Duration, error)
+}
+
+// NewStoreGCRALimiter returns a GCRA limiter that keeps its state under the
+// given key in the store. All limiters using the same store and key share the
+// rate and burst.
+func NewStoreGCRALimiter(store Store, key string, rate Rate, burst int) *storeLimiter {
+	if burst <= 0 {
+		burst = 1
+	}
+	interval := rate.durationFor(1)
+
+	return &storeLimiter{
+		closer:   newCloser(),
+		store:    store,
+		key:      key,
+		now:      time.Now,
+		recorder: newRecorder(),
+		step: func(state []byte, now time.Time) ([]byte, time.Duration, error) {
+			var tat time.Time
+			if len(state) > 0 {
+				nanos, err := strconv.ParseInt(string(state), 10, 64)
+				if err != nil {
+					return nil, 0, fmt.Errorf("invalid gcra state %q: %w", state, err)
+				}
+				tat = time.Unix(0, nanos)
+			}
+
+			tat, wait := gcra(tat, now, interval, burst)
+			if wait > 0 {
+				return state, wait, nil
+			}
+
+			return []byte(strconv.FormatInt(tat.UnixNano(), 10)), 0, nil
+		},
+	}
+}
+
+// NewStoreTokenBucket returns a token bucket that keeps its state under the
+// given key in the store. All limiters using the same store and key share the
+// rate and burst. The bucket starts full.
+func NewStoreTokenBucket(store Store, key string, rate Rate, burst int) *storeLimiter {
+	return &storeLimiter{
+		closer:   newCloser(),
+		store:    store,
+		key:      key,
+		now:      time.Now,
+		recorder: newRecorder(),
+		step: func(state []byte, now time.Time) ([]byte, time.Duration, error) {
+			tokens, last := float64(burst), now
+			if len(state) > 0 {
+				var err error
+				tokens, last, err = parseBucketState(state)
+				if err != nil {
+					return nil, 0, err
+				}
+			}
+
+			if now.After(last) {
+				if math.IsInf(float64(rate), 1) {
+					tokens = float64(burst)
+				} else {
+					tokens = math.Min(float64(burst), tokens+rate.tokensFor(now.Sub(last)))
+				}
+				last = now
+			}
+
+			if tokens < 1 {
+				return state, rate.durationFor(1 - tokens), nil
+			}
+			tokens--
+
+			return []byte(strconv.FormatFloat(tokens, 'g', -1, 64) + " " + strconv.FormatInt(last.UnixNano(), 10)), 0, nil
+		},
+	}
+}
+
+// parseBucketState parses the state of a store token bucket, which consists of
+// the tokens and the time they were calculated.
+func parseBucketState(state []byte) (float64, time.Time, error) {
+	tokensField, lastField, found := strings.Cut(string(state), " ")
+	if !found {
+		return 0, time.Time{}, fmt.Errorf("invalid token bucket state %q", state)
+	}
+
+	tokens, err := strconv.ParseFloat(tokensField, 64)
+	if err != nil {
+		return 0, time.Time{}, fmt.Errorf("invalid token bucket state %q: %w", state, err)
+	}
+
+	nanos, err := strconv.ParseInt(lastField, 10, 64)
+	if err != nil {
+		return 0, time.Time{}, fmt.Errorf("invalid token bucket state %q: %w", state, err)
+	}
+
+	return tokens, time.Unix(0, nanos), nil
+}
+
+func (l *storeLimiter) take(ctx context.Context) (time.Duration, error) {
+	if l.closed() {
+		return 0, ErrClosed
+	}
+
+	var wait time.Duration
+	err := l.store.Update(ctx, l.key, func(state []byte) ([]byte, error) {
+		newState, w, err := l.step(state, l.now())
+		wait = w
+		return newState, err
+	})
+	if err != nil {
+		return 0, StoreError{
+			Key: l.key,
+			Err: err,
+		}
+	}
+
+	return wait, nil
+}
+
+// Allow reports whether a request may be sent now and accounts for it if so.
+// Errors of the store are reported as false.
+func (l *storeLimiter) Allow() bool {
+	wait, err := l.take(context.Background())
+	return err == nil && wait == 0
+}
+
+// Wait blocks until the request may be sent or the context is done.
+func (l *storeLimiter) Wait(ctx context.Context) error {
+	record := l.recorder.begin()
+	err := waitFor(ctx, l.done, l.now, func() (time.Duration, error) {
+		return l.take(ctx)
+	})
+	record(err)
+
+	return err
+}
+
+// Stats returns the stats of this limiter. The tokens are shared with other
+// processes and not known without asking the store, so they are NaN.
+func (l *storeLimiter) Stats() Stats {
+	return l.recorder.stats(math.NaN())
+}
@@ -0,0 +1,171 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// stores returns a store of every kind, each store is shared by all limiters
+// of a test as if they ran in different processes.
+func stores(t *testing.T) map[string]func() Store {
+	return map[string]func() Store{
+		"Memory": func() Store {
+			return NewMemoryStore()
+		},
+		"File": func() Store {
+			store, err := NewFileStore(FileStoreConfig{Dir: t.TempDir()})
+			if err != nil {
+				t.Fatalf("Expected no error creating the file store, but got %v", err)
+			}
+			return store
+		},
+		"TCP": func() Store {
+			return startStoreServer(t)
+		},
+	}
+}
+
+// startStoreServer starts a store server and returns a client connected to it
+func startStoreServer(t *testing.T) *tcpStore {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Expected no error listening, but got %v", err)
+	}
+
+	srv := NewStoreServer()
+	go func() {
+		_ = srv.Serve(l)
+	}()
+	t.Cleanup(func() {
+		_ = srv.Close()
+	})
+
+	return NewTCPStore(l.Addr().String())
+}
+
+func TestStoreLimitersShareTheLimit(t *testing.T) {
+	limiters := map[string]func(store Store) *storeLimiter{
+		"GCRA": func(store Store) *storeLimiter {
+			return NewStoreGCRALimiter(store, "vendor", Every(time.Hour), 5)
+		},
+		"TokenBucket": func(store Store) *storeLimiter {
+			return NewStoreTokenBucket(store, "vendor", Every(time.Hour), 5)
+		},
+	}
+
+	for storeName, newStore := range stores(t) {
+		for limiterName, newLimiter := range limiters {
+			t.Run(storeName+"/"+limiterName, func(t *testing.T) {
+				store := newStore()
+
+				var mtx sync.Mutex
+				admitted := 0
+
+				// 4 replicas with their own limiter send 5 requests each
+				var wg sync.WaitGroup
+				for i := 0; i < 4; i++ {
+					limiter := newLimiter(store)
+
+					wg.Add(1)
+					go func() {
+						defer wg.Done()
+
+						for j := 0; j < 5; j++ {
+							ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+							err := limiter.Wait(ctx)
+							cancel()
+
+							var noTokenError NoTokenError
+							if err != nil && !errors.As(err, &noTokenError) {
+								t.Errorf("Expected NoTokenError, but got %v", err)
+								continue
+							}
+
+							if err == nil {
+								mtx.Lock()
+								admitted++
+								mtx.Unlock()
+							}
+						}
+					}()
+				}
+				wg.Wait()
+
+				if admitted != 5 {
+					t.Errorf("Expected 5 admitted requests across all replicas, but got %d", admitted)
+				}
+			})
+		}
+	}
+}
+
+func TestStoreLimiterPacing(t *testing.T) {
+	clock := &fakeClock{t: time.Now()}
+	limiter := NewStoreGCRALimiter(NewMemoryStore(), "vendor", Every(time.Second), 1)
+	limiter.now = clock.now
+
+	if !limiter.Allow() || limiter.Allow() {
+		t.Errorf("Expected a single request to be admitted")
+	}
+
+	clock.advance(time.Second)
+	if !limiter.Allow() {
+		t.Errorf("Expected a request to be admitted after 1s")
+	}
+}
+
+func TestStoreLimiterInvalidState(t *testing.T) {
+	store := NewMemoryStore()
+	_ = store.Update(context.Background(), "vendor", func([]byte) ([]byte, error) {
+		return []byte("garbage"), nil
+	})
+
+	limiter := NewStoreTokenBucket(store, "vendor", Every(time.Second), 1)
+
+	var storeError StoreError
+	if err := limiter.Wait(context.Background()); !errors.As(err, &storeError) {
+		t.Errorf("Expected StoreError, but got %v", err)
+	}
+}
+
+func TestStoresUpdateAtomically(t *testing.T) {
+	for name, newStore := range stores(t) {
+		t.Run(name, func(t *testing.T) {
+			store := newStore()
+
+			var wg sync.WaitGroup
+			for i := 0; i < 10; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+
+					for j := 0; j < 10; j++ {
+						err := store.Update(context.Background(), "counter", func(value []byte) ([]byte, error) {
+							n, _ := strconv.Atoi(string(value))
+							return []byte(strconv.Itoa(n + 1)), nil
+						})
+						if err != nil {
+							t.Errorf("Expected no error, but got %v", err)
+						}
+					}
+				}()
+			}
+			wg.Wait()
+
+			var counter string
+			_ = store.Update(context.Background(), "counter", func(value []byte) ([]byte, error) {
+				counter = string(value)
+				return value, nil
+			})
+
+			if counter != "100" {
+				t.Errorf("Expected counter to be 100, but got %s", counter)
+			}
+		})
+	}
+}
@@ -0,0 +1,299 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sync"
+	"testing"
+	"time"
+)
+
+// limiterFactory creates a limiter that admits burst requests at once and
+// refills them within the given interval.
+type limiterFactory func(burst int, interval time.Duration) Limiter
+
+// limiters is the common test suite all rate limiters of this pkg run against
+var limiters = map[string]limiterFactory{
+	"TickerTokenBucket": func(burst int, interval time.Duration) Limiter {
+		l := NewTokenBucketRateLimiter(*time.NewTicker(interval), burst, interval*10)
+		// give the goroutine time to prefill the bucket
+		time.Sleep(5 * time.Millisecond)
+		return l
+	},
+	"TokenBucket": func(burst int, interval time.Duration) Limiter {
+		return NewTokenBucket(Per(burst, interval), burst)
+	},
+	"SlidingLog": func(burst int, interval time.Duration) Limiter {
+		return NewSlidingLogLimiter(burst, interval)
+	},
+	"SlidingWindow": func(burst int, interval time.Duration) Limiter {
+		return NewSlidingWindowLimiter(burst, interval)
+	},
+	"FixedWindow": func(burst int, interval time.Duration) Limiter {
+		return NewFixedWindowLimiter(burst, interval)
+	},
+	"GCRA": func(burst int, interval time.Duration) Limiter {
+		return NewGCRALimiter(Per(burst, interval), burst)
+	},
+}
+
+func TestLimiterSuiteBurst(t *testing.T) {
+	for name, newLimiter := range limiters {
+		t.Run(name, func(t *testing.T) {
+			limiter := newLimiter(5, time.Hour)
+			defer closeLimiter(limiter)
+
+			for i := 0; i < 5; i++ {
+				ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+				err := limiter.Wait(ctx)
+				cancel()
+
+				if err != nil {
+					t.Errorf("Expected request %d to be admitted, but got %v", i, err)
+				}
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+			defer cancel()
+
+			var noTokenError NoTokenError
+			if err := limiter.Wait(ctx); !errors.As(err, &noTokenError) {
+				t.Errorf("Expected NoTokenError after the burst, but got %v", err)
+			}
+		})
+	}
+}
+
+// clockAligned are the limiters with windows aligned to the clock. They may
+// refill right after the burst if it happened at the end of a window.
+var clockAligned = map[string]bool{
+	"SlidingWindow": true,
+	"FixedWindow":   true,
+}
+
+func TestLimiterSuiteRefill(t *testing.T) {
+	for name, newLimiter := range limiters {
+		t.Run(name, func(t *testing.T) {
+			interval := 100 * time.Millisecond
+			limiter := newLimiter(2, interval)
+			defer closeLimiter(limiter)
+
+			start := time.Now()
+			for i := 0; i < 4; i++ {
+				if err := limiter.Wait(context.Background()); err != nil {
+					t.Errorf("Expected request %d to be admitted, but got %v", i, err)
+				}
+			}
+
+			// the second pair of requests has to wait for a refill. Windows
+			// aligned to the clock may refill at any time up to the interval.
+			elapsed := time.Since(start)
+			if !clockAligned[name] && elapsed < 5*time.Millisecond {
+				t.Errorf("Expected the refill to take some time, but took %s", elapsed)
+			}
+
+			if elapsed > 3*interval {
+				t.Errorf("Expected the refill to take up to %s, but took %s", interval, elapsed)
+			}
+		})
+	}
+}
+
+func TestLimiterSuiteClose(t *testing.T) {
+	for name, newLimiter := range limiters {
+		t.Run(name, func(t *testing.T) {
+			limiter := newLimiter(1, time.Hour)
+
+			closer, ok := limiter.(io.Closer)
+			if !ok {
+				t.Errorf("Expected limiter to implement io.Closer")
+				return
+			}
+
+			go func() {
+				time.Sleep(10 * time.Millisecond)
+				_ = closer.Close()
+			}()
+
+			// the first request may be admitted, the second blocks until the
+			// limiter is closed
+			var err error
+			for i := 0; i < 2 && err == nil; i++ {
+				err = limiter.Wait(context.Background())
+			}
+
+			if !errors.Is(err, ErrClosed) {
+				t.Errorf("Expected ErrClosed, but got %v", err)
+			}
+		})
+	}
+}
+
+func TestLimiterSuiteConcurrent(t *testing.T) {
+	for name, newLimiter := range limiters {
+		t.Run(name, func(t *testing.T) {
+			limiter := newLimiter(10, time.Hour)
+			defer closeLimiter(limiter)
+
+			var mtx sync.Mutex
+			admitted := 0
+
+			var wg sync.WaitGroup
+			for i := 0; i < 20; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+
+					ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+					defer cancel()
+
+					if limiter.Wait(ctx) == nil {
+						mtx.Lock()
+						admitted++
+						mtx.Unlock()
+					}
+				}()
+			}
+			wg.Wait()
+
+			if admitted != 10 {
+				t.Errorf("Expected 10 admitted requests, but got %d", admitted)
+			}
+		})
+	}
+}
+
+func TestSlidingLogIsExact(t *testing.T) {
+	clock := &fakeClock{t: time.Now()}
+	l := NewSlidingLogLimiter(3, time.Minute)
+	l.now = clock.now
+
+	// 3 requests spread over the window
+	for i := 0; i < 3; i++ {
+		if !l.Allow() {
+			t.Errorf("Expected request %d to be admitted", i)
+		}
+		clock.advance(20 * time.Second)
+	}
+
+	// the first request left the window exactly now
+	if !l.Allow() {
+		t.Errorf("Expected a request after the first left the window")
+	}
+
+	if l.Allow() {
+		t.Errorf("Expected no more than 3 requests in any 60s")
+	}
+
+	wait, _ := l.take()
+	if wait != 20*time.Second {
+		t.Errorf("Expected to wait 20s for the second request to leave the window, but got %s", wait)
+	}
+}
+
+func TestSlidingWindowWeightsPreviousWindow(t *testing.T) {
+	clock := &fakeClock{t: time.Now().Truncate(time.Minute)}
+	l := NewSlidingWindowLimiter(10, time.Minute)
+	l.now = clock.now
+
+	for i := 0; i < 10; i++ {
+		if !l.Allow() {
+			t.Errorf("Expected request %d to be admitted", i)
+		}
+	}
+
+	// half of the previous window overlaps, so 5 requests are free
+	clock.advance(90 * time.Second)
+	for i := 0; i < 5; i++ {
+		if !l.Allow() {
+			t.Errorf("Expected request %d to be admitted", i)
+		}
+	}
+
+	if l.Allow() {
+		t.Errorf("Expected the weighted limit to be exhausted")
+	}
+}
+
+func TestFixedWindowResetsAtBoundary(t *testing.T) {
+	clock := &fakeClock{t: time.Now().Truncate(time.Minute)}
+	l := NewFixedWindowLimiter(2, time.Minute)
+	l.now = clock.now
+
+	clock.advance(59 * time.Second)
+	if !l.Allow() || !l.Allow() || l.Allow() {
+		t.Errorf("Expected 2 requests in the first window")
+	}
+
+	wait, _ := l.take()
+	if wait != time.Second {
+		t.Errorf("Expected to wait 1s for the next window, but got %s", wait)
+	}
+
+	clock.advance(time.Second)
+	if !l.Allow() {
+		t.Errorf("Expected a request in the next window")
+	}
+}
+
+func TestGCRAPacesAfterBurst(t *testing.T) {
+	clock := &fakeClock{t: time.Now()}
+	l := NewGCRALimiter(Every(time.Second), 2)
+	l.now = clock.now
+
+	if !l.Allow() || !l.Allow() || l.Allow() {
+		t.Errorf("Expected a burst of 2 requests")
+	}
+
+	wait, _ := l.take()
+	if wait != time.Second {
+		t.Errorf("Expected to wait 1s, but got %s", wait)
+	}
+
+	clock.advance(time.Second)
+	if !l.Allow() || l.Allow() {
+		t.Errorf("Expected a single request after 1s")
+	}
+}
+
+func BenchmarkLimiters(b *testing.B) {
+	for name, newLimiter := range limiters {
+		if name == "TickerTokenBucket" {
+			// the ticker based bucket can not refill fast enough to not block
+			continue
+		}
+
+		b.Run(name, func(b *testing.B) {
+			limiter := newLimiter(1<<20, time.Millisecond)
+			defer closeLimiter(limiter)
+
+			ctx := context.Background()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				_ = limiter.Wait(ctx)
+			}
+		})
+	}
+}
+
+func BenchmarkLimitersParallel(b *testing.B) {
+	for name, newLimiter := range limiters {
+		if name == "TickerTokenBucket" {
+			continue
+		}
+
+		b.Run(name, func(b *testing.B) {
+			limiter := newLimiter(1<<20, time.Millisecond)
+			defer closeLimiter(limiter)
+
+			ctx := context.Background()
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					_ = limiter.Wait(ctx)
+				}
+			})
+		})
+	}
+}
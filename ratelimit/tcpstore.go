@@ -0,0 +1,266 @@
+package ratelimit
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+)
+
+// The tcp store speaks a line based protocol of json messages. A client sends
+// a get or a compare and swap and the server replies with the current version
+// and value of the key. Values are base64 encoded by encoding/json.
+type storeRequest struct {
+	Op      string `json:"op"` // "get" or "cas"
+	Key     string `json:"key"`
+	Version uint64 `json:"version,omitempty"`
+	Value   []byte `json:"value,omitempty"`
+}
+
+type storeResponse struct {
+	Version uint64 `json:"version"`
+	Value   []byte `json:"value,omitempty"`
+	OK      bool   `json:"ok"`
+	Err     string `json:"err,omitempty"`
+}
+
+// storeServer is a minimal key value server for the tcp store. It keeps all
+// values in memory and stands in for a real shared store in tests and small
+// deployments.
+type storeServer struct {
+	store *memoryStore
+
+	mtx      *sync.Mutex
+	listener net.Listener
+	conns    map[net.Conn]struct{}
+	closed   bool
+	wg       *sync.WaitGroup
+}
+
+// NewStoreServer returns a key value server for the tcp store.
+func NewStoreServer() *storeServer {
+	return &storeServer{
+		store: NewMemoryStore(),
+		mtx:   &sync.Mutex{},
+		conns: map[net.Conn]struct{}{},
+		wg:    &sync.WaitGroup{},
+	}
+}
+
+// Serve accepts connections on the listener until the server is closed.
+func (s *storeServer) Serve(l net.Listener) error {
+	s.mtx.Lock()
+	if s.closed {
+		s.mtx.Unlock()
+		return ErrClosed
+	}
+	s.listener = l
+	s.mtx.Unlock()
+
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			s.mtx.Lock()
+			closed := s.closed
+			s.mtx.Unlock()
+
+			if closed {
+				return ErrClosed
+			}
+
+			return fmt.Errorf("error accepting connection: %w", err)
+		}
+
+		s.mtx.Lock()
+		if s.closed {
+			s.mtx.Unlock()
+			_ = conn.Close()
+			return ErrClosed
+		}
+		s.conns[conn] = struct{}{}
+		s.wg.Add(1)
+		s.mtx.Unlock()
+
+		go s.serveConn(conn)
+	}
+}
+
+func (s *storeServer) serveConn(conn net.Conn) {
+	defer func() {
+		s.mtx.Lock()
+		delete(s.conns, conn)
+		s.mtx.Unlock()
+
+		_ = conn.Close()
+		s.wg.Done()
+	}()
+
+	dec := json.NewDecoder(bufio.NewReader(conn))
+	enc := json.NewEncoder(conn)
+
+	for {
+		var req storeRequest
+		if err := dec.Decode(&req); err != nil {
+			return
+		}
+
+		var res storeResponse
+		switch req.Op {
+		case "get":
+			res.Version, res.Value = s.store.get(req.Key)
+			res.OK = true
+		case "cas":
+			res.Version, res.OK = s.store.cas(req.Key, req.Version, req.Value)
+		default:
+			res.Err = fmt.Sprintf("unknown operation %q", req.Op)
+		}
+
+		if err := enc.Encode(res); err != nil {
+			return
+		}
+	}
+}
+
+// Close stops accepting connections, closes all open connections and waits
+// for their handlers to return.
+func (s *storeServer) Close() error {
+	s.mtx.Lock()
+	if s.closed {
+		s.mtx.Unlock()
+		return nil
+	}
+	s.closed = true
+
+	if s.listener != nil {
+		_ = s.listener.Close()
+	}
+
+	for conn := range s.conns {
+		_ = conn.Close()
+	}
+	s.mtx.Unlock()
+
+	s.wg.Wait()
+	return nil
+}
+
+// tcpStore is the client of a store server. It updates values with an
+// optimistic compare and swap, so concurrent updates of different processes
+// are retried instead of overwriting each other.
+type tcpStore struct {
+	addr        string
+	dialTimeout time.Duration
+
+	mtx  *sync.Mutex // a connection serves one request at a time
+	conn net.Conn
+	dec  *json.Decoder
+	enc  *json.Encoder
+}
+
+// NewTCPStore returns a store that keeps the state on the store server at the
+// given address. The connection is established lazily and re-established after
+// errors.
+func NewTCPStore(addr string) *tcpStore {
+	return &tcpStore{
+		addr:        addr,
+		dialTimeout: 5 * time.Second,
+		mtx:         &sync.Mutex{},
+	}
+}
+
+// Update implements Store.
+func (s *tcpStore) Update(ctx context.Context, key string, fn func(value []byte) ([]byte, error)) error {
+	for {
+		get, err := s.roundTrip(ctx, storeRequest{Op: "get", Key: key})
+		if err != nil {
+			return err
+		}
+
+		value, err := fn(get.Value)
+		if err != nil {
+			return err
+		}
+
+		if bytes.Equal(value, get.Value) {
+			return nil
+		}
+
+		cas, err := s.roundTrip(ctx, storeRequest{Op: "cas", Key: key, Version: get.Version, Value: value})
+		if err != nil {
+			return err
+		}
+
+		if cas.OK {
+			return nil
+		}
+
+		// another process updated the key in the meantime, try again with its
+		// value
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+	}
+}
+
+// roundTrip sends the request and reads the response. The connection is
+// dropped on errors, so the next request dials a new one.
+func (s *tcpStore) roundTrip(ctx context.Context, req storeRequest) (storeResponse, error) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	if s.conn == nil {
+		d := net.Dialer{Timeout: s.dialTimeout}
+		conn, err := d.DialContext(ctx, "tcp", s.addr)
+		if err != nil {
+			return storeResponse{}, fmt.Errorf("error connecting to store: %w", err)
+		}
+
+		s.conn = conn
+		s.dec = json.NewDecoder(bufio.NewReader(conn))
+		s.enc = json.NewEncoder(conn)
+	}
+
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = s.conn.SetDeadline(deadline)
+	} else {
+		_ = s.conn.SetDeadline(time.Time{})
+	}
+
+	var res storeResponse
+	err := s.enc.Encode(req)
+	if err == nil {
+		err = s.dec.Decode(&res)
+	}
+
+	if err != nil {
+		_ = s.conn.Close()
+		s.conn = nil
+		return storeResponse{}, fmt.Errorf("error talking to store: %w", err)
+	}
+
+	if res.Err != "" {
+		return storeResponse{}, errors.New(res.Err)
+	}
+
+	return res, nil
+}
+
+// Close closes the connection to the store server.
+func (s *tcpStore) Close() error {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	if s.conn == nil {
+		return nil
+	}
+
+	err := s.conn.Close()
+	s.conn = nil
+
+	return err
+}
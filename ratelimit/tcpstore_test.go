@@ -0,0 +1,60 @@
+package ratelimit
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestTCPStoreReconnects(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Errorf("Expected no error, but got %v", err)
+		return
+	}
+	addr := l.Addr().String()
+
+	srv := NewStoreServer()
+	go func() {
+		_ = srv.Serve(l)
+	}()
+
+	store := NewTCPStore(addr)
+	defer store.Close()
+
+	set := func(value string) func([]byte) ([]byte, error) {
+		return func([]byte) ([]byte, error) { return []byte(value), nil }
+	}
+
+	if err := store.Update(context.Background(), "vendor", set("a")); err != nil {
+		t.Errorf("Expected no error, but got %v", err)
+	}
+
+	// the server goes away, the store reports the error
+	_ = srv.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	if err := store.Update(ctx, "vendor", set("b")); err == nil {
+		t.Errorf("Expected an error without a server")
+	}
+
+	// a new server on the same address is picked up
+	l, err = net.Listen("tcp", addr)
+	if err != nil {
+		t.Errorf("Expected no error, but got %v", err)
+		return
+	}
+
+	srv = NewStoreServer()
+	go func() {
+		_ = srv.Serve(l)
+	}()
+	defer srv.Close()
+
+	if err := store.Update(context.Background(), "vendor", set("c")); err != nil {
+		t.Errorf("Expected no error, but got %v", err)
+	}
+}
@@ -2,11 +2,15 @@ package ratelimit
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"sync"
 	"time"
 )
 
+// ErrClosed is returned by the limiters of this pkg once they are closed.
+var ErrClosed = errors.New("rate limiter closed")
+
 type NoTokenError struct {
 	Err error
 }
@@ -15,26 +19,41 @@ func (e NoTokenError) Error() string {
 	return fmt.Sprintf("no token available in time: %s", e.Err.Error())
 }
 
+func (e NoTokenError) Unwrap() error {
+	return e.Err
+}
+
 type tokenBucketRateLimiter struct {
 	t       *time.Ticker  // tell us how often to fill the bucket
 	timeout time.Duration // the maximum time to wait for a token if the bucket is empty and the caller does not provide a deadline
 
+	*recorder
+
 	mtx    *sync.Mutex   // protect the bucket to allow concurrent access
 	bucket chan struct{} // the bucket
+
+	done      chan struct{} // closed to stop the refill goroutine
+	closeOnce *sync.Once
 }
 
 // NewTokenBucketRateLimiter returns a new token bucket rate limiter. The rate
-// limiter will fill the bucket with tokens at the given tick rate.
+// limiter will fill the bucket with tokens at the given tick rate. The refill
+// goroutine runs until the limiter is closed. The ticker is still owned by the
+// caller and has to be stopped by the caller.
 func NewTokenBucketRateLimiter(t time.Ticker, maxTokens int, timeout time.Duration) *tokenBucketRateLimiter {
 	if timeout == 0 {
 		timeout = time.Second * 30
 	}
 
 	lim := &tokenBucketRateLimiter{
-		t:       &t,
-		timeout: timeout,
-		mtx:     &sync.Mutex{},
-		bucket:  make(chan struct{}, maxTokens),
+		t:        &t,
+		timeout:  timeout,
+		recorder: newRecorder(),
+		mtx:      &sync.Mutex{},
+		bucket:   make(chan struct{}, maxTokens),
+
+		done:      make(chan struct{}),
+		closeOnce: &sync.Once{},
 	}
 
 	go func() {
@@ -43,8 +62,14 @@ func NewTokenBucketRateLimiter(t time.Ticker, maxTokens int, timeout time.Durati
 			lim.bucket <- struct{}{}
 		}
 
-		// for each tick fill up the bucket
-		for range t.C {
+		// for each tick fill up the bucket until the limiter is closed
+		for {
+			select {
+			case <-lim.done:
+				return
+			case <-t.C:
+			}
+
 			lim.mtx.Lock()
 
 			// fill the bucket
@@ -61,6 +86,14 @@ func NewTokenBucketRateLimiter(t time.Ticker, maxTokens int, timeout time.Durati
 
 // Wait blocks until a token is available or the context is done.
 func (l *tokenBucketRateLimiter) Wait(ctx context.Context) error {
+	record := l.recorder.begin()
+	err := l.wait(ctx)
+	record(err)
+
+	return err
+}
+
+func (l *tokenBucketRateLimiter) wait(ctx context.Context) error {
 	_, ok := ctx.Deadline()
 	if !ok {
 		// predeclare cancel so we can wrap the parent ctx in the scope of the
@@ -70,6 +103,13 @@ func (l *tokenBucketRateLimiter) Wait(ctx context.Context) error {
 		defer cancel()
 	}
 
+	// a closed limiter never hands out tokens again, even if some are left
+	select {
+	case <-l.done:
+		return ErrClosed
+	default:
+	}
+
 	select {
 	case <-ctx.Done():
 		// context timed out, return an error describing that no token was
@@ -77,7 +117,24 @@ func (l *tokenBucketRateLimiter) Wait(ctx context.Context) error {
 		return NoTokenError{
 			Err: ctx.Err(),
 		}
+	case <-l.done:
+		return ErrClosed
 	case <-l.bucket:
 		return nil
 	}
 }
+
+// Stats returns the stats of the limiter.
+func (l *tokenBucketRateLimiter) Stats() Stats {
+	return l.recorder.stats(float64(len(l.bucket)))
+}
+
+// Close stops the refill goroutine. Waiting and future calls to Wait return
+// ErrClosed. Closing the limiter multiple times is a no-op.
+func (l *tokenBucketRateLimiter) Close() error {
+	l.closeOnce.Do(func() {
+		close(l.done)
+	})
+
+	return nil
+}
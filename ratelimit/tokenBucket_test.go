@@ -158,3 +158,34 @@ func TestRateLimiterWaitConsidersCtxTimeout(t *testing.T) {
 	}
 
 }
+
+func TestRateLimiterClose(t *testing.T) {
+	ticker := time.NewTicker(10 * time.Second)
+	defer ticker.Stop()
+
+	limiter := NewTokenBucketRateLimiter(*ticker, 1, time.Second)
+	time.Sleep(5 * time.Millisecond)
+
+	// drain the bucket so the next wait blocks until the limiter is closed
+	<-limiter.bucket
+
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		_ = limiter.Close()
+	}()
+
+	err := limiter.Wait(context.Background())
+	if !errors.Is(err, ErrClosed) {
+		t.Errorf("Expected ErrClosed, but got %v", err)
+	}
+
+	// closing twice is fine
+	if err := limiter.Close(); err != nil {
+		t.Errorf("Expected no error, but got %v", err)
+	}
+
+	err = limiter.Wait(context.Background())
+	if !errors.Is(err, ErrClosed) {
+		t.Errorf("Expected ErrClosed, but got %v", err)
+	}
+}
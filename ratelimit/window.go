@@ -0,0 +1,369 @@
+package ratelimit
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// waitFor calls take until it admits the request, the limiter is closed or the
+// context is done. take returns 0 if the request was admitted or the time to
+// wait until it may be admitted. If the context deadline is before that time,
+// waitFor fails fast.
+func waitFor(ctx context.Context, done <-chan struct{}, now func() time.Time, take func() (time.Duration, error)) error {
+	for {
+		wait, err := take()
+		if err != nil {
+			return err
+		}
+
+		if wait <= 0 {
+			return nil
+		}
+
+		if deadline, ok := ctx.Deadline(); ok && deadline.Before(now().Add(wait)) {
+			return NoTokenError{
+				Err: context.DeadlineExceeded,
+			}
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return NoTokenError{
+				Err: ctx.Err(),
+			}
+		case <-done:
+			timer.Stop()
+			return ErrClosed
+		case <-timer.C:
+		}
+	}
+}
+
+// closer implements the Close lifecycle shared by the window limiters
+type closer struct {
+	done      chan struct{}
+	closeOnce *sync.Once
+}
+
+func newCloser() closer {
+	return closer{
+		done:      make(chan struct{}),
+		closeOnce: &sync.Once{},
+	}
+}
+
+// closed reports whether the limiter was closed
+func (c closer) closed() bool {
+	select {
+	case <-c.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// Close closes the limiter. Waiting and future calls to Wait return ErrClosed.
+// Closing the limiter multiple times is a no-op.
+func (c closer) Close() error {
+	c.closeOnce.Do(func() {
+		close(c.done)
+	})
+
+	return nil
+}
+
+// slidingLogLimiter remembers the time of every admitted request and admits a
+// request if less than limit requests were admitted in the window before it.
+// It is exact but needs memory proportional to the limit.
+type slidingLogLimiter struct {
+	closer
+	limit  int
+	window time.Duration
+	now    func() time.Time // replaceable for tests
+	*recorder
+
+	mtx  *sync.Mutex
+	log  []time.Time // ring buffer of the admitted requests, oldest first starting at head
+	head int
+}
+
+// NewSlidingLogLimiter returns a limiter that admits at most limit requests in
+// any rolling window, e.g. "max 100 requests in any 60s".
+func NewSlidingLogLimiter(limit int, window time.Duration) *slidingLogLimiter {
+	return &slidingLogLimiter{
+		closer:   newCloser(),
+		limit:    limit,
+		window:   window,
+		now:      time.Now,
+		recorder: newRecorder(),
+		mtx:      &sync.Mutex{},
+		log:      make([]time.Time, 0, limit),
+	}
+}
+
+// take admits the request or returns the time until the oldest request leaves
+// the window.
+func (l *slidingLogLimiter) take() (time.Duration, error) {
+	if l.closed() {
+		return 0, ErrClosed
+	}
+
+	now := l.now()
+
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+
+	if l.limit <= 0 {
+		return l.window, nil
+	}
+
+	if len(l.log) < l.limit {
+		l.log = append(l.log, now)
+		return 0, nil
+	}
+
+	oldest := l.log[l.head]
+	if wait := oldest.Add(l.window).Sub(now); wait > 0 {
+		return wait, nil
+	}
+
+	// the oldest request left the window, replace it with this one
+	l.log[l.head] = now
+	l.head = (l.head + 1) % len(l.log)
+
+	return 0, nil
+}
+
+// Allow reports whether a request may be sent now and accounts for it if so.
+func (l *slidingLogLimiter) Allow() bool {
+	wait, err := l.take()
+	return err == nil && wait == 0
+}
+
+// Wait blocks until the request may be sent or the context is done.
+func (l *slidingLogLimiter) Wait(ctx context.Context) error {
+	record := l.recorder.begin()
+	err := waitFor(ctx, l.done, l.now, l.take)
+	record(err)
+
+	return err
+}
+
+// Stats returns the stats of the limiter.
+func (l *slidingLogLimiter) Stats() Stats {
+	now := l.now()
+
+	l.mtx.Lock()
+	inWindow := 0
+	for _, t := range l.log {
+		if t.Add(l.window).After(now) {
+			inWindow++
+		}
+	}
+	l.mtx.Unlock()
+
+	return l.recorder.stats(float64(l.limit - inWindow))
+}
+
+// slidingWindowLimiter approximates a sliding window with the counts of the
+// current and the previous fixed window. The count of the previous window is
+// weighted by the part of it that still overlaps the sliding window. It needs
+// constant memory but may admit slightly more or less requests than the exact
+// sliding log.
+type slidingWindowLimiter struct {
+	closer
+	limit  int
+	window time.Duration
+	now    func() time.Time // replaceable for tests
+	*recorder
+
+	mtx      *sync.Mutex
+	start    time.Time // start of the current fixed window
+	current  int
+	previous int
+}
+
+// NewSlidingWindowLimiter returns a limiter that admits about limit requests in
+// any rolling window.
+func NewSlidingWindowLimiter(limit int, window time.Duration) *slidingWindowLimiter {
+	return &slidingWindowLimiter{
+		closer:   newCloser(),
+		limit:    limit,
+		window:   window,
+		now:      time.Now,
+		recorder: newRecorder(),
+		mtx:      &sync.Mutex{},
+	}
+}
+
+// advance moves the fixed windows forward. The caller must hold the lock.
+func (l *slidingWindowLimiter) advance(now time.Time) {
+	start := now.Truncate(l.window)
+	if start.Equal(l.start) {
+		return
+	}
+
+	if start.Sub(l.start) == l.window {
+		l.previous = l.current
+	} else {
+		l.previous = 0
+	}
+
+	l.current = 0
+	l.start = start
+}
+
+func (l *slidingWindowLimiter) take() (time.Duration, error) {
+	if l.closed() {
+		return 0, ErrClosed
+	}
+
+	now := l.now()
+
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+
+	if l.limit <= 0 {
+		return l.window, nil
+	}
+
+	l.advance(now)
+
+	elapsed := now.Sub(l.start)
+	weight := 1 - float64(elapsed)/float64(l.window)
+	if float64(l.previous)*weight+float64(l.current)+1 <= float64(l.limit) {
+		l.current++
+		return 0, nil
+	}
+
+	// the current window alone exhausts the limit, wait for the next window
+	next := l.start.Add(l.window).Sub(now)
+	if l.current+1 > l.limit || l.previous == 0 {
+		return next, nil
+	}
+
+	// wait until the weight of the previous window dropped far enough
+	free := float64(l.limit-l.current-1) / float64(l.previous)
+	wait := time.Duration((1-free)*float64(l.window)) - elapsed
+	if wait <= 0 {
+		wait = time.Millisecond
+	}
+
+	if wait > next {
+		wait = next
+	}
+
+	return wait, nil
+}
+
+// Allow reports whether a request may be sent now and accounts for it if so.
+func (l *slidingWindowLimiter) Allow() bool {
+	wait, err := l.take()
+	return err == nil && wait == 0
+}
+
+// Wait blocks until the request may be sent or the context is done.
+func (l *slidingWindowLimiter) Wait(ctx context.Context) error {
+	record := l.recorder.begin()
+	err := waitFor(ctx, l.done, l.now, l.take)
+	record(err)
+
+	return err
+}
+
+// Stats returns the stats of the limiter.
+func (l *slidingWindowLimiter) Stats() Stats {
+	now := l.now()
+
+	l.mtx.Lock()
+	l.advance(now)
+	weight := 1 - float64(now.Sub(l.start))/float64(l.window)
+	used := float64(l.previous)*weight + float64(l.current)
+	l.mtx.Unlock()
+
+	return l.recorder.stats(float64(l.limit) - used)
+}
+
+// fixedWindowLimiter admits limit requests per fixed window. Windows are aligned
+// to multiples of the window duration. Up to twice the limit may be admitted
+// around the boundary of two windows.
+type fixedWindowLimiter struct {
+	closer
+	limit  int
+	window time.Duration
+	now    func() time.Time // replaceable for tests
+	*recorder
+
+	mtx   *sync.Mutex
+	start time.Time // start of the current window
+	count int
+}
+
+// NewFixedWindowLimiter returns a limiter that admits at most limit requests
+// per fixed window.
+func NewFixedWindowLimiter(limit int, window time.Duration) *fixedWindowLimiter {
+	return &fixedWindowLimiter{
+		closer:   newCloser(),
+		limit:    limit,
+		window:   window,
+		now:      time.Now,
+		recorder: newRecorder(),
+		mtx:      &sync.Mutex{},
+	}
+}
+
+func (l *fixedWindowLimiter) take() (time.Duration, error) {
+	if l.closed() {
+		return 0, ErrClosed
+	}
+
+	now := l.now()
+
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+
+	start := now.Truncate(l.window)
+	if !start.Equal(l.start) {
+		l.start = start
+		l.count = 0
+	}
+
+	if l.count < l.limit {
+		l.count++
+		return 0, nil
+	}
+
+	return l.start.Add(l.window).Sub(now), nil
+}
+
+// Allow reports whether a request may be sent now and accounts for it if so.
+func (l *fixedWindowLimiter) Allow() bool {
+	wait, err := l.take()
+	return err == nil && wait == 0
+}
+
+// Wait blocks until the request may be sent or the context is done.
+func (l *fixedWindowLimiter) Wait(ctx context.Context) error {
+	record := l.recorder.begin()
+	err := waitFor(ctx, l.done, l.now, l.take)
+	record(err)
+
+	return err
+}
+
+// Stats returns the stats of the limiter.
+func (l *fixedWindowLimiter) Stats() Stats {
+	now := l.now()
+
+	l.mtx.Lock()
+	used := 0
+	if now.Truncate(l.window).Equal(l.start) {
+		used = l.count
+	}
+	l.mtx.Unlock()
+
+	return l.recorder.stats(float64(l.limit - used))
+}
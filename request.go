@@ -0,0 +1,239 @@
+package lazyhttp
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// requestBody encodes the body of a request and returns its content type
+type requestBody func() ([]byte, string, error)
+
+// multipartPart is a field or a file of a multipart body
+type multipartPart struct {
+	field    string
+	fileName string // empty for plain fields
+	value    string
+	content  io.Reader
+}
+
+// requestBuilder builds a request step by step. Errors are returned by Build.
+type requestBuilder struct {
+	ctx        context.Context
+	method     string
+	url        string
+	pathParams map[string]string
+	query      url.Values
+	header     http.Header
+	body       requestBody
+	parts      []multipartPart
+}
+
+// NewRequest returns a builder for a request. The url may be relative, e.g.
+// /users/{id}, the client then resolves it against the host set with
+// WithHost:
+//
+//	req, err := lazyhttp.NewRequest(http.MethodPost, "/users/{id}/posts").
+//		WithContext(ctx).
+//		PathParam("id", "42").
+//		Query("draft", "true").
+//		JSON(post).
+//		Build()
+//
+// The body is encoded once when the request is built and GetBody is set, so
+// the request can be retried.
+func NewRequest(method string, rawURL string) *requestBuilder {
+	return &requestBuilder{
+		ctx:        context.Background(),
+		method:     method,
+		url:        rawURL,
+		pathParams: map[string]string{},
+		query:      url.Values{},
+		header:     http.Header{},
+	}
+}
+
+// WithContext sets the context of the request.
+func (b *requestBuilder) WithContext(ctx context.Context) *requestBuilder {
+	b.ctx = ctx
+	return b
+}
+
+// PathParam replaces the placeholder {name} in the path with the escaped
+// value.
+func (b *requestBuilder) PathParam(name string, value string) *requestBuilder {
+	b.pathParams[name] = value
+	return b
+}
+
+// Query adds values to a query parameter. They are added to the query the
+// url already has.
+func (b *requestBuilder) Query(key string, values ...string) *requestBuilder {
+	for _, v := range values {
+		b.query.Add(key, v)
+	}
+	return b
+}
+
+// Header sets a header. Headers set here take precedence over the content
+// type of the body.
+func (b *requestBuilder) Header(key string, value string) *requestBuilder {
+	b.header.Set(key, value)
+	return b
+}
+
+// Body sets a raw body with its content type.
+func (b *requestBuilder) Body(contentType string, body []byte) *requestBuilder {
+	b.body = func() ([]byte, string, error) {
+		return body, contentType, nil
+	}
+	return b
+}
+
+// JSON sets the JSON encoding of v as the body.
+func (b *requestBuilder) JSON(v any) *requestBuilder {
+	b.body = func() ([]byte, string, error) {
+		data, err := json.Marshal(v)
+		if err != nil {
+			return nil, "", fmt.Errorf("error encoding json body: %w", err)
+		}
+
+		return data, "application/json", nil
+	}
+	return b
+}
+
+// XML sets the XML encoding of v as the body.
+func (b *requestBuilder) XML(v any) *requestBuilder {
+	b.body = func() ([]byte, string, error) {
+		data, err := xml.Marshal(v)
+		if err != nil {
+			return nil, "", fmt.Errorf("error encoding xml body: %w", err)
+		}
+
+		return data, "application/xml", nil
+	}
+	return b
+}
+
+// Form sets the url encoded values as the body.
+func (b *requestBuilder) Form(values url.Values) *requestBuilder {
+	b.body = func() ([]byte, string, error) {
+		return []byte(values.Encode()), "application/x-www-form-urlencoded", nil
+	}
+	return b
+}
+
+// MultipartField adds a field to a multipart/form-data body.
+func (b *requestBuilder) MultipartField(field string, value string) *requestBuilder {
+	b.parts = append(b.parts, multipartPart{field: field, value: value})
+	b.body = b.multipart
+	return b
+}
+
+// MultipartFile adds a file to a multipart/form-data body. The content is read
+// when the request is built.
+func (b *requestBuilder) MultipartFile(field string, fileName string, content io.Reader) *requestBuilder {
+	b.parts = append(b.parts, multipartPart{field: field, fileName: fileName, content: content})
+	b.body = b.multipart
+	return b
+}
+
+// multipart encodes the multipart parts in the order they were added
+func (b *requestBuilder) multipart() ([]byte, string, error) {
+	buf := &bytes.Buffer{}
+	w := multipart.NewWriter(buf)
+
+	for _, p := range b.parts {
+		if p.fileName == "" {
+			err := w.WriteField(p.field, p.value)
+			if err != nil {
+				return nil, "", fmt.Errorf("error writing multipart field %s: %w", p.field, err)
+			}
+			continue
+		}
+
+		fw, err := w.CreateFormFile(p.field, p.fileName)
+		if err != nil {
+			return nil, "", fmt.Errorf("error creating multipart file %s: %w", p.field, err)
+		}
+
+		_, err = io.Copy(fw, p.content)
+		if err != nil {
+			return nil, "", fmt.Errorf("error writing multipart file %s: %w", p.field, err)
+		}
+	}
+
+	err := w.Close()
+	if err != nil {
+		return nil, "", fmt.Errorf("error closing multipart body: %w", err)
+	}
+
+	return buf.Bytes(), w.FormDataContentType(), nil
+}
+
+// Build returns the request. It fails if a path param is missing, the url is
+// invalid or the body can not be encoded.
+func (b *requestBuilder) Build() (*http.Request, error) {
+	rawURL := b.url
+	for name, value := range b.pathParams {
+		rawURL = strings.ReplaceAll(rawURL, "{"+name+"}", url.PathEscape(value))
+	}
+
+	if i := strings.Index(rawURL, "{"); i >= 0 {
+		if j := strings.Index(rawURL[i:], "}"); j >= 0 {
+			return nil, fmt.Errorf("missing path param %s", rawURL[i:i+j+1])
+		}
+	}
+
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing url: %w", err)
+	}
+
+	if len(b.query) > 0 {
+		q := u.Query()
+		for k, values := range b.query {
+			for _, v := range values {
+				q.Add(k, v)
+			}
+		}
+		u.RawQuery = q.Encode()
+	}
+
+	var body io.Reader
+	var contentType string
+	if b.body != nil {
+		data, ct, err := b.body()
+		if err != nil {
+			return nil, err
+		}
+
+		// a bytes.Reader lets NewRequestWithContext set GetBody and the
+		// content length, so the request can be retried
+		body = bytes.NewReader(data)
+		contentType = ct
+	}
+
+	req, err := http.NewRequestWithContext(b.ctx, b.method, u.String(), body)
+	if err != nil {
+		return nil, fmt.Errorf("error creating request: %w", err)
+	}
+
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+
+	for k, values := range b.header {
+		req.Header[k] = append([]string(nil), values...)
+	}
+
+	return req, nil
+}
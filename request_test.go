@@ -0,0 +1,187 @@
+package lazyhttp_test
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/niksteff/lazyhttp"
+)
+
+func TestRequestBuilder(t *testing.T) {
+	req, err := lazyhttp.NewRequest(http.MethodPost, "https://example.com/users/{id}/posts?sort=asc").
+		WithContext(context.Background()).
+		PathParam("id", "a b/c").
+		Query("draft", "true").
+		Query("tag", "go", "http").
+		Header("X-Request-Id", "42").
+		JSON(map[string]string{"title": "hello"}).
+		Build()
+	if err != nil {
+		t.Errorf("did not expect error building request: %+v", err)
+		return
+	}
+
+	if req.Method != http.MethodPost {
+		t.Errorf("expected POST but got: %+v", req.Method)
+	}
+
+	if req.URL.EscapedPath() != "/users/a%20b%2Fc/posts" {
+		t.Errorf("expected escaped path param but got: %+v", req.URL.EscapedPath())
+	}
+
+	q := req.URL.Query()
+	if q.Get("sort") != "asc" || q.Get("draft") != "true" || len(q["tag"]) != 2 {
+		t.Errorf("expected merged query but got: %+v", q)
+	}
+
+	if req.Header.Get("Content-Type") != "application/json" || req.Header.Get("X-Request-Id") != "42" {
+		t.Errorf("expected headers but got: %+v", req.Header)
+	}
+
+	if req.GetBody == nil || req.ContentLength != int64(len(`{"title":"hello"}`)) {
+		t.Errorf("expected GetBody and content length but got: %+v", req.ContentLength)
+	}
+}
+
+func TestRequestBuilderMissingPathParam(t *testing.T) {
+	_, err := lazyhttp.NewRequest(http.MethodGet, "/users/{id}").Build()
+	if err == nil || !strings.Contains(err.Error(), "{id}") {
+		t.Errorf("expected error naming the missing path param but got: %+v", err)
+	}
+}
+
+func TestRequestBuilderBodies(t *testing.T) {
+	type user struct {
+		Name string `xml:"name"`
+	}
+
+	req, err := lazyhttp.NewRequest(http.MethodPost, "/").XML(user{Name: "gopher"}).Build()
+	if err != nil {
+		t.Errorf("did not expect error building xml request: %+v", err)
+		return
+	}
+
+	b, _ := io.ReadAll(req.Body)
+	if req.Header.Get("Content-Type") != "application/xml" || string(b) != "<user><name>gopher</name></user>" {
+		t.Errorf("expected xml body but got: %s", b)
+	}
+
+	req, err = lazyhttp.NewRequest(http.MethodPost, "/").Form(url.Values{"a": []string{"1"}}).Build()
+	if err != nil {
+		t.Errorf("did not expect error building form request: %+v", err)
+		return
+	}
+
+	b, _ = io.ReadAll(req.Body)
+	if req.Header.Get("Content-Type") != "application/x-www-form-urlencoded" || string(b) != "a=1" {
+		t.Errorf("expected form body but got: %s", b)
+	}
+
+	req, err = lazyhttp.NewRequest(http.MethodPost, "/").
+		Header("Content-Type", "text/csv").
+		Body("text/plain", []byte("a,b")).
+		Build()
+	if err != nil {
+		t.Errorf("did not expect error building raw request: %+v", err)
+		return
+	}
+
+	if req.Header.Get("Content-Type") != "text/csv" {
+		t.Errorf("expected explicit header to win but got: %+v", req.Header.Get("Content-Type"))
+	}
+}
+
+func TestRequestBuilderMultipart(t *testing.T) {
+	req, err := lazyhttp.NewRequest(http.MethodPost, "/upload").
+		MultipartField("name", "gopher").
+		MultipartFile("avatar", "gopher.png", strings.NewReader("png")).
+		Build()
+	if err != nil {
+		t.Errorf("did not expect error building multipart request: %+v", err)
+		return
+	}
+
+	err = req.ParseMultipartForm(1 << 20)
+	if err != nil {
+		t.Errorf("did not expect error parsing multipart form: %+v", err)
+		return
+	}
+
+	if req.FormValue("name") != "gopher" {
+		t.Errorf("expected name field but got: %+v", req.MultipartForm.Value)
+	}
+
+	files := req.MultipartForm.File["avatar"]
+	if len(files) != 1 || files[0].Filename != "gopher.png" {
+		t.Errorf("expected avatar file but got: %+v", req.MultipartForm.File)
+	}
+}
+
+func TestRequestBuilderWithClient(t *testing.T) {
+	var mtx sync.Mutex
+	var bodies []string
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+
+		mtx.Lock()
+		bodies = append(bodies, r.URL.Path+" "+string(b))
+		n := len(bodies)
+		mtx.Unlock()
+
+		if n == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]string{"id": "1"})
+	}))
+	defer srv.Close()
+
+	host, _ := url.Parse(srv.URL)
+	client := lazyhttp.New(
+		lazyhttp.WithHost(host),
+		lazyhttp.WithRetryPolicy(retryOnUnavailable),
+		lazyhttp.WithBackoffPolicy(func() lazyhttp.Backoff {
+			return lazyhttp.NewLimitedTriesBackoff(time.Millisecond, 3)
+		}),
+	)
+
+	req, err := lazyhttp.NewRequest(http.MethodPost, "/users/{id}").
+		PathParam("id", "42").
+		JSON(map[string]string{"name": "gopher"}).
+		Build()
+	if err != nil {
+		t.Errorf("did not expect error building request: %+v", err)
+		return
+	}
+
+	res, err := client.Do(req)
+	if err != nil {
+		t.Errorf("did not expect error doing request: %+v", err)
+		return
+	}
+
+	var out map[string]string
+	err = lazyhttp.Decode(res, &out)
+	if err != nil || out["id"] != "1" {
+		t.Errorf("expected decoded response but got: %+v, %+v", out, err)
+	}
+
+	mtx.Lock()
+	defer mtx.Unlock()
+
+	want := `/users/42 {"name":"gopher"}`
+	if len(bodies) != 2 || bodies[0] != want || bodies[1] != want {
+		t.Errorf("expected the body to be sent twice but got: %+v", bodies)
+	}
+}
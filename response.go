@@ -1,7 +1,6 @@
 package lazyhttp
 
 import (
-	"encoding/json"
 	"fmt"
 	"io"
 )
@@ -15,13 +14,13 @@ func NoopBodyCloser(rc io.ReadCloser) {
 }
 
 // DecodeBytes reads from the given reader and returns the content as a []byte.
-// To limit the number of bytes read, use the io.LimitReader type to wrap the
-// reader.
-func DecodeBytes(rc io.ReadCloser) ([]byte, error) {
+// To limit the number of bytes read, pass WithMaxBytes. A longer body fails
+// with ErrBodyTooLarge.
+func DecodeBytes(rc io.ReadCloser, opts ...DecodeOption) ([]byte, error) {
 	// always close reader after reading
 	defer rc.Close()
 
-	b, err := io.ReadAll(rc)
+	b, err := io.ReadAll(newDecodeOptions(opts).reader(rc))
 	if err != nil {
 		return []byte{}, fmt.Errorf("error reading response body: %w", err)
 	}
@@ -30,20 +29,23 @@ func DecodeBytes(rc io.ReadCloser) ([]byte, error) {
 }
 
 // DecodeJson reads from the given reader and unmarshals the content into the given
-// pointer. The reader is closed after reading. This function does not limit the
-// number of bytes read from the reader. To limit the number of bytes read, use
-// the io.LimitReader type to wrap the reader.
-func DecodeJson(rc io.ReadCloser, out any) error {
+// pointer. The reader is closed after reading. By default this function does not
+// limit the number of bytes read from the reader. To limit the number of bytes
+// read, pass WithMaxBytes. A longer body fails with ErrBodyTooLarge instead of
+// being truncated. Data after the JSON value is always rejected.
+func DecodeJson(rc io.ReadCloser, out any, opts ...DecodeOption) error {
 	// always close reader after reading
 	defer rc.Close()
 
+	o := newDecodeOptions(opts)
+
 	// read all from the given reader
-	b, err := io.ReadAll(rc)
+	b, err := io.ReadAll(o.reader(rc))
 	if err != nil {
 		return fmt.Errorf("error reading response body: %w", err)
 	}
 
-	err = json.Unmarshal(b, out)
+	err = o.unmarshal(b, out)
 	if err != nil {
 		return fmt.Errorf("error unmarshaling response body: %w", err)
 	}
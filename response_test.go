@@ -3,12 +3,15 @@ package lazyhttp_test
 import (
 	"bytes"
 	"context"
+	"encoding/json"
+	"errors"
 	"io"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/niksteff/lazyhttp"
 )
@@ -130,3 +133,185 @@ func TestDecodeJsonFromResponse(t *testing.T) {
 		return
 	}
 }
+
+func TestDecodeJsonMaxBytes(t *testing.T) {
+	d := `{"foo": "bar"}`
+
+	type res struct {
+		Foo string `json:"foo"`
+	}
+
+	var tmp res
+	err := lazyhttp.DecodeJson(io.NopCloser(strings.NewReader(d)), &tmp, lazyhttp.WithMaxBytes(int64(len(d))))
+	if err != nil || tmp.Foo != "bar" {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	err = lazyhttp.DecodeJson(io.NopCloser(strings.NewReader(d)), &tmp, lazyhttp.WithMaxBytes(int64(len(d)-1)))
+	if !errors.Is(err, lazyhttp.ErrBodyTooLarge) {
+		t.Errorf("expected ErrBodyTooLarge but got: %v", err)
+	}
+
+	_, err = lazyhttp.DecodeBytes(io.NopCloser(strings.NewReader(d)), lazyhttp.WithMaxBytes(1*B))
+	if !errors.Is(err, lazyhttp.ErrBodyTooLarge) {
+		t.Errorf("expected ErrBodyTooLarge but got: %v", err)
+	}
+}
+
+func TestDecodeJsonStrict(t *testing.T) {
+	type res struct {
+		Foo string `json:"foo"`
+	}
+
+	var tmp res
+	err := lazyhttp.DecodeJson(io.NopCloser(strings.NewReader(`{"foo": "bar", "baz": 1}`)), &tmp)
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	err = lazyhttp.DecodeJson(io.NopCloser(strings.NewReader(`{"foo": "bar", "baz": 1}`)), &tmp, lazyhttp.WithDisallowUnknownFields())
+	if err == nil || !strings.Contains(err.Error(), "baz") {
+		t.Errorf("expected unknown field error but got: %v", err)
+	}
+
+	// trailing data is rejected with and without options
+	err = lazyhttp.DecodeJson(io.NopCloser(strings.NewReader(`{"foo": "bar"} {}`)), &tmp)
+	if err == nil {
+		t.Errorf("expected trailing data error")
+	}
+
+	err = lazyhttp.DecodeJson(io.NopCloser(strings.NewReader(`{"foo": "bar"} {}`)), &tmp, lazyhttp.WithDisallowUnknownFields())
+	if err == nil {
+		t.Errorf("expected trailing data error")
+	}
+
+	var m map[string]any
+	err = lazyhttp.DecodeJson(io.NopCloser(strings.NewReader(`{"id": 9007199254740993}`)), &m, lazyhttp.WithUseNumber())
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+		return
+	}
+
+	if n, ok := m["id"].(json.Number); !ok || n.String() != "9007199254740993" {
+		t.Errorf("expected json.Number but got: %#v", m["id"])
+	}
+}
+
+func TestMaxResponseSize(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"foo": "` + strings.Repeat("a", 2*KB) + `"}`))
+	}))
+	defer srv.Close()
+
+	addr, err := url.Parse(srv.URL)
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+		return
+	}
+
+	var hookErr error
+	c := lazyhttp.New(
+		lazyhttp.WithHost(addr),
+		lazyhttp.WithMaxResponseSize(1*KB),
+		lazyhttp.WithPostResponseHooks(func(res *http.Response) error {
+			// the hook sees the limited body
+			b := make([]byte, 2*KB)
+			_, hookErr = io.ReadFull(res.Body, b)
+			return nil
+		}),
+	)
+
+	req, err := http.NewRequestWithContext(context.Background(), http.MethodGet, "/", nil)
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+		return
+	}
+
+	res, err := c.Do(req)
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+		return
+	}
+	defer res.Body.Close()
+
+	if !errors.Is(hookErr, lazyhttp.ErrBodyTooLarge) {
+		t.Errorf("expected ErrBodyTooLarge in the hook but got: %v", hookErr)
+	}
+
+	res, err = c.Do(req.Clone(context.Background()))
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+		return
+	}
+
+	var out map[string]string
+	err = lazyhttp.Decode(res, &out)
+	if !errors.Is(err, lazyhttp.ErrBodyTooLarge) {
+		t.Errorf("expected ErrBodyTooLarge but got: %v", err)
+	}
+}
+
+func TestMaxResponseSizeEveryAttempt(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		_, _ = w.Write([]byte(strings.Repeat("a", 2*KB)))
+	}))
+	defer srv.Close()
+
+	addr, err := url.Parse(srv.URL)
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+		return
+	}
+
+	c := lazyhttp.New(
+		lazyhttp.WithHost(addr),
+		lazyhttp.WithMaxResponseSize(1*KB),
+		lazyhttp.WithRetryPolicy(func(res *http.Response) bool {
+			return res.StatusCode == http.StatusServiceUnavailable
+		}),
+		lazyhttp.WithBackoffPolicy(func() lazyhttp.Backoff {
+			return lazyhttp.NewLimitedTriesBackoff(time.Millisecond, 1)
+		}),
+	)
+
+	req, err := http.NewRequestWithContext(context.Background(), http.MethodGet, "/", nil)
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+		return
+	}
+
+	// the response handed out when the retries are exhausted is limited
+	res, err := c.Do(req)
+
+	var exhausted lazyhttp.RetryExhaustedError
+	if !errors.As(err, &exhausted) {
+		t.Errorf("expected RetryExhaustedError but got: %v", err)
+		return
+	}
+
+	_, err = lazyhttp.DecodeBytes(res.Body)
+	if !errors.Is(err, lazyhttp.ErrBodyTooLarge) {
+		t.Errorf("expected ErrBodyTooLarge but got: %v", err)
+	}
+
+	// the start of the body kept in a status error is limited
+	c = lazyhttp.New(
+		lazyhttp.WithHost(addr),
+		lazyhttp.WithMaxResponseSize(1*KB),
+		lazyhttp.WithStatusErrors(lazyhttp.StatusErrorConfig{MaxBodySize: 4 * KB}),
+	)
+
+	_, err = c.Do(req.Clone(context.Background()))
+
+	var statusErr lazyhttp.StatusError
+	if !errors.As(err, &statusErr) {
+		t.Errorf("expected StatusError but got: %v", err)
+		return
+	}
+
+	if len(statusErr.Body) != 1*KB || !errors.Is(statusErr.BodyErr, lazyhttp.ErrBodyTooLarge) {
+		t.Errorf("expected 1KB of the body and ErrBodyTooLarge but got %d bytes: %v", len(statusErr.Body), statusErr.BodyErr)
+	}
+}
@@ -1,8 +1,240 @@
 package lazyhttp
 
-import "net/http"
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"runtime"
+	"sync"
+	"time"
+)
+
+// maxDrainBytes is the maximum number of bytes read from a discarded response
+// body so its connection can be reused. Larger bodies are closed without
+// reading them to the end.
+const maxDrainBytes = 64 << 10
 
 // NoopRetryHook is a retry hook that never retries
 func NoopRetryHook(resp *http.Response) bool {
 	return false
 }
+
+// Attempt describes a single attempt of a request.
+type Attempt struct {
+	StatusCode int           // the status code of the response, 0 if the attempt failed
+	Err        error         // the error of the attempt if it failed
+	Duration   time.Duration // the time until the response headers were received
+	Delay      time.Duration // the backoff slept before the attempt
+	Retried    bool          // whether the retry policy decided to retry after this attempt
+	Policy     string        // the name of the retry policy that decided to retry
+}
+
+// attemptHistory records the attempts of a request
+type attemptHistory struct {
+	mtx  sync.Mutex
+	list []Attempt
+}
+
+func (h *attemptHistory) add(a Attempt) {
+	h.mtx.Lock()
+	defer h.mtx.Unlock()
+
+	h.list = append(h.list, a)
+}
+
+// retried marks the last attempt as retried by the given policy
+func (h *attemptHistory) retried(policy string) {
+	h.mtx.Lock()
+	defer h.mtx.Unlock()
+
+	if len(h.list) == 0 {
+		return
+	}
+
+	h.list[len(h.list)-1].Retried = true
+	h.list[len(h.list)-1].Policy = policy
+}
+
+// attempts returns a copy of the recorded attempts
+func (h *attemptHistory) attempts() []Attempt {
+	h.mtx.Lock()
+	defer h.mtx.Unlock()
+
+	return append([]Attempt(nil), h.list...)
+}
+
+type attemptHistoryKey struct{}
+
+// WithAttemptHistory returns a context that records the attempts of requests
+// made with it. The attempts can be read with AttemptsFromContext once Do
+// returned, also for successful requests:
+//
+//	ctx := lazyhttp.WithAttemptHistory(context.Background())
+//	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, "/", nil)
+//	res, err := client.Do(req)
+//	attempts := lazyhttp.AttemptsFromContext(ctx)
+//
+// The attempts of all requests made with the context are recorded, so use a
+// new context per request.
+func WithAttemptHistory(ctx context.Context) context.Context {
+	return context.WithValue(ctx, attemptHistoryKey{}, &attemptHistory{})
+}
+
+// AttemptsFromContext returns the attempts recorded in a context created with
+// WithAttemptHistory. It returns nil for other contexts.
+func AttemptsFromContext(ctx context.Context) []Attempt {
+	h := attemptHistoryFromContext(ctx)
+	if h == nil {
+		return nil
+	}
+
+	return h.attempts()
+}
+
+func attemptHistoryFromContext(ctx context.Context) *attemptHistory {
+	h, _ := ctx.Value(attemptHistoryKey{}).(*attemptHistory)
+	return h
+}
+
+// recordAttempt executes an attempt and adds it to the history.
+func (c *client) recordAttempt(req *http.Request, permit *circuitPermit, history *attemptHistory, delay time.Duration) (*http.Response, error) {
+	start := time.Now()
+	res, err := c.attempt(req, permit)
+
+	a := Attempt{
+		Err:      err,
+		Duration: time.Since(start),
+		Delay:    delay,
+	}
+	if res != nil {
+		a.StatusCode = res.StatusCode
+	}
+	history.add(a)
+
+	return res, err
+}
+
+// funcName returns the name of a func, e.g. main.retryOnUnavailable
+func funcName(f interface{}) string {
+	v := reflect.ValueOf(f)
+	if v.Kind() != reflect.Func || v.IsNil() {
+		return ""
+	}
+
+	fn := runtime.FuncForPC(v.Pointer())
+	if fn == nil {
+		return ""
+	}
+
+	return fn.Name()
+}
+
+// retry repeats the request as long as the retry policy wants to and the
+// backoff allows it. Every discarded response is drained and closed before
+// backing off. A request with a body that can not be replayed is not retried,
+// its response is returned without an error. If the backoff gives up, the last
+// response is returned with a RetryExhaustedError and its body has to be
+// closed by the caller.
+func (c *client) retry(req *http.Request, res *http.Response, history *attemptHistory) (*http.Response, error) {
+	ctx := req.Context()
+
+	// create a new backoff instance for this request
+	bop := c.newBackoffPolicy()
+
+	for c.retryPolicy(res) {
+		// a body that can not be replayed, e.g. a streamed one, can not be
+		// sent again, so the response is returned as it is
+		if !canRewindBody(req) {
+			return res, nil
+		}
+
+		history.retried(c.retryPolicyName)
+
+		// want to perform a retry so check the backoff implementation if a
+		// retry is still possible
+		t, ok := bop.Backoff()
+		if !ok {
+			return res, RetryExhaustedError{
+				Attempts: history.attempts(),
+				Response: res,
+			}
+		}
+
+		// the response is discarded, free its connection and bulkhead slot
+		// while backing off
+		discardBody(res.Body)
+
+		// we are using a timer so we are able to concurrently listen on the
+		// context and the timer. This is not possible with a sleep.
+		timer := time.NewTimer(t)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, BackoffError{
+				Err:      fmt.Errorf("error waiting for retry: %w", ctx.Err()),
+				Attempts: history.attempts(),
+			}
+		case <-timer.C:
+		}
+
+		err := rewindBody(req)
+		if err != nil {
+			return nil, RequestError{
+				Err:     fmt.Errorf("error rewinding request body: %w", err),
+				Request: req,
+			}
+		}
+
+		// now execute the request without all prior hooks etc. because we
+		// already did that
+		res, err = c.recordAttempt(req, nil, history, t)
+		if err != nil {
+			// the attempt did not produce a response the retry policy could
+			// look at, e.g. because the circuit opened meanwhile
+			return nil, BackoffError{
+				Err:      err,
+				Attempts: history.attempts(),
+			}
+		}
+	}
+
+	return res, nil
+}
+
+// canRewindBody reports whether the body of the request can be sent again.
+func canRewindBody(req *http.Request) bool {
+	return req.Body == nil || req.Body == http.NoBody || req.GetBody != nil
+}
+
+// rewindBody replaces the consumed body of the request with a fresh copy, so
+// it can be sent again.
+func rewindBody(req *http.Request) error {
+	if req.Body == nil || req.Body == http.NoBody {
+		return nil
+	}
+
+	if req.GetBody == nil {
+		return errors.New("request has a body but no GetBody func")
+	}
+
+	body, err := req.GetBody()
+	if err != nil {
+		return err
+	}
+	req.Body = body
+
+	return nil
+}
+
+// discardBody reads the rest of a response body up to a limit and closes it.
+func discardBody(rc io.ReadCloser) {
+	if rc == nil {
+		return
+	}
+	defer rc.Close()
+
+	_, _ = io.CopyN(io.Discard, rc, maxDrainBytes)
+}
@@ -0,0 +1,382 @@
+package lazyhttp_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/niksteff/lazyhttp"
+)
+
+// closeTracker counts the response bodies that were opened and closed
+type closeTracker struct {
+	mtx    sync.Mutex
+	opened int
+	closed int
+}
+
+type trackedBody struct {
+	io.ReadCloser
+	tracker *closeTracker
+}
+
+func (b *trackedBody) Close() error {
+	b.tracker.mtx.Lock()
+	b.tracker.closed++
+	b.tracker.mtx.Unlock()
+
+	return b.ReadCloser.Close()
+}
+
+type trackingTransport struct {
+	tracker *closeTracker
+}
+
+func (tr *trackingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	res, err := http.DefaultTransport.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+
+	tr.tracker.mtx.Lock()
+	tr.tracker.opened++
+	tr.tracker.mtx.Unlock()
+
+	res.Body = &trackedBody{ReadCloser: res.Body, tracker: tr.tracker}
+	return res, nil
+}
+
+func TestOperationTimeoutCoversRetries(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		_, _ = w.Write([]byte("try again"))
+	}))
+	defer srv.Close()
+
+	tracker := &closeTracker{}
+	client := lazyhttp.New(
+		lazyhttp.WithHttpClient(&http.Client{Transport: &trackingTransport{tracker: tracker}}),
+		lazyhttp.WithRetryPolicy(func(res *http.Response) bool {
+			return res.StatusCode == http.StatusServiceUnavailable
+		}),
+		lazyhttp.WithBackoffPolicy(func() lazyhttp.Backoff {
+			return lazyhttp.NewConstantBackoff(20 * time.Millisecond)
+		}),
+		lazyhttp.WithOperationTimeout(100*time.Millisecond),
+	)
+
+	req, err := http.NewRequestWithContext(context.Background(), http.MethodGet, srv.URL, nil)
+	if err != nil {
+		t.Errorf("did not expect error creating request: %+v", err)
+		return
+	}
+
+	start := time.Now()
+	_, err = client.Do(req)
+
+	var backoffErr lazyhttp.BackoffError
+	if !errors.As(err, &backoffErr) || !errors.Is(backoffErr.Err, context.DeadlineExceeded) {
+		t.Errorf("expected BackoffError with deadline exceeded but got: %+v", err)
+	}
+
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("expected the operation to end after 100ms but took: %s", elapsed)
+	}
+
+	tracker.mtx.Lock()
+	defer tracker.mtx.Unlock()
+
+	if tracker.opened < 2 || tracker.opened != tracker.closed {
+		t.Errorf("expected all of the %d bodies to be closed but closed: %d", tracker.opened, tracker.closed)
+	}
+}
+
+func TestOperationTimeoutAllowsReadingTheBody(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write([]byte("hello"))
+	}))
+	defer srv.Close()
+
+	client := lazyhttp.New(
+		lazyhttp.WithOperationTimeout(time.Second),
+		lazyhttp.WithAttemptTimeout(time.Second),
+	)
+
+	req, err := http.NewRequestWithContext(context.Background(), http.MethodGet, srv.URL, nil)
+	if err != nil {
+		t.Errorf("did not expect error creating request: %+v", err)
+		return
+	}
+
+	res, err := client.Do(req)
+	if err != nil {
+		t.Errorf("did not expect error making request: %+v", err)
+		return
+	}
+
+	b, err := lazyhttp.DecodeBytes(res.Body)
+	if err != nil {
+		t.Errorf("did not expect error reading body: %+v", err)
+		return
+	}
+
+	if string(b) != "hello" {
+		t.Errorf("expected body hello but got: %s", b)
+	}
+}
+
+func TestAttemptTimeout(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(time.Second):
+		}
+	}))
+	defer srv.Close()
+
+	client := lazyhttp.New(
+		lazyhttp.WithAttemptTimeout(20 * time.Millisecond),
+	)
+
+	req, err := http.NewRequestWithContext(context.Background(), http.MethodGet, srv.URL, nil)
+	if err != nil {
+		t.Errorf("did not expect error creating request: %+v", err)
+		return
+	}
+
+	_, err = client.Do(req)
+
+	var transportErr lazyhttp.TransportError
+	if !errors.As(err, &transportErr) || !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected deadline exceeded but got: %+v", err)
+	}
+}
+
+func TestRetryRewindsBody(t *testing.T) {
+	var mtx sync.Mutex
+	var bodies []string
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+
+		mtx.Lock()
+		bodies = append(bodies, string(b))
+		n := len(bodies)
+		mtx.Unlock()
+
+		if n < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+
+	client := lazyhttp.New(
+		lazyhttp.WithRetryPolicy(func(res *http.Response) bool {
+			return res.StatusCode == http.StatusServiceUnavailable
+		}),
+		lazyhttp.WithBackoffPolicy(func() lazyhttp.Backoff {
+			return lazyhttp.NewConstantBackoff(time.Millisecond)
+		}),
+	)
+
+	req, err := http.NewRequestWithContext(context.Background(), http.MethodPost, srv.URL, strings.NewReader("payload"))
+	if err != nil {
+		t.Errorf("did not expect error creating request: %+v", err)
+		return
+	}
+
+	res, err := client.Do(req)
+	if err != nil {
+		t.Errorf("did not expect error making request: %+v", err)
+		return
+	}
+	lazyhttp.NoopBodyCloser(res.Body)
+
+	mtx.Lock()
+	defer mtx.Unlock()
+
+	for i, b := range bodies {
+		if b != "payload" {
+			t.Errorf("expected attempt %d to send the payload but got: %q", i, b)
+		}
+	}
+}
+
+func retryOnUnavailable(res *http.Response) bool {
+	return res.StatusCode == http.StatusServiceUnavailable
+}
+
+func TestAttemptHistory(t *testing.T) {
+	var mtx sync.Mutex
+	calls := 0
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mtx.Lock()
+		calls++
+		n := calls
+		mtx.Unlock()
+
+		if n < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+
+	client := lazyhttp.New(
+		lazyhttp.WithRetryPolicy(retryOnUnavailable),
+		lazyhttp.WithBackoffPolicy(func() lazyhttp.Backoff {
+			return lazyhttp.NewConstantBackoff(5 * time.Millisecond)
+		}),
+	)
+
+	ctx := lazyhttp.WithAttemptHistory(context.Background())
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL, nil)
+	if err != nil {
+		t.Errorf("did not expect error creating request: %+v", err)
+		return
+	}
+
+	res, err := client.Do(req)
+	if err != nil {
+		t.Errorf("did not expect error making request: %+v", err)
+		return
+	}
+	lazyhttp.NoopBodyCloser(res.Body)
+
+	attempts := lazyhttp.AttemptsFromContext(ctx)
+	if len(attempts) != 3 {
+		t.Errorf("expected 3 attempts but got: %d", len(attempts))
+		return
+	}
+
+	for i, status := range []int{http.StatusServiceUnavailable, http.StatusServiceUnavailable, http.StatusOK} {
+		if attempts[i].StatusCode != status {
+			t.Errorf("expected attempt %d to have status %d but got: %d", i, status, attempts[i].StatusCode)
+		}
+
+		retried := i < 2
+		if attempts[i].Retried != retried {
+			t.Errorf("expected attempt %d retried to be %t", i, retried)
+		}
+
+		if retried && !strings.HasSuffix(attempts[i].Policy, "retryOnUnavailable") {
+			t.Errorf("expected attempt %d to be retried by retryOnUnavailable but got: %s", i, attempts[i].Policy)
+		}
+	}
+
+	if attempts[0].Delay != 0 || attempts[1].Delay != 5*time.Millisecond {
+		t.Errorf("expected the backoff to be recorded as delay but got: %s and %s", attempts[0].Delay, attempts[1].Delay)
+	}
+}
+
+func TestAttemptHistoryOnError(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+
+	client := lazyhttp.New(
+		lazyhttp.WithRetryPolicy(retryOnUnavailable),
+		lazyhttp.WithBackoffPolicy(func() lazyhttp.Backoff {
+			return lazyhttp.NewLimitedTriesBackoff(time.Millisecond, 2)
+		}),
+	)
+
+	req, err := http.NewRequestWithContext(context.Background(), http.MethodGet, srv.URL, nil)
+	if err != nil {
+		t.Errorf("did not expect error creating request: %+v", err)
+		return
+	}
+
+	res, err := client.Do(req)
+
+	var retryErr lazyhttp.RetryExhaustedError
+	if !errors.As(err, &retryErr) {
+		t.Errorf("expected RetryExhaustedError but got: %+v", err)
+		return
+	}
+	lazyhttp.NoopBodyCloser(res.Body)
+
+	if !errors.Is(err, lazyhttp.ErrMaxRetriesReached) {
+		t.Errorf("expected max retries reached but got: %+v", err)
+	}
+
+	if len(retryErr.Attempts) < 2 {
+		t.Errorf("expected the attempts on the error but got: %+v", retryErr.Attempts)
+	}
+
+	for i, a := range retryErr.Attempts {
+		if a.StatusCode != http.StatusServiceUnavailable || !a.Retried {
+			t.Errorf("expected attempt %d to be a retried 503 but got: %+v", i, a)
+		}
+	}
+}
+
+func TestRetrySkipsBodyWithoutGetBody(t *testing.T) {
+	var mtx sync.Mutex
+	calls := 0
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.Copy(io.Discard, r.Body)
+
+		mtx.Lock()
+		calls++
+		mtx.Unlock()
+
+		w.WriteHeader(http.StatusServiceUnavailable)
+		_, _ = w.Write([]byte("try again"))
+	}))
+	defer srv.Close()
+
+	client := lazyhttp.New(
+		lazyhttp.WithRetryPolicy(retryOnUnavailable),
+		lazyhttp.WithBackoffPolicy(func() lazyhttp.Backoff {
+			return lazyhttp.NewConstantBackoff(time.Second)
+		}),
+	)
+
+	values := make(chan int, 1)
+	values <- 1
+	close(values)
+
+	// a streamed body can not be sent again
+	req, err := http.NewRequestWithContext(context.Background(), http.MethodPost, srv.URL, lazyhttp.NewNDJSONBody(context.Background(), values))
+	if err != nil {
+		t.Errorf("did not expect error creating request: %+v", err)
+		return
+	}
+
+	start := time.Now()
+	res, err := client.Do(req)
+	if err != nil {
+		t.Errorf("did not expect error: %+v", err)
+		return
+	}
+
+	b, err := lazyhttp.DecodeBytes(res.Body)
+	if err != nil || res.StatusCode != http.StatusServiceUnavailable || string(b) != "try again" {
+		t.Errorf("expected the first response but got %d: %s", res.StatusCode, b)
+	}
+
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("expected no backoff but took: %s", elapsed)
+	}
+
+	mtx.Lock()
+	defer mtx.Unlock()
+
+	if calls != 1 {
+		t.Errorf("expected a single call but got: %d", calls)
+	}
+}
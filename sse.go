@@ -0,0 +1,333 @@
+package lazyhttp
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// defaultRetry is the reconnection time of an event stream until the server
+// sends one.
+const defaultRetry = 3 * time.Second
+
+// Event is a server-sent event.
+type Event struct {
+	ID    string        // the last event id of the stream when the event was dispatched
+	Event string        // the event type, defaults to message
+	Data  string        // the data lines of the event joined by newlines
+	Retry time.Duration // the reconnection time the server sent with the event, 0 if none
+}
+
+// SSEConfig configures an event stream.
+type SSEConfig struct {
+	Backoff     func(retry time.Duration) Backoff // creates the backoff for reconnecting after a connection was established, retry is the reconnection time sent by the server, defaults to NewConstantBackoff(retry)
+	Buffer      int                               // size of the events channel buffer, defaults to 0
+	MaxLineSize int                               // max bytes of a single line, defaults to 1MiB
+}
+
+// eventStream receives server-sent events and reconnects if the connection
+// is lost.
+type eventStream struct {
+	d    Doer
+	req  *http.Request
+	conf SSEConfig
+
+	events chan Event
+	mtx    *sync.Mutex
+	err    error
+
+	lastEventID string
+	retry       time.Duration
+}
+
+// Subscribe connects to a text/event-stream endpoint and delivers its events
+// over a channel:
+//
+//	req, _ := http.NewRequest(http.MethodGet, "/events", nil)
+//	stream := lazyhttp.Subscribe(ctx, client, req, lazyhttp.SSEConfig{})
+//
+//	for event := range stream.Events() {
+//		...
+//	}
+//	if err := stream.Err(); err != nil {
+//		...
+//	}
+//
+// The stream parses events as defined by the WHATWG HTML standard. If the
+// connection is lost, it reconnects with the Last-Event-ID header after the
+// delay of the backoff. The stream ends once the context is done, the server
+// answers with 204 No Content, an error status or another content type, a
+// request fails with an error that is not temporary, see IsTemporary, or the
+// backoff gives up. The request is cloned for every connection. Note that
+// an operation timeout of the client ends every connection after it.
+func Subscribe(ctx context.Context, d Doer, req *http.Request, conf SSEConfig) *eventStream {
+	if conf.Backoff == nil {
+		conf.Backoff = func(retry time.Duration) Backoff {
+			return NewConstantBackoff(retry)
+		}
+	}
+
+	if conf.MaxLineSize <= 0 {
+		conf.MaxLineSize = 1 << 20
+	}
+
+	s := &eventStream{
+		d:      d,
+		req:    req,
+		conf:   conf,
+		events: make(chan Event, conf.Buffer),
+		mtx:    &sync.Mutex{},
+		retry:  defaultRetry,
+	}
+
+	go s.run(ctx)
+
+	return s
+}
+
+// Events returns the channel of events. It is closed once the stream ended.
+func (s *eventStream) Events() <-chan Event {
+	return s.events
+}
+
+// Err returns the error that ended the stream. It is nil if the server ended
+// the stream with 204 No Content. Call it after the events channel was
+// closed.
+func (s *eventStream) Err() error {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	return s.err
+}
+
+// run connects and reconnects until the stream ends.
+func (s *eventStream) run(ctx context.Context) {
+	defer close(s.events)
+
+	var bop Backoff
+	for {
+		connected, err := s.connect(ctx)
+		if errors.Is(err, errStreamEnded) {
+			return
+		}
+
+		if ctx.Err() != nil {
+			s.fail(ctx.Err())
+			return
+		}
+
+		var statusErr StatusError
+		if errors.As(err, &statusErr) || errors.Is(err, errNotEventStream) {
+			s.fail(err)
+			return
+		}
+
+		// a lost connection is always reestablished, but a request that
+		// failed permanently, e.g. because the client was closed or a hook
+		// failed, would fail again
+		if !connected && (errors.Is(err, ErrClientClosed) || !IsTemporary(err)) {
+			s.fail(err)
+			return
+		}
+
+		// start over with a fresh backoff once a connection was established,
+		// so it uses the latest reconnection time of the server
+		if connected || bop == nil {
+			bop = s.conf.Backoff(s.retry)
+		}
+
+		t, ok := bop.Backoff()
+		if !ok {
+			s.fail(BackoffError{
+				Err: fmt.Errorf("error reconnecting event stream: %w", err),
+			})
+			return
+		}
+
+		timer := time.NewTimer(t)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			s.fail(ctx.Err())
+			return
+		case <-timer.C:
+		}
+	}
+}
+
+func (s *eventStream) fail(err error) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	s.err = err
+}
+
+var (
+	errStreamEnded    = fmt.Errorf("event stream ended by the server")
+	errNotEventStream = fmt.Errorf("response is not an event stream")
+)
+
+// connect opens a connection and reads events until it is lost. It reports
+// whether a connection was established.
+func (s *eventStream) connect(ctx context.Context) (bool, error) {
+	req := s.req.Clone(ctx)
+	req.Header.Set("Accept", "text/event-stream")
+	req.Header.Set("Cache-Control", "no-cache")
+	if s.lastEventID != "" {
+		req.Header.Set("Last-Event-ID", s.lastEventID)
+	}
+
+	res, err := s.d.Do(req)
+	if err != nil {
+		if res != nil && res.Body != nil {
+			discardBody(res.Body)
+		}
+
+		return false, err
+	}
+	defer discardBody(res.Body)
+
+	if res.StatusCode == http.StatusNoContent {
+		return false, errStreamEnded
+	}
+
+	if res.StatusCode != http.StatusOK {
+		statusErr := newStatusError(res)
+		statusErr.Header = res.Header
+		return false, statusErr
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(res.Header.Get("Content-Type"))
+	if mediaType != "text/event-stream" {
+		return false, fmt.Errorf("%w: %s", errNotEventStream, mediaType)
+	}
+
+	return true, s.read(ctx, res.Body)
+}
+
+// read parses the events of the body and delivers them. It returns the error
+// that ended the connection, io.EOF if the server closed it.
+func (s *eventStream) read(ctx context.Context, body io.Reader) error {
+	scanner := bufio.NewScanner(body)
+	scanner.Buffer(make([]byte, 0, min(64<<10, s.conf.MaxLineSize)), s.conf.MaxLineSize)
+	scanner.Split(scanEventLines)
+
+	var data strings.Builder
+	var eventType string
+	var retry time.Duration
+	first := true
+
+	// the id of an event only becomes the last event id once the event is
+	// dispatched, so an event cut off by a lost connection is sent again
+	id := s.lastEventID
+
+	for scanner.Scan() {
+		line := scanner.Text()
+		if first {
+			line = strings.TrimPrefix(line, "\uFEFF")
+			first = false
+		}
+
+		// an empty line dispatches the event
+		if line == "" {
+			s.lastEventID = id
+			if data.Len() > 0 {
+				event := Event{
+					ID:    s.lastEventID,
+					Event: eventType,
+					Data:  strings.TrimSuffix(data.String(), "\n"),
+					Retry: retry,
+				}
+				if event.Event == "" {
+					event.Event = "message"
+				}
+
+				select {
+				case s.events <- event:
+				case <-ctx.Done():
+					return ctx.Err()
+				}
+			}
+
+			data.Reset()
+			eventType = ""
+			retry = 0
+			continue
+		}
+
+		// comments are used as keep alive
+		if strings.HasPrefix(line, ":") {
+			continue
+		}
+
+		field, value, _ := strings.Cut(line, ":")
+		value = strings.TrimPrefix(value, " ")
+
+		switch field {
+		case "event":
+			eventType = value
+		case "data":
+			data.WriteString(value)
+			data.WriteByte('\n')
+		case "id":
+			if !strings.ContainsRune(value, 0) {
+				id = value
+			}
+		case "retry":
+			// only ascii digits are valid
+			ms, err := strconv.ParseUint(value, 10, 63)
+			if err == nil {
+				retry = time.Duration(ms) * time.Millisecond
+				s.retry = retry
+			}
+		}
+	}
+
+	// a pending event is discarded once the connection is lost
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	return io.EOF
+}
+
+// scanEventLines splits lines ended by CRLF, LF or CR. An unterminated last
+// line is dropped.
+func scanEventLines(data []byte, atEOF bool) (int, []byte, error) {
+	i := bytes.IndexAny(data, "\r\n")
+	if i < 0 {
+		if atEOF {
+			return len(data), nil, nil
+		}
+
+		return 0, nil, nil
+	}
+
+	if data[i] == '\n' {
+		return i + 1, data[:i], nil
+	}
+
+	// a CR may be followed by a LF
+	if i+1 < len(data) {
+		if data[i+1] == '\n' {
+			return i + 2, data[:i], nil
+		}
+
+		return i + 1, data[:i], nil
+	}
+
+	if atEOF {
+		return i + 1, data[:i], nil
+	}
+
+	return 0, nil, nil
+}
@@ -0,0 +1,329 @@
+package lazyhttp_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/niksteff/lazyhttp"
+)
+
+func collectEvents(t *testing.T, events <-chan lazyhttp.Event) []lazyhttp.Event {
+	t.Helper()
+
+	var got []lazyhttp.Event
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case e, ok := <-events:
+			if !ok {
+				return got
+			}
+			got = append(got, e)
+		case <-timeout:
+			t.Errorf("expected the events channel to be closed")
+			return got
+		}
+	}
+}
+
+func TestSubscribeParsing(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Accept") != "text/event-stream" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/event-stream; charset=utf-8")
+		_, _ = io.WriteString(w, "\uFEFF: keep alive\n"+
+			"data: first\n\n"+
+			"event: update\r\nid: 1\r\ndata: line 1\r\ndata:line 2\r\ndata\r\n\r\n"+
+			"id\rretry: 1500\rdata:  spaced\r\r"+
+			"retry: 1x\nevent: empty\n\n"+
+			"data: {\"a\": 1}\nunknown: field\n\n"+
+			"data: incomplete\n")
+	}))
+	defer srv.Close()
+
+	req, _ := http.NewRequest(http.MethodGet, srv.URL, nil)
+
+	// the server closes the stream without 204, stop after the first
+	// connection
+	stream := lazyhttp.Subscribe(context.Background(), lazyhttp.New(), req, lazyhttp.SSEConfig{
+		Backoff: func(time.Duration) lazyhttp.Backoff {
+			return lazyhttp.NewNoopBackoff()
+		},
+	})
+
+	got := collectEvents(t, stream.Events())
+	want := []lazyhttp.Event{
+		{Event: "message", Data: "first"},
+		{Event: "update", ID: "1", Data: "line 1\nline 2\n"},
+		{Event: "message", ID: "", Data: " spaced", Retry: 1500 * time.Millisecond},
+		{Event: "message", ID: "", Data: `{"a": 1}`},
+	}
+
+	if len(got) != len(want) {
+		t.Errorf("expected %d events but got: %+v", len(want), got)
+		return
+	}
+
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("expected event %d to be %+v but got: %+v", i, want[i], got[i])
+		}
+	}
+
+	var backoffErr lazyhttp.BackoffError
+	if !errors.As(stream.Err(), &backoffErr) {
+		t.Errorf("expected BackoffError after the connection was lost but got: %+v", stream.Err())
+	}
+}
+
+func TestSubscribeReconnect(t *testing.T) {
+	var mtx sync.Mutex
+	var lastEventIDs []string
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mtx.Lock()
+		lastEventIDs = append(lastEventIDs, r.Header.Get("Last-Event-ID"))
+		n := len(lastEventIDs)
+		mtx.Unlock()
+
+		switch n {
+		case 1:
+			w.Header().Set("Content-Type", "text/event-stream")
+			_, _ = io.WriteString(w, "retry: 10\nid: a\ndata: 1\n\n")
+		case 2:
+			// a failed reconnect is retried as well
+			hj, _ := w.(http.Hijacker)
+			conn, _, _ := hj.Hijack()
+			_ = conn.Close()
+		case 3:
+			w.Header().Set("Content-Type", "text/event-stream")
+			_, _ = io.WriteString(w, "id: b\ndata: 2\n\n")
+		default:
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}))
+	defer srv.Close()
+
+	var mtxRetries sync.Mutex
+	var retries []time.Duration
+
+	req, _ := http.NewRequest(http.MethodGet, srv.URL, nil)
+	stream := lazyhttp.Subscribe(context.Background(), lazyhttp.New(), req, lazyhttp.SSEConfig{
+		Backoff: func(retry time.Duration) lazyhttp.Backoff {
+			mtxRetries.Lock()
+			retries = append(retries, retry)
+			mtxRetries.Unlock()
+
+			return lazyhttp.NewLimitedTriesBackoff(retry, 3)
+		},
+	})
+
+	got := collectEvents(t, stream.Events())
+	if len(got) != 2 || got[0].Data != "1" || got[1].Data != "2" || got[1].ID != "b" {
+		t.Errorf("expected events 1 and 2 but got: %+v", got)
+	}
+
+	if err := stream.Err(); err != nil {
+		t.Errorf("expected the stream to end with 204 without error but got: %+v", err)
+	}
+
+	mtx.Lock()
+	defer mtx.Unlock()
+
+	if len(lastEventIDs) != 4 || lastEventIDs[0] != "" || lastEventIDs[1] != "a" || lastEventIDs[2] != "a" || lastEventIDs[3] != "b" {
+		t.Errorf("expected Last-Event-ID to be sent on reconnect but got: %+v", lastEventIDs)
+	}
+
+	mtxRetries.Lock()
+	defer mtxRetries.Unlock()
+
+	// a backoff per established connection with the retry of the server
+	if len(retries) != 2 || retries[0] != 10*time.Millisecond || retries[1] != 10*time.Millisecond {
+		t.Errorf("expected 2 backoffs with the retry of the server but got: %+v", retries)
+	}
+}
+
+func TestSubscribeReconnectIncompleteEvent(t *testing.T) {
+	var mtx sync.Mutex
+	var lastEventIDs []string
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mtx.Lock()
+		lastEventIDs = append(lastEventIDs, r.Header.Get("Last-Event-ID"))
+		n := len(lastEventIDs)
+		mtx.Unlock()
+
+		if n > 1 {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+		// the connection is lost before the second event is dispatched
+		w.Header().Set("Content-Type", "text/event-stream")
+		_, _ = io.WriteString(w, "retry: 10\nid: 1\ndata: one\n\nid: 2\ndata: two\n")
+	}))
+	defer srv.Close()
+
+	req, _ := http.NewRequest(http.MethodGet, srv.URL, nil)
+	stream := lazyhttp.Subscribe(context.Background(), lazyhttp.New(), req, lazyhttp.SSEConfig{})
+
+	got := collectEvents(t, stream.Events())
+	if len(got) != 1 || got[0].ID != "1" {
+		t.Errorf("expected event 1 but got: %+v", got)
+	}
+
+	mtx.Lock()
+	defer mtx.Unlock()
+
+	// the reconnect asks for the events after the last one delivered
+	if len(lastEventIDs) != 2 || lastEventIDs[1] != "1" {
+		t.Errorf("expected Last-Event-ID 1 on reconnect but got: %+v", lastEventIDs)
+	}
+}
+
+func TestSubscribeErrors(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/json":
+			w.Header().Set("Content-Type", "application/json")
+			_, _ = io.WriteString(w, "{}")
+		default:
+			w.WriteHeader(http.StatusUnauthorized)
+		}
+	}))
+	defer srv.Close()
+
+	req, _ := http.NewRequest(http.MethodGet, srv.URL+"/denied", nil)
+	stream := lazyhttp.Subscribe(context.Background(), lazyhttp.New(), req, lazyhttp.SSEConfig{})
+	collectEvents(t, stream.Events())
+
+	var statusErr lazyhttp.StatusError
+	if !errors.As(stream.Err(), &statusErr) || statusErr.StatusCode != http.StatusUnauthorized {
+		t.Errorf("expected StatusError without reconnecting but got: %+v", stream.Err())
+	}
+
+	req, _ = http.NewRequest(http.MethodGet, srv.URL+"/json", nil)
+	stream = lazyhttp.Subscribe(context.Background(), lazyhttp.New(), req, lazyhttp.SSEConfig{})
+	collectEvents(t, stream.Events())
+
+	if stream.Err() == nil {
+		t.Errorf("expected error for another content type")
+	}
+}
+
+func TestSubscribeCancel(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		_, _ = io.WriteString(w, "data: hello\n\n")
+		w.(http.Flusher).Flush()
+
+		<-r.Context().Done()
+	}))
+	defer srv.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	req, _ := http.NewRequest(http.MethodGet, srv.URL, nil)
+	stream := lazyhttp.Subscribe(ctx, lazyhttp.New(), req, lazyhttp.SSEConfig{})
+
+	select {
+	case e := <-stream.Events():
+		if e.Data != "hello" {
+			t.Errorf("expected hello but got: %+v", e)
+		}
+	case <-time.After(5 * time.Second):
+		t.Errorf("expected an event")
+		return
+	}
+
+	cancel()
+	collectEvents(t, stream.Events())
+
+	if !errors.Is(stream.Err(), context.Canceled) {
+		t.Errorf("expected context canceled but got: %+v", stream.Err())
+	}
+}
+
+func TestSubscribeClientClosed(t *testing.T) {
+	end := make(chan struct{})
+	var connections atomic.Int32
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		connections.Add(1)
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		_, _ = io.WriteString(w, "data: hello\n\n")
+		w.(http.Flusher).Flush()
+
+		// keep the connection until the client was closed
+		<-end
+	}))
+	defer srv.Close()
+
+	client := lazyhttp.New()
+
+	req, _ := http.NewRequest(http.MethodGet, srv.URL, nil)
+	stream := lazyhttp.Subscribe(context.Background(), client, req, lazyhttp.SSEConfig{
+		Backoff: func(time.Duration) lazyhttp.Backoff {
+			return lazyhttp.NewConstantBackoff(time.Millisecond)
+		},
+	})
+
+	select {
+	case <-stream.Events():
+	case <-time.After(5 * time.Second):
+		t.Errorf("expected an event")
+		return
+	}
+
+	// the connection is lost after the client was closed, the reconnect
+	// fails for good
+	_ = client.Close()
+	close(end)
+
+	collectEvents(t, stream.Events())
+
+	if !errors.Is(stream.Err(), lazyhttp.ErrClientClosed) {
+		t.Errorf("expected ErrClientClosed but got: %+v", stream.Err())
+	}
+
+	if connections.Load() != 1 {
+		t.Errorf("expected a single connection but got: %d", connections.Load())
+	}
+}
+
+func TestSubscribeHookError(t *testing.T) {
+	var connections atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		connections.Add(1)
+	}))
+	defer srv.Close()
+
+	client := lazyhttp.New(lazyhttp.WithPreRequestHooks(func(r *http.Request) error {
+		return errors.New("no token")
+	}))
+
+	req, _ := http.NewRequest(http.MethodGet, srv.URL, nil)
+	stream := lazyhttp.Subscribe(context.Background(), client, req, lazyhttp.SSEConfig{})
+	collectEvents(t, stream.Events())
+
+	var hookErr lazyhttp.HookError
+	if !errors.As(stream.Err(), &hookErr) {
+		t.Errorf("expected HookError without reconnecting but got: %+v", stream.Err())
+	}
+
+	if connections.Load() != 0 {
+		t.Errorf("expected no connection but got: %d", connections.Load())
+	}
+}
@@ -0,0 +1,39 @@
+package lazyhttp
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+)
+
+// StatusErrorConfig configures the status errors of the client.
+type StatusErrorConfig struct {
+	IsError     func(*http.Response) bool // decides whether a response is an error, defaults to IsErrorStatus
+	MaxBodySize int                       // max bytes of the body kept in the error, defaults to 1KiB
+}
+
+// IsErrorStatus reports whether the status code of the response is not 2xx.
+func IsErrorStatus(res *http.Response) bool {
+	return res.StatusCode < 200 || res.StatusCode > 299
+}
+
+// checkStatus turns a response with an error status into a StatusError. The
+// start of the body is kept in the error, the rest is discarded and the body
+// is closed. The returned response has a body that replays the kept part, so
+// it is safe to read and to close, but does not have to be closed.
+func (c *client) checkStatus(res *http.Response) (*http.Response, error) {
+	if c.statusErrors == nil || !c.statusErrors.IsError(res) {
+		return res, nil
+	}
+
+	snippet, err := io.ReadAll(io.LimitReader(res.Body, int64(c.statusErrors.MaxBodySize)))
+	discardBody(res.Body)
+	res.Body = io.NopCloser(bytes.NewReader(snippet))
+
+	statusErr := newStatusError(res)
+	statusErr.Header = res.Header
+	statusErr.Body = snippet
+	statusErr.BodyErr = err
+
+	return res, statusErr
+}
@@ -0,0 +1,88 @@
+package lazyhttp_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/niksteff/lazyhttp"
+)
+
+func TestStatusErrors(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/ok":
+			w.WriteHeader(http.StatusOK)
+			_, _ = w.Write([]byte("fine"))
+		case "/missing":
+			w.WriteHeader(http.StatusNotFound)
+		default:
+			w.Header().Set("X-Request-Id", "abc")
+			w.WriteHeader(http.StatusInternalServerError)
+			_, _ = w.Write([]byte(strings.Repeat("x", 100)))
+		}
+	}))
+	defer srv.Close()
+
+	// 404 is expected by the caller and not an error
+	client := lazyhttp.New(
+		lazyhttp.WithStatusErrors(lazyhttp.StatusErrorConfig{
+			IsError: func(res *http.Response) bool {
+				return lazyhttp.IsErrorStatus(res) && res.StatusCode != http.StatusNotFound
+			},
+			MaxBodySize: 10,
+		}),
+	)
+
+	do := func(path string) (*http.Response, error) {
+		req, err := http.NewRequestWithContext(context.Background(), http.MethodGet, srv.URL+path, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		return client.Do(req)
+	}
+
+	for _, path := range []string{"/ok", "/missing"} {
+		res, err := do(path)
+		if err != nil {
+			t.Errorf("did not expect error for %s: %+v", path, err)
+			continue
+		}
+		lazyhttp.NoopBodyCloser(res.Body)
+	}
+
+	res, err := do("/fail")
+
+	var statusErr lazyhttp.StatusError
+	if !errors.As(err, &statusErr) {
+		t.Errorf("expected StatusError but got: %+v", err)
+		return
+	}
+
+	if statusErr.StatusCode != http.StatusInternalServerError {
+		t.Errorf("expected status code %d but got: %d", http.StatusInternalServerError, statusErr.StatusCode)
+	}
+
+	if statusErr.Method != http.MethodGet || statusErr.URL != srv.URL+"/fail" {
+		t.Errorf("expected the request in the error but got: %s %s", statusErr.Method, statusErr.URL)
+	}
+
+	if statusErr.Header.Get("X-Request-Id") != "abc" {
+		t.Errorf("expected the headers in the error but got: %+v", statusErr.Header)
+	}
+
+	if string(statusErr.Body) != strings.Repeat("x", 10) {
+		t.Errorf("expected the body to be cut at 10 bytes but got: %q", statusErr.Body)
+	}
+
+	// the response still replays the start of the body
+	b, err := io.ReadAll(res.Body)
+	if err != nil || string(b) != strings.Repeat("x", 10) {
+		t.Errorf("expected the body to be readable but got: %q, %+v", b, err)
+	}
+}
@@ -0,0 +1,139 @@
+package lazyhttp
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// maxTypedResponseSize is the max size of a response body decoded by the
+// typed helpers.
+const maxTypedResponseSize = 10 << 20
+
+// maxTypedErrorBodySize is the max size of the body kept in the StatusError
+// returned by the typed helpers.
+const maxTypedErrorBodySize = 1 << 10
+
+// Doer executes requests, e.g. the lazyhttp client or an *http.Client.
+type Doer interface {
+	Do(req *http.Request) (*http.Response, error)
+}
+
+// GetJSON sends a GET request and decodes the JSON response into a T:
+//
+//	user, res, err := lazyhttp.GetJSON[User](ctx, client, "/users/42")
+//
+// The body of the returned response is closed already, it is returned for its
+// status and headers. A response with an error status is returned with a
+// ProblemError if it carries problem details and a StatusError otherwise. The
+// body may not exceed 10MiB unless WithMaxBytes sets another limit.
+func GetJSON[T any](ctx context.Context, d Doer, path string, opts ...DecodeOption) (T, *http.Response, error) {
+	var zero T
+
+	req, err := NewRequest(http.MethodGet, path).
+		WithContext(ctx).
+		Build()
+	if err != nil {
+		return zero, nil, RequestError{Err: err}
+	}
+
+	return doJSON[T](d, req, opts)
+}
+
+// PostJSON sends the JSON encoding of body in a POST request and decodes the
+// JSON response into a Res:
+//
+//	post, res, err := lazyhttp.PostJSON[NewPost, Post](ctx, client, "/posts", newPost)
+//
+// The request can be retried. The response is handled like in GetJSON.
+func PostJSON[Req any, Res any](ctx context.Context, d Doer, path string, body Req, opts ...DecodeOption) (Res, *http.Response, error) {
+	var zero Res
+
+	req, err := NewRequest(http.MethodPost, path).
+		WithContext(ctx).
+		JSON(body).
+		Build()
+	if err != nil {
+		return zero, nil, RequestError{Err: err}
+	}
+
+	return doJSON[Res](d, req, opts)
+}
+
+// doJSON executes the request and decodes the response. The body is always
+// closed.
+func doJSON[T any](d Doer, req *http.Request, opts []DecodeOption) (T, *http.Response, error) {
+	var out T
+
+	req.Header.Set("Accept", "application/json")
+
+	res, err := d.Do(req)
+	if err != nil {
+		if res != nil && res.Body != nil {
+			discardBody(res.Body)
+		}
+
+		return out, res, err
+	}
+	defer discardBody(res.Body)
+
+	if IsErrorStatus(res) {
+		return out, res, typedStatusError(res)
+	}
+
+	o := newDecodeOptions(append([]DecodeOption{WithMaxBytes(maxTypedResponseSize)}, opts...))
+
+	b, err := io.ReadAll(o.reader(res.Body))
+	if err != nil {
+		return out, res, ResponseError{
+			Err:      fmt.Errorf("error reading response body: %w", err),
+			Response: res,
+		}
+	}
+
+	// e.g. 204 No Content
+	if len(bytes.TrimSpace(b)) == 0 {
+		return out, res, nil
+	}
+
+	err = o.unmarshal(b, &out)
+	if err != nil {
+		return out, res, ResponseError{
+			Err:      fmt.Errorf("error unmarshaling response body: %w", err),
+			Response: res,
+		}
+	}
+
+	return out, res, nil
+}
+
+// typedStatusError returns the error of a response with an error status, the
+// problem details if the response has them or a StatusError.
+func typedStatusError(res *http.Response) error {
+	var b []byte
+	var err error
+	if IsProblem(res) {
+		b, err = io.ReadAll(io.LimitReader(res.Body, maxProblemSize))
+		if err == nil {
+			problem, parseErr := parseProblem(b, res.StatusCode)
+			if parseErr == nil {
+				return problem
+			}
+		}
+	} else {
+		b, err = io.ReadAll(io.LimitReader(res.Body, maxTypedErrorBodySize))
+	}
+
+	if len(b) > maxTypedErrorBodySize {
+		b = b[:maxTypedErrorBodySize]
+	}
+
+	statusErr := newStatusError(res)
+	statusErr.Header = res.Header
+	statusErr.Body = b
+	statusErr.BodyErr = err
+
+	return statusErr
+}
@@ -0,0 +1,126 @@
+package lazyhttp_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/niksteff/lazyhttp"
+)
+
+type typedUser struct {
+	ID   int    `json:"id"`
+	Name string `json:"name"`
+}
+
+func newTypedServer() *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/users/42":
+			w.Header().Set("Content-Type", "application/json")
+			_, _ = w.Write([]byte(`{"id":42,"name":"gopher"}`))
+		case "/users":
+			var u typedUser
+			_ = json.NewDecoder(r.Body).Decode(&u)
+			u.ID = 7
+
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusCreated)
+			_ = json.NewEncoder(w).Encode(u)
+		case "/empty":
+			w.WriteHeader(http.StatusNoContent)
+		case "/problem":
+			w.Header().Set("Content-Type", "application/problem+json")
+			w.WriteHeader(http.StatusForbidden)
+			_, _ = w.Write([]byte(`{"title":"no credit"}`))
+		case "/large":
+			w.Header().Set("Content-Type", "application/json")
+			_, _ = w.Write([]byte(`"` + strings.Repeat("a", 11<<20) + `"`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			_, _ = w.Write([]byte("not found"))
+		}
+	}))
+}
+
+func TestGetJSON(t *testing.T) {
+	srv := newTypedServer()
+	defer srv.Close()
+
+	host, _ := url.Parse(srv.URL)
+	client := lazyhttp.New(lazyhttp.WithHost(host))
+
+	user, res, err := lazyhttp.GetJSON[typedUser](context.Background(), client, "/users/42")
+	if err != nil {
+		t.Errorf("did not expect error getting user: %+v", err)
+		return
+	}
+
+	if user.ID != 42 || user.Name != "gopher" {
+		t.Errorf("expected user 42 but got: %+v", user)
+	}
+
+	if res.StatusCode != http.StatusOK || res.Header.Get("Content-Type") != "application/json" {
+		t.Errorf("expected response metadata but got: %+v", res)
+	}
+
+	_, res, err = lazyhttp.GetJSON[*typedUser](context.Background(), client, "/empty")
+	if err != nil || res.StatusCode != http.StatusNoContent {
+		t.Errorf("expected no content without error but got: %+v", err)
+	}
+}
+
+func TestPostJSON(t *testing.T) {
+	srv := newTypedServer()
+	defer srv.Close()
+
+	// a plain http client is a Doer, too
+	user, res, err := lazyhttp.PostJSON[typedUser, typedUser](context.Background(), srv.Client(), srv.URL+"/users", typedUser{Name: "gopher"})
+	if err != nil {
+		t.Errorf("did not expect error posting user: %+v", err)
+		return
+	}
+
+	if user.ID != 7 || user.Name != "gopher" || res.StatusCode != http.StatusCreated {
+		t.Errorf("expected created user but got: %+v", user)
+	}
+}
+
+func TestGetJSONErrors(t *testing.T) {
+	srv := newTypedServer()
+	defer srv.Close()
+
+	host, _ := url.Parse(srv.URL)
+	client := lazyhttp.New(lazyhttp.WithHost(host))
+
+	_, _, err := lazyhttp.GetJSON[typedUser](context.Background(), client, "/missing")
+
+	var statusErr lazyhttp.StatusError
+	if !errors.As(err, &statusErr) || statusErr.StatusCode != http.StatusNotFound || string(statusErr.Body) != "not found" {
+		t.Errorf("expected StatusError with body but got: %+v", err)
+	}
+
+	_, _, err = lazyhttp.GetJSON[typedUser](context.Background(), client, "/problem")
+
+	var problem lazyhttp.ProblemError
+	if !errors.As(err, &problem) || problem.Title != "no credit" || problem.Status != http.StatusForbidden {
+		t.Errorf("expected ProblemError but got: %+v", err)
+	}
+
+	_, _, err = lazyhttp.GetJSON[string](context.Background(), client, "/large")
+
+	var responseErr lazyhttp.ResponseError
+	if !errors.As(err, &responseErr) || !errors.Is(err, lazyhttp.ErrBodyTooLarge) {
+		t.Errorf("expected ResponseError for a large body but got: %+v", err)
+	}
+
+	_, _, err = lazyhttp.GetJSON[int](context.Background(), client, "/users/42")
+	if !errors.As(err, &responseErr) {
+		t.Errorf("expected ResponseError for a mismatching type but got: %+v", err)
+	}
+}